@@ -0,0 +1,159 @@
+package ledgrid
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/conn/v3/spi"
+	"periph.io/x/conn/v3/spi/spireg"
+	"periph.io/x/host/v3"
+)
+
+// NeoPixel mit APA102 oder SK9822 werden wie der WS2801 mit Takt- und
+// Datenleitung angesteuert. Jedes Pixel erhaelt 4 Bytes: ein Byte mit 3
+// gesetzten Bits und einer 5 Bit breiten Helligkeit, gefolgt von den
+// Farbwerten (ueblicherweise in der Reihenfolge Blau, Gruen, Rot). Vor den
+// Pixeln wird ein Start-Frame (4 Null-Bytes) gesendet, danach ein End-Frame,
+// mit welchem die Daten bis ans Ende der Kette durchgetaktet werden (jedes
+// Pixel verzoegert die Daten um einen halben Takt). Der SK9822 braucht
+// zudem 4 weitere Null-Bytes, damit die Werte uebernommen werden.
+const (
+	DefAPA102Baud   = 4_000_000
+	apa102MaxBright = 31
+)
+
+// Dies ist die Implementation eines Displayers fuer NeoPixel mit APA102
+// oder SK9822, welche ueber den SPI-Bus eines RaspberryPi angesteuert
+// werden.
+//
+// Die maximalen Helligkeitswerte des GridServers (siehe SetMaxValue)
+// werden ueber das Helligkeitsfeld der Hardware umgesetzt: wird die
+// Helligkeit bspw. auf einen Achtel begrenzt, stehen fuer die Farbwerte
+// trotzdem die vollen 8 Bit zur Verfuegung, was insbesondere bei wenig
+// Licht zu feineren Abstufungen fuehrt.
+type APA102 struct {
+	DisplayEmbed
+	spiPort   spi.PortCloser
+	spiConn   spi.Conn
+	maxTxSize int
+	bright    byte
+	txBuffer  []byte
+}
+
+// Erstellt eine neue Instanz. spiDev ist das Device-File des SPI-Buses, baud
+// die Taktrate (siehe DefAPA102Baud) und modConf die Modul-Konfiguration.
+// Fehler beim Oeffnen des SPI-Buses enthalten ErrDevice.
+func NewAPA102(spiDev string, baud int, modConf conf.ModuleConfig) (*APA102, error) {
+	var err error
+	var maxTxSize int
+
+	_, err = host.Init()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDevice, err)
+	}
+
+	spiPort, err := spireg.Open(spiDev)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
+	}
+	// Wie beim WS2812 wird die maximale Groesse eines Transfers beim
+	// tatsaechlich geoeffneten Port abgefragt.
+	maxTxSize = defSPIMaxTxSize
+	if port, ok := spiPort.(interface{ MaxTxSize() int }); ok {
+		maxTxSize = port.MaxTxSize()
+	}
+	p, err := newAPA102(spiPort, baud, maxTxSize, modConf)
+	if err != nil {
+		spiPort.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
+	}
+	return p, nil
+}
+
+// Erstellt den Displayer auf einem bereits geoeffneten SPI-Port. Damit kann
+// in den Tests ein simulierter Port verwendet werden.
+func newAPA102(spiPort spi.PortCloser, baud, maxTxSize int, modConf conf.ModuleConfig) (*APA102, error) {
+	var err error
+
+	p := &APA102{spiPort: spiPort, maxTxSize: maxTxSize}
+	p.DisplayEmbed.Init(p, modConf.NumLeds())
+	p.SetModuleConfig(modConf)
+	p.SetColorOrder(conf.BGR)
+	p.SetMaxValue(0xff, 0xff, 0xff)
+
+	numLeds := p.NumLeds()
+	p.txBuffer = make([]byte, 4+4*numLeds+4+(numLeds+15)/16)
+
+	p.spiConn, err = p.spiPort.Connect(physic.Frequency(baud)*physic.Hertz,
+		spi.Mode0, 8)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Diese Methode gehoert zum Displayer-Interface und retourniert die
+// empfohlenen Gamma-Werte fuer die drei Farbkanaele Rot, Gruen und Blau.
+func (p *APA102) DefaultGamma() (r, g, b float64) {
+	return 2.5, 2.5, 2.5
+}
+
+// Setzt die maximalen Helligkeitswerte fuer die drei Farben (siehe
+// BrightnessLimiter). Die Helligkeit der Hardware wird so gewaehlt, dass
+// der hellste Kanal gerade noch erreicht werden kann; die Farbwerte werden
+// anschliessend pro Kanal auf den verbleibenden Rest skaliert (nach der
+// Gamma-Korrektur).
+func (p *APA102) SetMaxValue(r, g, b uint8) {
+	var scaleTbl [3][256]byte
+
+	maxVal := max(r, g, b)
+	p.bright = byte((int(maxVal)*apa102MaxBright + 254) / 255)
+	if p.bright > 0 {
+		for colorIdx, val := range [3]uint8{r, g, b} {
+			for i := range 256 {
+				scaleTbl[colorIdx][i] = byte((i*int(val)*apa102MaxBright +
+					255*int(p.bright)/2) / (255 * int(p.bright)))
+			}
+		}
+	}
+	p.setLevels(scaleTbl, float64(p.bright)/apa102MaxBright)
+}
+
+// Schliesst den Displayer, in diesem Fall den SPI-Port.
+func (p *APA102) Close() {
+	p.spiPort.Close()
+}
+
+// Baut das komplette Bild mit Start- und End-Frame auf. Die Farbwerte in
+// buffer sind bereits skaliert und in der Reihenfolge der Module (i.d.R.
+// Blau, Gruen, Rot). Die Null-Bytes des End-Frames werden nie
+// ueberschrieben.
+func (p *APA102) encode(buffer []byte) []byte {
+	dst := p.txBuffer[4:]
+	for i := 0; i+3 <= len(buffer) && len(dst) >= 4; i += 3 {
+		dst[0] = 0xe0 | p.bright
+		copy(dst[1:4], buffer[i:i+3])
+		dst = dst[4:]
+	}
+	return p.txBuffer
+}
+
+// Sendet die Farbwerte in buffer via SPI-Bus zur NeoPixel Lichterkette. Die
+// Reihenfolge der Pixel muss bereits vorgaengig der effektiven Verkabelung
+// angepasst worden sein, ebenso die Farbwertkorrektur. Diese Methode wird
+// ueblicherweise vom DisplayEmbed und nicht von Benutzercode aufgerufen.
+func (p *APA102) Send(buffer []byte) {
+	var err error
+
+	txBuffer := p.encode(buffer)
+	for idx := 0; idx < len(txBuffer); idx += p.maxTxSize {
+		txSize := min(p.maxTxSize, len(txBuffer)-idx)
+		if err = p.spiConn.Tx(txBuffer[idx:idx+txSize:idx+txSize], nil); err != nil {
+			log.Printf("Couldn't send data: %v", err)
+			return
+		}
+	}
+}
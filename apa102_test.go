@@ -0,0 +1,63 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/spi/spitest"
+)
+
+func TestAPA102Frame(t *testing.T) {
+	port := &spitest.Record{}
+	disp, err := newAPA102(port, DefAPA102Baud, 100, testModConf(image.Point{20, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disp.SetGamma(1.0, 1.0, 1.0)
+	server := newTestServer(disp)
+	numLeds := disp.NumLeds()
+
+	sent := func() []byte {
+		var b []byte
+		for _, op := range port.Ops {
+			b = append(b, op.W...)
+		}
+		port.Ops = nil
+		return b
+	}
+
+	frame := make([]byte, 3*numLeds)
+	frame[0], frame[1], frame[2] = 0x10, 0x80, 0xff
+	disp.Display(frame)
+	b := sent()
+	if size := 4 + 4*numLeds + 4 + (numLeds+15)/16; len(b) != size {
+		t.Fatalf("expected %d bytes, got %d", size, len(b))
+	}
+	if !bytes.Equal(b[:4], []byte{0, 0, 0, 0}) {
+		t.Errorf("bad start frame: %x", b[:4])
+	}
+	if !bytes.Equal(b[4:8], []byte{0xff, 0xff, 0x80, 0x10}) {
+		t.Errorf("first pixel: %x", b[4:8])
+	}
+	if !bytes.Equal(b[4+4*numLeds:], make([]byte, len(b)-4-4*numLeds)) {
+		t.Errorf("bad end frame")
+	}
+
+	// Eine Begrenzung auf einen Viertel wird ueber das Helligkeitsfeld
+	// umgesetzt, die Farbwerte behalten ihre Aufloesung.
+	server.SetMaxValue(64, 64, 32)
+	disp.Display(frame)
+	b = sent()
+	if !bytes.Equal(b[4:8], []byte{0xe0 | 8, 0x7c, 0x7c, 0x10}) {
+		t.Errorf("first pixel with max value: %x", b[4:8])
+	}
+
+	// Die Strombegrenzung beruecksichtigt das Helligkeitsfeld.
+	want := float64(numLeds)*DefIdleCurrent +
+		DefChannelCurrent*float64(0x7c+0x7c+0x10)/255.0*8.0/apa102MaxBright
+	if stats := disp.PowerStats(); math.Abs(stats.Requested-want) > 0.01 {
+		t.Errorf("expected %.2f mA, got %.2f mA", want, stats.Requested)
+	}
+}
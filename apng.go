@@ -0,0 +1,154 @@
+package ledgrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+// APNG (animated PNG) is a PNG file with additional chunks: acTL announces
+// the number of frames, fcTL precedes every frame and the image data of all
+// frames but the first one is stored in fdAT chunks. Viewers without APNG
+// support show the first frame. The standard library can't write APNG, but
+// since the frames have the same size and color type, the chunks of PNG
+// files encoded with image/png can be reused.
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+type apngWriter struct {
+	w      io.Writer
+	delay  time.Duration
+	enc    png.Encoder
+	frames [][]byte
+}
+
+// Collects the frames and writes them as APNG to w when the writer is
+// closed. Unlike GIF, the colors are not reduced. The frames are shown for
+// delay each (with a resolution of 1ms).
+func NewAPNGWriter(w io.Writer, delay time.Duration) FrameWriter {
+	return &apngWriter{w: w, delay: delay,
+		enc: png.Encoder{CompressionLevel: png.BestSpeed}}
+}
+
+func (w *apngWriter) WriteFrame(img *image.RGBA) error {
+	var buf bytes.Buffer
+
+	if err := w.enc.Encode(&buf, img); err != nil {
+		return err
+	}
+	w.frames = append(w.frames, buf.Bytes())
+	return nil
+}
+
+// A chunk of a PNG file.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// Splits a PNG file into its chunks.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return nil, fmt.Errorf("%w: not a PNG file", ErrBadFormat)
+	}
+	data = data[len(pngHeader):]
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			break
+		}
+		chunks = append(chunks, pngChunk{string(data[4:8]), data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: truncated PNG file", ErrBadFormat)
+	}
+	return chunks, nil
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var buf [4]byte
+
+	binary.BigEndian.PutUint32(buf[:], uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	for _, b := range [][]byte{buf[:], []byte(typ), data,
+		binary.BigEndian.AppendUint32(nil, crc.Sum32())} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the content of a fcTL chunk for a frame with the size given in
+// ihdr, shown for delay milliseconds.
+func fctlData(seq uint32, ihdr []byte, delay uint16) []byte {
+	data := binary.BigEndian.AppendUint32(nil, seq)
+	data = append(data, ihdr[0:8]...)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = binary.BigEndian.AppendUint16(data, delay)
+	data = binary.BigEndian.AppendUint16(data, 1000)
+	return append(data, 0, 0)
+}
+
+func (w *apngWriter) Close() error {
+	var seq uint32
+
+	if len(w.frames) == 0 {
+		return nil
+	}
+	if _, err := io.WriteString(w.w, pngHeader); err != nil {
+		return err
+	}
+	delay := uint16(min(w.delay.Milliseconds(), 0xffff))
+	for i, frame := range w.frames {
+		chunks, err := pngChunks(frame)
+		if err != nil {
+			return err
+		}
+		if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+			return fmt.Errorf("%w: PNG file without IHDR", ErrBadFormat)
+		}
+		ihdr := chunks[0].data
+		if i == 0 {
+			if err = writeChunk(w.w, "IHDR", ihdr); err != nil {
+				return err
+			}
+			actl := binary.BigEndian.AppendUint32(nil, uint32(len(w.frames)))
+			actl = binary.BigEndian.AppendUint32(actl, 0)
+			if err = writeChunk(w.w, "acTL", actl); err != nil {
+				return err
+			}
+		}
+		if err = writeChunk(w.w, "fcTL", fctlData(seq, ihdr, delay)); err != nil {
+			return err
+		}
+		seq++
+		for _, chunk := range chunks {
+			if chunk.typ != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = writeChunk(w.w, "IDAT", chunk.data)
+			} else {
+				fdat := binary.BigEndian.AppendUint32(nil, seq)
+				err = writeChunk(w.w, "fdAT", append(fdat, chunk.data...))
+				seq++
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeChunk(w.w, "IEND", nil)
+}
@@ -0,0 +1,240 @@
+package ledgrid
+
+import (
+	"sync"
+	"time"
+)
+
+// Several clients may send frames to the GridServer at the same time (TCP,
+// UDP, OPC, E1.31, Art-Net). Every one of them is registered as a
+// FrameSource with a priority and only the active source with the highest
+// priority is shown. Sources with equal priority are served on a first come,
+// first served basis. The frames of the other sources are kept, so that
+// a lower source resumes immediately with its latest frame, as soon as the
+// higher one leaves.
+//
+// A source is active from its first frame until it is closed. With a timeout
+// greater than 0, a source which hasn't sent a frame for this time is no
+// longer active. If no source is active, the idle frame (black by default)
+// is shown.
+type Priority uint8
+
+const (
+	MinPriority Priority = 0
+	// The default priority of all sources. It is the same as the default
+	// priority of E1.31.
+	DefPriority Priority = 100
+	MaxPriority Priority = 255
+)
+
+// FrameSource is the handle of a single source, returned by
+// GridServer.OpenSource.
+type FrameSource struct {
+	arb    *arbiter
+	name   string
+	prio   Priority
+	frame  []byte
+	seen   time.Time
+	closed bool
+}
+
+// Describes a source, as returned by GridServer.Sources.
+type SourceInfo struct {
+	Name     string   `json:"name"`
+	Priority Priority `json:"priority"`
+	Active   bool     `json:"active"`
+	Shown    bool     `json:"shown"`
+}
+
+type arbiter struct {
+	server    *GridServer
+	mutex     sync.Mutex
+	timeout   time.Duration
+	timer     *time.Timer
+	sources   []*FrameSource
+	current   *FrameSource
+	idleFrame []byte
+}
+
+func newArbiter(server *GridServer) *arbiter {
+	a := &arbiter{server: server}
+	a.idleFrame = make([]byte, server.bufferSize)
+	a.timer = time.AfterFunc(time.Hour, a.expire)
+	a.timer.Stop()
+	return a
+}
+
+// Registers a new source with the given name (only used for information)
+// and priority. The source must be closed, when the client disconnects.
+func (p *GridServer) OpenSource(name string, prio Priority) *FrameSource {
+	a := p.arbiter
+	s := &FrameSource{arb: a, name: name, prio: prio}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.sources = append(a.sources, s)
+	return s
+}
+
+// Returns the timeout after which a silent source is no longer active.
+func (p *GridServer) IdleTimeout() time.Duration {
+	a := p.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.timeout
+}
+
+// Sets the timeout after which a silent source is no longer active. A value
+// of 0 (the default) disables the timeout: sources stay active until they
+// are closed.
+func (p *GridServer) SetIdleTimeout(timeout time.Duration) {
+	a := p.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.timeout = max(0, timeout)
+	a.update()
+}
+
+// Sets the frame which is shown, when no source is active.
+func (p *GridServer) SetIdleFrame(frame []byte) {
+	a := p.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	clear(a.idleFrame)
+	copy(a.idleFrame, frame)
+	if a.current == nil {
+		a.show(a.idleFrame)
+	}
+}
+
+// Calls f with the mutex of the arbiter held. As the displayer of the
+// server is only called by the arbiter (see show), all changes of its
+// settings (gamma, calibration, power limiter, LED status, etc.) must be
+// made this way.
+func (p *GridServer) withDisplay(f func()) {
+	a := p.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	f()
+}
+
+// Returns the list of all registered sources in the order of registration.
+func (p *GridServer) Sources() []SourceInfo {
+	a := p.arbiter
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	infoList := make([]SourceInfo, len(a.sources))
+	for i, s := range a.sources {
+		infoList[i] = SourceInfo{Name: s.name, Priority: s.prio,
+			Active: a.isActive(s, now), Shown: s == a.current}
+	}
+	return infoList
+}
+
+func (s *FrameSource) Name() string {
+	return s.name
+}
+
+func (s *FrameSource) Priority() Priority {
+	s.arb.mutex.Lock()
+	defer s.arb.mutex.Unlock()
+	return s.prio
+}
+
+// Changes the priority of the source. This may change the shown source.
+func (s *FrameSource) SetPriority(prio Priority) {
+	a := s.arb
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if s.prio == prio {
+		return
+	}
+	s.prio = prio
+	a.update()
+}
+
+// Passes a new frame of this source to the arbiter. The frame is shown
+// immediately, if this source has the highest priority. Frames of closed
+// sources are ignored.
+func (s *FrameSource) Display(frame []byte) {
+	a := s.arb
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.frame = append(s.frame[:0], frame...)
+	s.seen = time.Now()
+	if s == a.current {
+		a.show(s.frame)
+		if a.timeout > 0 {
+			a.timer.Reset(a.timeout)
+		}
+		return
+	}
+	a.update()
+}
+
+// Removes the source. If it was shown, the next active source takes over
+// or the idle frame is shown.
+func (s *FrameSource) Close() {
+	a := s.arb
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for i, src := range a.sources {
+		if src == s {
+			a.sources = append(a.sources[:i], a.sources[i+1:]...)
+			break
+		}
+	}
+	a.update()
+}
+
+func (a *arbiter) isActive(s *FrameSource, now time.Time) bool {
+	return s.frame != nil && (a.timeout == 0 || now.Sub(s.seen) < a.timeout)
+}
+
+// Determines the source to be shown and shows its latest frame, if it has
+// changed. Must be called with the mutex held.
+func (a *arbiter) update() {
+	var best *FrameSource
+
+	now := time.Now()
+	for _, s := range a.sources {
+		if a.isActive(s, now) && (best == nil || s.prio > best.prio) {
+			best = s
+		}
+	}
+	if best != a.current {
+		a.current = best
+		if best != nil {
+			a.show(best.frame)
+		} else {
+			a.show(a.idleFrame)
+		}
+	}
+	if a.timeout > 0 && best != nil {
+		a.timer.Reset(a.timeout - now.Sub(best.seen))
+	} else {
+		a.timer.Stop()
+	}
+}
+
+func (a *arbiter) expire() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.update()
+}
+
+func (a *arbiter) show(frame []byte) {
+	p := a.server
+	p.stopwatch.Start()
+	p.Disp.Display(frame)
+	p.sentBytes += ByteCount(len(frame))
+	p.stopwatch.Stop()
+}
@@ -0,0 +1,159 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"net"
+	"testing"
+	"time"
+)
+
+func expectNoFrame(t *testing.T, disp *testDisplayer) {
+	t.Helper()
+	select {
+	case <-disp.frames:
+		t.Errorf("unexpected frame")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Erstellt einen Client, welcher via net.Pipe mit server verbunden ist.
+func newPipeClient(t *testing.T, server *GridServer) *NetGridClient {
+	srvConn, cltConn := net.Pipe()
+	go server.HandleMessage(srvConn)
+	client := &NetGridClient{conn: cltConn, network: "tcp",
+		encoder: NewFrameEncoder(EncRaw, 1), stopwatch: NewStopwatch()}
+	if err := client.handshake(cltConn); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestArbiterPriority(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	size := 3 * disp.NumLeds()
+	background, operator := testFrame(size, 1), testFrame(size, 2)
+
+	show := server.OpenSource("show", 10)
+	show.Display(background)
+	expectFrame(t, disp, background)
+
+	// Der Operator uebersteuert die Show, deren Bilder werden nicht mehr
+	// angezeigt.
+	op := server.OpenSource("operator", 200)
+	op.Display(operator)
+	expectFrame(t, disp, operator)
+	show.Display(testFrame(size, 3))
+	expectNoFrame(t, disp)
+
+	// Sobald der Operator geht, wird das letzte Bild der Show angezeigt.
+	op.Close()
+	expectFrame(t, disp, testFrame(size, 3))
+	show.Close()
+	expectFrame(t, disp, make([]byte, size))
+	op.Display(operator)
+	expectNoFrame(t, disp)
+
+	// Bei gleicher Prioritaet bleibt die erste Quelle aktiv.
+	s1 := server.OpenSource("s1", DefPriority)
+	s2 := server.OpenSource("s2", DefPriority)
+	s1.Display(background)
+	expectFrame(t, disp, background)
+	s2.Display(operator)
+	expectNoFrame(t, disp)
+	s2.SetPriority(DefPriority + 1)
+	expectFrame(t, disp, operator)
+	if info := server.Sources(); len(info) != 2 || info[0].Shown || !info[1].Shown {
+		t.Errorf("unexpected sources: %+v", info)
+	}
+	s1.Close()
+	s2.Close()
+	expectFrame(t, disp, make([]byte, size))
+}
+
+func TestArbiterTimeout(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	size := 3 * disp.NumLeds()
+	idle := testFrame(size, 7)
+
+	server.SetIdleFrame(idle)
+	expectFrame(t, disp, idle)
+	server.SetIdleTimeout(200 * time.Millisecond)
+
+	low := server.OpenSource("low", 10)
+	high := server.OpenSource("high", 20)
+	low.Display(testFrame(size, 1))
+	expectFrame(t, disp, testFrame(size, 1))
+	high.Display(testFrame(size, 2))
+	expectFrame(t, disp, testFrame(size, 2))
+
+	// Die Quelle mit hoher Prioritaet sendet nichts mehr, die Quelle mit
+	// tiefer Prioritaet bleibt aktiv und uebernimmt nach dem Timeout.
+	time.Sleep(100 * time.Millisecond)
+	low.Display(testFrame(size, 3))
+	expectFrame(t, disp, testFrame(size, 3))
+
+	// Schweigen alle Quellen, wird das Idle-Bild angezeigt.
+	expectFrame(t, disp, idle)
+	low.Close()
+	high.Close()
+	expectNoFrame(t, disp)
+}
+
+func TestPriorityHello(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	size := 3 * disp.NumLeds()
+
+	show := server.OpenSource("show", DefPriority)
+	show.Display(testFrame(size, 1))
+	expectFrame(t, disp, testFrame(size, 1))
+
+	client := newPipeClient(t, server)
+	client.SetPriority(DefPriority + 50)
+	client.Send(testFrame(size, 2))
+	expectFrame(t, disp, testFrame(size, 2))
+	client.SetPriority(DefPriority - 50)
+	expectFrame(t, disp, testFrame(size, 1))
+	client.Close()
+	show.Close()
+	expectFrame(t, disp, make([]byte, size))
+}
+
+// Das Testmuster uebersteuert alle Quellen; wird es ausgeschaltet, wird
+// wieder das letzte Bild der Quelle angezeigt.
+func TestTestPattern(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{10, 10}))
+	server := newTestServer(disp)
+	size := 3 * disp.NumLeds()
+
+	show := server.OpenSource("show", DefPriority)
+	show.Display(testFrame(size, 1))
+	expectFrame(t, disp, testFrame(size, 1))
+	if !server.ToggleTestPattern() {
+		t.Fatal("expected test pattern to be on")
+	}
+	if b := <-disp.frames; b[0] != 0xff || b[1] != 0 || b[2] != 0 || b[3] != 0 {
+		t.Errorf("unexpected first test frame: %x", b[:6])
+	}
+	show.Display(testFrame(size, 2))
+	if server.ToggleTestPattern() {
+		t.Fatal("expected test pattern to be off")
+	}
+	want := testFrame(size, 2)
+	for {
+		select {
+		case b := <-disp.frames:
+			if b[0] != 0xff && !bytes.Equal(b, want) {
+				t.Fatalf("unexpected frame %x", b[:6])
+			}
+			if bytes.Equal(b, want) {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("source not shown after the test pattern")
+		}
+	}
+}
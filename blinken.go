@@ -2,12 +2,12 @@ package ledgrid
 
 import (
 	"encoding/xml"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"strconv"
 	"time"
@@ -68,23 +68,25 @@ type BlinkenFrame struct {
 // Mit folgender Funktion wird eine Datei im BlinkenLight-Format eingelesen.
 // Die Bilddaten werden dabei noch nicht decodiert, d.h. noch nicht in ein
 // 'image'-Format umgewandelt (siehe dazu auch die Methode [Decode]).
-func ReadBlinkenFile(fileName string) *BlinkenFile {
+// Fehler beim Lesen der Datei werden unveraendert retourniert, ungueltige
+// Inhalte mit ErrBadFormat.
+func ReadBlinkenFile(fileName string) (*BlinkenFile, error) {
 	b := &BlinkenFile{Channels: 1}
 
 	xmlFile, err := os.Open(fileName)
 	if err != nil {
-		log.Fatalf("Couldn't open file '%s': %v", fileName, err)
+		return nil, err
 	}
 	defer xmlFile.Close()
 
 	byteValue, err := ioutil.ReadAll(xmlFile)
 	if err != nil {
-		log.Fatalf("Couldn't read content of file: %v", err)
+		return nil, err
 	}
 
 	err = xml.Unmarshal(byteValue, b)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %s: %w", ErrBadFormat, fileName, err)
 	}
 
 	numberWidth := b.Bits / 4
@@ -98,10 +100,15 @@ func ReadBlinkenFile(fileName string) *BlinkenFile {
 			for k := 0; k < b.Width; k++ {
 				for l := range b.Channels {
 					idx := k*numberWidth*b.Channels + l*numberWidth
+					if idx+numberWidth > len(row) {
+						return nil, fmt.Errorf("%w: %s: row %d of frame %d too short",
+							ErrBadFormat, fileName, j, i)
+					}
 					val := row[idx : idx+numberWidth]
 					v, err := strconv.ParseUint(string(val), 16, b.Bits)
 					if err != nil {
-						log.Fatalf("Cannot parse '%s': %v", string(val), err)
+						return nil, fmt.Errorf("%w: %s: cannot parse '%s': %w",
+							ErrBadFormat, fileName, string(val), err)
 					}
 					idx = k*b.Channels + l
 					b.Frames[i].Values[j][idx] = uint8(v)
@@ -109,7 +116,7 @@ func ReadBlinkenFile(fileName string) *BlinkenFile {
 			}
 		}
 	}
-	return b
+	return b, nil
 }
 
 // Retourniert die Anzahl Frames in der BlinkenLight-Animation.
@@ -0,0 +1,71 @@
+package ledgrid
+
+import (
+	"math"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// The color correction of a calibration (see conf.Calibration) is applied
+// in Display, before the gamma correction. The matrices are stored as fixed
+// point numbers with 16 fractional bits.
+type calMatrix [3][3]int32
+
+// Displayers which correct the colors of single modules or LEDs implement
+// this interface. DisplayEmbed provides an implementation.
+type Calibrator interface {
+	Calibration() conf.Calibration
+	SetCalibration(cal conf.Calibration) error
+}
+
+// Returns the currently used calibration.
+func (d *DisplayEmbed) Calibration() conf.Calibration {
+	return d.calib
+}
+
+// Sets a new calibration. Returns an error with conf.ErrBadConfig, if the
+// calibration doesn't match the module configuration (see
+// conf.Calibration.Verify).
+func (d *DisplayEmbed) SetCalibration(cal conf.Calibration) error {
+	if err := cal.Verify(d.ModConf); err != nil {
+		return err
+	}
+	d.calib = cal
+	d.updateCalibration()
+	return nil
+}
+
+// Computes the fixed point matrices of all LEDs. The new table is only
+// assigned when complete, Display never sees a partial table.
+func (d *DisplayEmbed) updateCalibration() {
+	var calTbl []*calMatrix
+
+	for idx, m := range d.calib.Matrices(d.ModConf) {
+		if m == nil || idx >= d.numLeds {
+			continue
+		}
+		if calTbl == nil {
+			calTbl = make([]*calMatrix, d.numLeds)
+		}
+		cm := &calMatrix{}
+		for i := range m {
+			for j := range m[i] {
+				cm[i][j] = int32(math.Round(m[i][j] * 65536.0))
+			}
+		}
+		calTbl[idx] = cm
+	}
+	d.calTbl = calTbl
+}
+
+// Applies the correction of matrix m to the color in src.
+func (m *calMatrix) apply(src []byte) [3]byte {
+	var dst [3]byte
+
+	for i := range dst {
+		v := (m[i][0]*int32(src[0]) + m[i][1]*int32(src[1]) +
+			m[i][2]*int32(src[2]) + 1<<15) >> 16
+		dst[i] = byte(min(max(v, 0), 255))
+	}
+	return dst
+}
@@ -0,0 +1,71 @@
+package ledgrid
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// Die Korrektur wird vor der Gamma-Korrektur angewendet, nur auf die
+// kalibrierten Module.
+func TestCalibration(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	disp.SetGamma(2.0, 2.0, 2.0)
+	cal := conf.Calibration{}
+	cal.SetModule(conf.ModulePosition{Col: 1}, conf.ColorCorrection{Gain: [3]float64{0.5, 1.0, 1.0}})
+	if err := disp.SetCalibration(cal); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := make([]byte, 3*disp.NumLeds())
+	for i := range frame {
+		frame[i] = 200
+	}
+	disp.Display(frame)
+	b := <-disp.frames
+	modSize := conf.ModuleDim.X * conf.ModuleDim.Y
+	want := disp.gammaTbl[0][100]
+	if b[0] != disp.gammaTbl[0][200] || b[3*modSize] != want ||
+		b[3*modSize+1] != disp.gammaTbl[1][200] {
+		t.Errorf("got %d, %d, %d", b[0], b[3*modSize], b[3*modSize+1])
+	}
+
+	cal.SetModule(conf.ModulePosition{Col: 7, Row: 7}, conf.ColorCorrection{})
+	if err := disp.SetCalibration(cal); err == nil {
+		t.Error("expected error for unknown module")
+	}
+}
+
+// Die Kalibrierung kann via Server geaendert werden, waehrend Bilder
+// angezeigt werden.
+func TestCalibrationConcurrent(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	cal := conf.Calibration{}
+	cal.SetModule(conf.ModulePosition{Col: 1}, conf.ColorCorrection{Gain: [3]float64{0.5, 1.0, 1.0}})
+
+	const numFrames = 100
+	done := make(chan bool)
+	go func() {
+		for range numFrames {
+			<-disp.frames
+		}
+		done <- true
+	}()
+	go func() {
+		for i := range numFrames {
+			if i%2 == 0 {
+				server.SetCalibration(cal)
+			} else {
+				server.SetCalibration(conf.Calibration{})
+			}
+		}
+	}()
+	src := server.OpenSource("test", DefPriority)
+	frame := testFrame(3*disp.NumLeds(), 1)
+	for range numFrames {
+		src.Display(frame)
+	}
+	<-done
+}
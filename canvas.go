@@ -2,9 +2,9 @@ package ledgrid
 
 import (
 	"container/list"
+	"fmt"
 	"image"
 	"image/color"
-	"log"
 	"math"
 	"os"
 	"sync"
@@ -741,22 +741,32 @@ type Image struct {
 
 // Erzeugt ein neues Bild aus der Datei fileName und platziert es bei pos.
 // Pos wird per Default als Koordinaten des Mittelpunktes interpretiert.
-func NewImage(pos geom.Point, fileName string) *Image {
+// Fehler beim Einlesen der Datei werden wie bei LoadImage retourniert.
+func NewImage(pos geom.Point, fileName string) (*Image, error) {
+	img, err := LoadImage(fileName)
+	if err != nil {
+		return nil, err
+	}
 	i := &Image{}
 	i.Pos = pos
 	i.CanvasObjectEmbed.Extend(i)
-	i.Img = LoadImage(fileName)
+	i.Img = img
 	i.ax, i.ay = 0.5, 0.5
 	i.Mask = NewMyUniform(0xff)
-	return i
+	return i, nil
 }
 
 func (i *Image) AlphaPtr() *uint8 {
 	return &i.Mask.C.A
 }
 
-func (i *Image) Read(fileName string) {
-	i.Img = LoadImage(fileName)
+func (i *Image) Read(fileName string) error {
+	img, err := LoadImage(fileName)
+	if err != nil {
+		return err
+	}
+	i.Img = img
+	return nil
 }
 
 func (i *Image) Draw(c *Canvas) {
@@ -784,19 +794,27 @@ func (i *Image) Draw(c *Canvas) {
 		&draw.Options{DstMask: i.Mask})
 }
 
-func LoadImage(fileName string) draw.Image {
+// Liest das Bild aus der Datei fileName. Fehler beim Oeffnen werden
+// unveraendert retourniert (bspw. fs.ErrNotExist), kann das Bild nicht
+// dekodiert werden, enthaelt der Fehler ErrBadFormat.
+func LoadImage(fileName string) (draw.Image, error) {
 	var img image.Image
 
 	fh, err := os.Open(fileName)
 	if err != nil {
-		log.Fatalf("Couldn't open file: %v", err)
+		return nil, err
 	}
 	defer fh.Close()
 	img, _, err = image.Decode(fh)
 	if err != nil {
-		log.Fatalf("Couldn't decode image: %v", err)
+		return nil, fmt.Errorf("%w: %s: %w", ErrBadFormat, fileName, err)
+	}
+	dst, ok := img.(draw.Image)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: unsupported image type %T",
+			ErrBadFormat, fileName, img)
 	}
-	return img.(draw.Image)
+	return dst, nil
 }
 
 // Mit ImageList (TO DO: besserer Name waere wohl schon Sprite) lassen sich
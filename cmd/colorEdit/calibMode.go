@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	gc "github.com/gbin/goncurses"
+	"github.com/stefan-muehlebach/ledgrid"
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// Im Kalibrierungsmodus werden alle Module weiss dargestellt. Fuer das
+// ausgewaehlte Modul werden die Verstaerkungen der drei Farben so lange
+// angepasst, bis dessen Weiss mit dem des Referenzmoduls uebereinstimmt.
+// Die Kalibrierung wird laufend an den Controller uebertragen und kann mit
+// [Ctrl]-s in der Datei fileName gespeichert werden (siehe
+// conf.Calibration).
+func CalibMode(client *ledgrid.NetGridClient, ledGrid *ledgrid.LedGrid, fileName string) {
+	var ch gc.Key
+	var modIdx, refIdx int
+	var level = 0xc0
+	var onlyPair bool
+	var message string
+
+	modConf := client.ModuleConfig()
+
+	cal, err := client.Calibration()
+	if err != nil {
+		log.Fatalf("Couldn't read calibration: %v", err)
+	}
+
+	gain := func(idx int) [3]float64 {
+		corr, ok := cal.Module(modConf[idx])
+		if !ok || corr.Gain == [3]float64{} {
+			return [3]float64{1.0, 1.0, 1.0}
+		}
+		return corr.Gain
+	}
+	setGain := func(idx int, g [3]float64) {
+		corr, _ := cal.Module(modConf[idx])
+		corr.Gain = g
+		cal.SetModule(modConf[idx], corr)
+		if err := client.SetCalibration(cal); err != nil {
+			message = fmt.Sprintf("Couldn't set calibration: %v", err)
+		}
+	}
+	show := func() {
+		for i, m := range modConf {
+			val := uint8(level)
+			if onlyPair && i != modIdx && i != refIdx {
+				val = 0
+			}
+			for j := 3 * m.Idx; j < 3*(m.Idx+m.NumLeds()); j++ {
+				ledGrid.Pix[j] = val
+			}
+		}
+		if err := client.Send(ledGrid.Pix); err != nil {
+			message = fmt.Sprintf("Couldn't send data: %v", err)
+		}
+	}
+
+	stdscr, err := gc.Init()
+	if err != nil {
+		log.Fatalf("Couldn't Init ncurses: %v", err)
+	}
+	defer gc.End()
+	gc.Echo(false)
+	gc.CBreak(true)
+	gc.Cursor(0)
+	stdscr.Keypad(true)
+
+	termHeight, _ := stdscr.MaxYX()
+	listHeight := max(termHeight-16, 3)
+	win, err := gc.NewWindow(listHeight+14, 70, 1, 4)
+	if err != nil {
+		log.Fatalf("Couldn't create window: %v", err)
+	}
+	win.Keypad(true)
+
+	show()
+	for {
+		win.Erase()
+		win.Box(0, 0)
+		win.MovePrintf(1, 2, "Calibration of %d modules, white level: %02X", len(modConf), level)
+		win.MovePrintf(2, 2, "  Module      Gain R  Gain G  Gain B")
+		first := max(0, min(modIdx-listHeight/2, len(modConf)-listHeight))
+		for row := 0; row < listHeight && first+row < len(modConf); row++ {
+			idx := first + row
+			g := gain(idx)
+			mark := ' '
+			if idx == refIdx {
+				mark = '*'
+			}
+			if idx == modIdx {
+				win.AttrOn(gc.A_REVERSE)
+			}
+			win.MovePrintf(3+row, 2, "%c (%2d,%2d)     %.2f    %.2f    %.2f", mark,
+				modConf[idx].Col, modConf[idx].Row, g[0], g[1], g[2])
+			win.AttrOff(gc.A_REVERSE)
+		}
+		row := listHeight + 4
+		win.MovePrintf(row+0, 2, "[Ins]/[Del], [Home]/[End], [PgUp]/[PgDn]: R, G, B gain +/- 0.01")
+		win.MovePrintf(row+1, 2, "[Alt]: +/- 0.05            [Up]/[Down]: select module")
+		win.MovePrintf(row+2, 2, "r: use as reference (*)    x: reset gains of module")
+		win.MovePrintf(row+3, 2, "+/-: change white level    i: show only module and reference")
+		win.MovePrintf(row+4, 2, "[Ctrl]-s: save to '%s'     q: quit", fileName)
+		win.MovePrintf(row+6, 2, "%s", message)
+		win.Refresh()
+
+		ch = win.GetChar()
+		message = ""
+		g := gain(modIdx)
+		step := 0.01
+		switch ch {
+		case KEY_AINS, KEY_ADEL, KEY_AHOME, KEY_AEND, KEY_APAGEUP, KEY_APAGEDOWN:
+			step = 0.05
+		}
+
+		switch ch {
+		case 'q':
+			return
+		case gc.KEY_UP:
+			if modIdx > 0 {
+				modIdx--
+			}
+		case gc.KEY_DOWN:
+			if modIdx < len(modConf)-1 {
+				modIdx++
+			}
+		case 'r':
+			refIdx = modIdx
+		case 'x':
+			g = [3]float64{1.0, 1.0, 1.0}
+		case 'i':
+			onlyPair = !onlyPair
+		case '+':
+			level = min(level+16, 0xf0)
+		case '-':
+			level = max(level-16, 0x10)
+		case gc.KEY_IC, KEY_AINS:
+			g[0] += step
+		case gc.KEY_DC, KEY_ADEL:
+			g[0] -= step
+		case gc.KEY_HOME, KEY_AHOME:
+			g[1] += step
+		case gc.KEY_END, KEY_AEND:
+			g[1] -= step
+		case gc.KEY_PAGEUP, KEY_APAGEUP:
+			g[2] += step
+		case gc.KEY_PAGEDOWN, KEY_APAGEDOWN:
+			g[2] -= step
+		case Ctrl('s'):
+			if err := cal.Save(fileName); err != nil {
+				message = fmt.Sprintf("Couldn't save calibration: %v", err)
+			} else {
+				message = fmt.Sprintf("Calibration saved to '%s'", fileName)
+			}
+		}
+		if g != gain(modIdx) {
+			for i := range g {
+				g[i] = min(max(g[i], 0.0), 2.0)
+			}
+			setGain(modIdx, g)
+		}
+		show()
+	}
+}
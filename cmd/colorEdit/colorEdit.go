@@ -99,7 +99,7 @@ func LogMouseEvent(log *os.File, event *gc.MouseEvent) {
 func main() {
 	var host string = "raspi-3"
 	var width, height int
-	var tcpPort, rpcPort uint
+	var dataPort, rpcPort uint
 
 	var logFile *os.File
 	var stdscr *gc.Window
@@ -131,15 +131,32 @@ func main() {
 	var clipRect image.Rectangle
 	var clipData []colors.RGBA
 	var modConf conf.ModuleConfig
+	var calibFile string
 
-	flag.StringVar(&host, "host", host, "Controller hostname")
-	flag.UintVar(&tcpPort, "tcp", ledgrid.DefTCPPort, "TCP Port")
+	flag.StringVar(&host, "host", host, "Controller hostname ('auto': search via mDNS)")
+	flag.UintVar(&dataPort, "data", ledgrid.DefDataPort, "Data Port")
 	flag.UintVar(&rpcPort, "rpc", ledgrid.DefRPCPort, "RPC Port")
+	flag.StringVar(&calibFile, "calib", "", "Create a color calibration interactively and save it to this file")
 	flag.Parse()
 
-	gridClient = ledgrid.NewNetGridClient(host, tcpPort, rpcPort)
+	if host == "auto" {
+		svc, err := ledgrid.DiscoverGrid(ledgrid.DefDiscoverTimeout)
+		if err != nil {
+			log.Fatalf("Couldn't find controller: %v", err)
+		}
+		host, dataPort, rpcPort = svc.Addr.String(), svc.DataPort, svc.RPCPort
+	}
+	gridClient, err = ledgrid.NewNetGridClient(host, "tcp", dataPort, rpcPort)
+	if err != nil {
+		log.Fatalf("Couldn't connect to grid server: %v", err)
+	}
 	modConf = gridClient.ModuleConfig()
 	ledGrid = ledgrid.NewLedGrid(gridClient, modConf)
+	if calibFile != "" {
+		CalibMode(gridClient.(*ledgrid.NetGridClient), ledGrid, calibFile)
+		gridClient.Close()
+		return
+	}
 
 	gridSize = ledGrid.Rect.Size()
 	width = gridSize.X
@@ -313,7 +330,9 @@ func main() {
 	winGrid.Refresh()
 	winHelp.Refresh()
 
-	ledGrid.Client.Send(ledGrid.Pix)
+	if err = ledGrid.Client.Send(ledGrid.Pix); err != nil {
+		fmt.Fprintf(logFile, "Couldn't send data: %v\n", err)
+	}
 
 main:
 	for {
@@ -511,14 +530,14 @@ main:
 			gammaValues[1] -= 0.1
 			gammaValues[2] -= 0.1
 			ledGrid.Client.SetGamma(gammaValues[0], gammaValues[1], gammaValues[2])
-			ledGrid.Client.Send(ledGrid.Pix)
+			redrawGrid = true
 
 		case 'G':
 			gammaValues[0] += 0.1
 			gammaValues[1] += 0.1
 			gammaValues[2] += 0.1
 			ledGrid.Client.SetGamma(gammaValues[0], gammaValues[1], gammaValues[2])
-			ledGrid.Client.Send(ledGrid.Pix)
+			redrawGrid = true
 
 		case Ctrl('i'):
 			for row := selRect.Min.Y; row < selRect.Max.Y; row++ {
@@ -785,7 +804,9 @@ main:
 		}
 
 		if redrawGrid {
-			ledGrid.Client.Send(ledGrid.Pix)
+			if err = ledGrid.Client.Send(ledGrid.Pix); err != nil {
+				fmt.Fprintf(logFile, "Couldn't send data: %v\n", err)
+			}
 			redrawGrid = false
 		}
 	}
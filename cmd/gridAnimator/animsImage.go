@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log"
 	"math"
 	"time"
 
@@ -24,7 +25,11 @@ func BlinkenAnimation(ctx context.Context, c *ledgrid.Canvas) {
 	// pos3Mario := geom.Point{-5.0, float64(height) / 2.0}
 	// pos4Mario := geom.Point{float64(width) + 5.0, float64(height) / 2.0}
 
-	bmlFlame := ledgrid.ReadBlinkenFile("blinken/flameNew.bml")
+	bmlFlame, err := ledgrid.ReadBlinkenFile("blinken/flameNew.bml")
+	if err != nil {
+		log.Printf("Couldn't read animation: %v", err)
+		return
+	}
 	bmlFlame.SetAllDuration(32)
 
 	flame1 := ledgrid.NewSprite(posFlame1)
@@ -39,7 +44,11 @@ func BlinkenAnimation(ctx context.Context, c *ledgrid.Canvas) {
 	flame2.AddBlinkenLight(bmlFlame)
 	flame2.RepeatCount = ledgrid.AnimationRepeatForever
 
-	bmlMario := ledgrid.ReadBlinkenFile("blinken/marioWalkRight.bml")
+	bmlMario, err := ledgrid.ReadBlinkenFile("blinken/marioWalkRight.bml")
+	if err != nil {
+		log.Printf("Couldn't read animation: %v", err)
+		return
+	}
 
 	mario := ledgrid.NewSprite(pos1Mario)
 	mario.Mask.C.A = 0x00
@@ -78,7 +87,11 @@ func SlideShow(ctx context.Context, c *ledgrid.Canvas) {
 	dstSize := geom.NewPointIMG(c.Bounds().Size())
 	dstRatio := dstSize.X / dstSize.Y
 	for i, fileName := range files {
-		img := ledgrid.NewImage(pos, fileName)
+		img, err := ledgrid.NewImage(pos, fileName)
+		if err != nil {
+			log.Printf("Couldn't load image: %v", err)
+			continue
+		}
 		img.Hide()
 		srcRatio := float64(img.Img.Bounds().Dx()) / float64(img.Img.Bounds().Dy())
 		if dstRatio > srcRatio {
@@ -118,7 +131,11 @@ func SlideShow(ctx context.Context, c *ledgrid.Canvas) {
 
 func SingleImageAlign(ctx context.Context, c *ledgrid.Canvas) {
 	imgPos := geom.Point{float64(width / 2), float64(height / 2)}
-	img := ledgrid.NewImage(imgPos, "images/skull.png")
+	img, err := ledgrid.NewImage(imgPos, "images/skull.png")
+	if err != nil {
+		log.Printf("Couldn't load image: %v", err)
+		return
+	}
 	img.Size = geom.Point{float64(width / 2), float64(height / 2)}
 	img.SetAlign(ledgrid.AlignBottom)
 	c.Add(img)
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/stefan-muehlebach/gg/geom"
@@ -34,13 +36,14 @@ func FadeCanvases(ctx context.Context, c1 *ledgrid.Canvas) {
 
 	pos := geom.Point{float64(width) / 2.0, float64(height) / 2.0}
 
-	img1 := ledgrid.NewImage(pos, "images/img01.png")
-	img2 := ledgrid.NewImage(pos, "images/img02.png")
-	img3 := ledgrid.NewImage(pos, "images/img03.png")
-
-	c1.Add(img1)
-	c2.Add(img2)
-	c3.Add(img3)
+	for i, c := range []*ledgrid.Canvas{c1, c2, c3} {
+		img, err := ledgrid.NewImage(pos, fmt.Sprintf("images/img%02d.png", i+1))
+		if err != nil {
+			log.Printf("Couldn't load image: %v", err)
+			return
+		}
+		c.Add(img)
+	}
 
 	fader1.Start()
 	fader2.Start()
@@ -82,10 +85,18 @@ func TheaterKulissen(ctx context.Context, c1 *ledgrid.Canvas) {
 	pos := geom.Point{float64(width) / 2.0, float64(height) / 2.0}
 	size := geom.Point{float64(width), float64(height)}
 
-	imgCurtain := ledgrid.NewImage(pos, "images/curtain.png")
+	imgCurtain, err := ledgrid.NewImage(pos, "images/curtain.png")
+	if err != nil {
+		log.Printf("Couldn't load image: %v", err)
+		return
+	}
 	c1.Add(imgCurtain)
 
-	imgRocks := ledgrid.NewImage(pos, "images/floor.png")
+	imgRocks, err := ledgrid.NewImage(pos, "images/floor.png")
+	if err != nil {
+		log.Printf("Couldn't load image: %v", err)
+		return
+	}
 	c2.Add(imgRocks)
 
 	cam := NewCamera(pos, size, ctx)
@@ -152,8 +152,11 @@ func main() {
 	var dataPort, rpcPort uint
 	var clientType ClientType = defClientType
 	var customConfName string
-	// var useTCP bool
-	// var network string
+	var useUDP bool
+	var network string
+	var encoding ledgrid.FrameEncoding
+	var keyInterval int
+	var priority uint
 	var progChar string
 	var input string
 	var ch byte
@@ -184,9 +187,13 @@ func main() {
 	flag.IntVar(&width, "width", defWidth, "Width (Types: 1/2)")
 	flag.IntVar(&height, "height", defHeight, "Height (Types: 1/2)")
 
-	flag.StringVar(&host, "host", defHost, "Controller hostname (Type: 0)")
-	flag.UintVar(&dataPort, "tcp", ledgrid.DefTCPPort, "TCP Port (Type: 0)")
+	flag.StringVar(&host, "host", defHost, "Controller hostname, 'auto' to search via mDNS (Type: 0)")
+	flag.UintVar(&dataPort, "data", ledgrid.DefDataPort, "Data Port (Type: 0)")
 	flag.UintVar(&rpcPort, "rpc", ledgrid.DefRPCPort, "RPC Port (Type: 0)")
+	flag.BoolVar(&useUDP, "udp", false, "Use UDP instead of TCP for data (Type: 0)")
+	flag.Var(&encoding, "enc", "Frame encoding; 'raw' (default), 'rle' or 'delta' (Type: 0)")
+	flag.IntVar(&keyInterval, "key", 25, "Send a keyframe every 'key' frames with 'delta' encoding (Type: 0)")
+	flag.UintVar(&priority, "prio", uint(ledgrid.DefPriority), "Priority (0..255) if several clients are connected to the controller (Type: 0)")
 
 	flag.StringVar(&outFile, "out", "", "Send all data to this file (Type: 1)")
 
@@ -201,18 +208,61 @@ func main() {
 
 	switch clientType {
 	case NetClient:
-		gridClient = ledgrid.NewNetGridClient(host, dataPort, rpcPort)
+		network = "tcp"
+		if useUDP {
+			network = "udp"
+		}
+		if host == "auto" {
+			svc, err := ledgrid.DiscoverGrid(ledgrid.DefDiscoverTimeout)
+			if err != nil {
+				log.Fatalf("Couldn't find controller: %v", err)
+			}
+			host, dataPort, rpcPort = svc.Addr.String(), svc.DataPort, svc.RPCPort
+		}
+		gridClient, err = ledgrid.NewNetGridClient(host, network, dataPort, rpcPort)
+		if err != nil {
+			log.Fatalf("Couldn't connect to controller: %v", err)
+		}
+		err = gridClient.(*ledgrid.NetGridClient).SetEncoding(encoding, keyInterval)
+		if err != nil {
+			log.Fatalf("Couldn't set frame encoding: %v", err)
+		}
+		if priority != uint(ledgrid.DefPriority) {
+			err = gridClient.(*ledgrid.NetGridClient).SetPriority(ledgrid.Priority(min(priority, 255)))
+			if err != nil {
+				log.Fatalf("Couldn't set priority: %v", err)
+			}
+		}
+		gridClient.(*ledgrid.NetGridClient).OnStateChange(func(state ledgrid.ConnState, err error) {
+			if err != nil && state != ledgrid.ConnClosed {
+				log.Printf("Connection to controller %v: %v", state, err)
+			} else {
+				log.Printf("Connection to controller %v", state)
+			}
+		})
 		hostName = gridClient.(*ledgrid.NetGridClient).Address()
 		modConf = gridClient.ModuleConfig()
 	case FileClient:
 		if outFile == "" {
 			log.Fatalf("Must specify 'out' when using File client type")
 		}
-		modConf = conf.DefaultModuleConfig(image.Point{width, height})
-		gridClient = ledgrid.NewFileSaveClient(outFile, modConf)
+		modConf, err = conf.DefaultModuleConfig(image.Point{width, height})
+		if err != nil {
+			log.Fatalf("Couldn't create module configuration: %v", err)
+		}
+		gridClient, err = ledgrid.NewFileSaveClient(outFile, modConf)
+		if err != nil {
+			log.Fatalf("Couldn't create file: %v", err)
+		}
 	case DirectClient:
-		modConf = conf.DefaultModuleConfig(image.Point{width, height})
-		ws2801 = ledgrid.NewWS2801(spiDevFile, baud, modConf)
+		modConf, err = conf.DefaultModuleConfig(image.Point{width, height})
+		if err != nil {
+			log.Fatalf("Couldn't create module configuration: %v", err)
+		}
+		ws2801, err = ledgrid.NewWS2801(spiDevFile, baud, modConf)
+		if err != nil {
+			log.Fatalf("Couldn't open LED chain: %v", err)
+		}
 		gridClient = ledgrid.NewDirectGridClient(ws2801)
 	default:
 		log.Fatalf("Client type %d not defined (expected 0..2)")
@@ -233,7 +283,7 @@ func main() {
 	width = gridSize.X
 	height = gridSize.Y
 
-	canvas = ledGrid.Canvas(0)
+	canvas, _ = ledGrid.Canvas(0)
 	animCtrl = ledGrid.AnimCtrl
 
 	ledGrid.StartRefresh()
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"image"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -21,6 +24,7 @@ const (
 	defMissingIDs = ""
 	defDefectIDs  = ""
 	defBaud       = 2_000_000
+	defChip       = "ws2801"
 )
 
 func SignalHandler(gridServer *ledgrid.GridServer) {
@@ -35,14 +39,16 @@ func SignalHandler(gridServer *ledgrid.GridServer) {
 		case syscall.SIGUSR1:
 			log.Printf("Server statistics:")
 			log.Printf("   %v", gridServer.Stopwatch())
-			log.Printf("   %v bytes received by the controller", gridServer.RecvBytes)
-			log.Printf("   %v bytes sent by the controller", gridServer.SentBytes)
+			log.Printf("   %v bytes received by the controller", gridServer.RecvBytes())
+			log.Printf("   %v bytes sent by the controller", gridServer.SentBytes())
+			log.Printf("   compression ratio: %.1f", gridServer.CompressionRatio())
+			power := gridServer.PowerStats()
+			log.Printf("   estimated current: %.0f mA (requested: %.0f mA)",
+				power.Drawn, power.Requested)
 			log.Printf("Current gamma values:")
 			r, g, b := gridServer.Gamma()
 			log.Printf("   R: %.1f, G: %.1f, B: %.1f", r, g, b)
-			gridServer.RecvBytes = 0
-			gridServer.SentBytes = 0
-			gridServer.Stopwatch().Reset()
+			gridServer.ResetStatistics()
 
 		case syscall.SIGUSR2:
 			if gridServer.ToggleTestPattern() {
@@ -57,8 +63,12 @@ func SignalHandler(gridServer *ledgrid.GridServer) {
 func PlayFile(fileName string) {
 	var client ledgrid.GridClient
 	var buffer []byte
+	var err error
 
-	client = ledgrid.NewNetGridClient("localhost", dataPort, rpcPort)
+	client, err = ledgrid.NewNetGridClient("localhost", "tcp", dataPort, rpcPort)
+	if err != nil {
+		log.Fatalf("Couldn't connect to controller: %v", err)
+	}
 	buffer = make([]byte, 3*client.NumLeds())
 
 	fh, err := os.Open(fileName)
@@ -71,14 +81,117 @@ func PlayFile(fileName string) {
 		if n == 0 && errors.Is(err, io.EOF) {
 			break
 		}
-		client.Send(buffer)
+		if err = client.Send(buffer); err != nil {
+			log.Printf("Couldn't send frame: %v", err)
+			break
+		}
 	}
 	fh.Close()
 	client.Close()
 }
 
+// Oeffnet die Kette am SPI-Bus spiDev mit dem gewaehlten Chip-Typ.
+func OpenDisplay(spiDev string, modConf conf.ModuleConfig) (ledgrid.Displayer, error) {
+	var disp ledgrid.Displayer
+	var err error
+
+	spiBaud := baud
+	switch chip {
+	case "ws2801":
+		if spiBaud == 0 {
+			spiBaud = defBaud
+		}
+		disp, err = ledgrid.NewWS2801(spiDev, spiBaud, modConf)
+	case "ws2812":
+		if spiBaud == 0 {
+			spiBaud = ledgrid.DefWS2812Baud
+		}
+		disp, err = ledgrid.NewWS2812(spiDev, spiBaud, modConf)
+	case "sk6812rgbw":
+		var ws2812 *ledgrid.WS2812
+		if spiBaud == 0 {
+			spiBaud = ledgrid.DefWS2812Baud
+		}
+		ws2812, err = ledgrid.NewWS2812(spiDev, spiBaud, modConf)
+		if err == nil {
+			if whiteTemp > 0 {
+				ws2812.SetWhitePoint(ledgrid.WhitePointFromTemp(whiteTemp))
+			} else {
+				ws2812.SetWhitePoint(255, 255, 255)
+			}
+		}
+		disp = ws2812
+	case "apa102":
+		if spiBaud == 0 {
+			spiBaud = ledgrid.DefAPA102Baud
+		}
+		disp, err = ledgrid.NewAPA102(spiDev, spiBaud, modConf)
+	default:
+		return nil, fmt.Errorf("unknown chip type '%s'", chip)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if order != conf.DefOrder {
+		disp.(interface{ SetColorOrder(conf.ColorOrder) }).SetColorOrder(order)
+	}
+	return disp, nil
+}
+
+// Ein Recorder, welcher beim Schliessen auch die Ausgabedatei schliesst.
+type recordFile struct {
+	*ledgrid.Recorder
+	fh *os.File
+}
+
+func (r *recordFile) Close() {
+	r.Recorder.Close()
+	if r.fh != os.Stdout {
+		r.fh.Close()
+	}
+}
+
+// Statt die LEDs anzusteuern, werden die Bilder in die Datei fileName
+// gezeichnet. Das Format wird anhand der Endung gewaehlt: '.png' fuer
+// einzelne Bilder (mit '%d' im Namen fuer jedes Bild eine eigene Datei,
+// sonst jeweils das aktuelle Bild), '.gif' und '.apng' fuer Animationen und
+// '-' fuer Rohdaten auf stdout (bspw. fuer ffmpeg).
+func OpenRecorder(fileName string, modConf conf.ModuleConfig) (ledgrid.Displayer, error) {
+	var out ledgrid.FrameWriter
+
+	cfg := ledgrid.DefaultRecorderConfig()
+	cfg.PixelSize = recPixelSize
+	delay := time.Second / time.Duration(max(recFrameRate, 1))
+	if fileName == "-" {
+		rec := ledgrid.NewRecorder(modConf, cfg, ledgrid.NewRawWriter(os.Stdout))
+		size := rec.Bounds().Size().Mul(cfg.PixelSize)
+		log.Printf("Recording raw frames of %dx%d pixels to stdout", size.X, size.Y)
+		return &recordFile{rec, os.Stdout}, nil
+	}
+	switch filepath.Ext(fileName) {
+	case ".png":
+		return ledgrid.NewRecorder(modConf, cfg, ledgrid.NewPNGWriter(fileName)), nil
+	case ".gif", ".apng":
+		fh, err := os.Create(fileName)
+		if err != nil {
+			return nil, err
+		}
+		out = ledgrid.NewGIFWriter(fh, delay)
+		if filepath.Ext(fileName) == ".apng" {
+			out = ledgrid.NewAPNGWriter(fh, delay)
+		}
+		return &recordFile{ledgrid.NewRecorder(modConf, cfg, out), fh}, nil
+	}
+	return nil, fmt.Errorf("unknown file type '%s'", fileName)
+}
+
 var (
-	dataPort, rpcPort uint
+	tcpPort, dataPort, rpcPort, opcPort uint
+	chip                                string
+	baud                                int
+	order                               conf.ColorOrder
+	whiteTemp                           float64
+	recPixelSize, recFrameRate          int
 )
 
 func main() {
@@ -89,22 +202,55 @@ func main() {
 	var gridSize image.Point
 	var modConf conf.ModuleConfig
 
-	var baud int
+	var maxValue uint
+	var powerBudget float64
+	var powerConfName string
+	var useDither bool
+	var calibName string
 	var missingIDs, defectIDs string
-	var spiDevFile string = "/dev/spidev0.0"
-	var ws2801 ledgrid.Displayer
+	var statusFile string
+	var useE131, useArtNet bool
+	var dmxConfName string
+	var dmxConf conf.DMXConfig
+	var idleTimeout time.Duration
+	var mdnsName string
+	var spiDevFiles string
+	var recFile string
+	var disp ledgrid.Displayer
 	var gridServer *ledgrid.GridServer
+	var err error
 
 	flag.StringVar(&inFile, "play", "", "Play the animation in this file instead of running as a daemon")
 
 	// Verarbeite als erstes die Kommandozeilen-Optionen
 	flag.IntVar(&width, "width", 0, "Width of panel")
 	flag.IntVar(&height, "height", 0, "Height of panel")
-	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration")
+	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration (name or file)")
 
-	flag.UintVar(&dataPort, "tcp", ledgrid.DefTCPPort, "TCP port")
+	flag.UintVar(&tcpPort, "tcp", ledgrid.DefTCPPort, "TCP port for legacy clients (raw frames)")
+	flag.UintVar(&dataPort, "data", ledgrid.DefDataPort, "Data port (framed protocol, TCP and UDP)")
 	flag.UintVar(&rpcPort, "rpc", ledgrid.DefRPCPort, "RPC port")
-	flag.IntVar(&baud, "baud", defBaud, "SPI baudrate in Hz")
+	flag.UintVar(&opcPort, "opc", 0, "Open Pixel Control (OPC) port (0: disabled, default OPC port: 7890)")
+	flag.BoolVar(&useE131, "sacn", false, "Listen for E1.31 (sACN) packets (multicast)")
+	flag.BoolVar(&useArtNet, "artnet", false, "Listen for Art-Net packets")
+	flag.StringVar(&dmxConfName, "dmx", "", "DMX mapping for sACN/Art-Net (default: 170 LEDs per universe, starting with universe 1)")
+	flag.DurationVar(&idleTimeout, "timeout", 0, "Time after which a silent client is no longer shown (0: clients are shown until they disconnect)")
+	mdnsName, _ = os.Hostname()
+	flag.StringVar(&mdnsName, "name", mdnsName, "Name under which the controller is advertised via mDNS (empty: disabled)")
+	flag.StringVar(&spiDevFiles, "spi", "/dev/spidev0.0", "SPI device(s) of the LED chain(s), with several comma separated devices the modules are split onto several chains")
+	flag.StringVar(&chip, "chip", defChip, "Type of the LED chips ('ws2801', 'ws2812', 'sk6812rgbw' or 'apa102')")
+	flag.StringVar(&recFile, "record", "", "Draw the frames into this file instead of sending them to the LEDs ('.png', '.gif', '.apng' or '-' for raw frames on stdout)")
+	flag.IntVar(&recPixelSize, "pixelsize", 20, "Size of a pixel in the recorded images")
+	flag.IntVar(&recFrameRate, "fps", 30, "Frame rate of recorded animations")
+	flag.IntVar(&baud, "baud", 0, "SPI baudrate in Hz (0: default baudrate of the chip)")
+	flag.UintVar(&maxValue, "maxvalue", 255, "Maximum brightness of the LEDs (0..255, uses the global brightness of the 'apa102' chips)")
+	flag.Var(&order, "order", "Color order of the LED chips ('RGB', 'GRB', 'BGR', etc., default: native order of the chip)")
+	flag.Float64Var(&whiteTemp, "white", 0, "Color temperature of the white LEDs of 'sk6812rgbw' chips in Kelvin (0: white = min(R, G, B))")
+	flag.Float64Var(&powerBudget, "budget", 0, "Maximum current of the LEDs in mA, frames are dimmed above (0: no limit)")
+	flag.StringVar(&powerConfName, "powerconf", "", "Use a power limiter configuration with segments (file in data/)")
+	flag.BoolVar(&useDither, "dither", false, "Use temporal dithering for smoother fades at low brightness")
+	flag.StringVar(&calibName, "calib", "", "Use this color calibration (name in data/calib of package conf or file)")
+	flag.StringVar(&statusFile, "status", "", "File with the status of missing and defect LEDs (will be created and updated)")
 	flag.StringVar(&missingIDs, "missing", defMissingIDs, "Comma separated list with IDs of missing LEDs (they will be skipped)")
 	flag.StringVar(&defectIDs, "defect", defDefectIDs, "Comma separated list with IDs of defect LEDs (they will be blacked out)")
 	flag.Parse()
@@ -118,16 +264,98 @@ func main() {
 	}
 
 	if customConfName != "" {
-		modConf = conf.Load("data/" + customConfName + ".json")
+		modConf, err = conf.Load(conf.CustomFileName(customConfName))
+		if err != nil {
+			log.Fatalf("Couldn't load module configuration: %v", err)
+		}
 		gridSize = modConf.Size()
 		width, height = gridSize.X, gridSize.Y
 	} else {
 		gridSize = image.Point{width, height}
-		modConf = conf.DefaultModuleConfig(gridSize)
+		modConf, err = conf.DefaultModuleConfig(gridSize)
+		if err != nil {
+			log.Fatalf("Couldn't create module configuration: %v", err)
+		}
+	}
+
+	spiDevs := strings.Split(spiDevFiles, ",")
+	if len(spiDevs) > len(modConf) {
+		log.Fatalf("Couldn't open LED chain: %d SPI devices for %d modules",
+			len(spiDevs), len(modConf))
+	}
+	if recFile != "" {
+		disp, err = OpenRecorder(recFile, modConf)
+	} else if len(spiDevs) == 1 {
+		disp, err = OpenDisplay(spiDevs[0], modConf)
+	} else {
+		// Die Module werden moeglichst gleichmaessig auf die Ketten verteilt.
+		var outputs []ledgrid.Displayer
+		numMods := make([]int, len(spiDevs))
+		for i := range numMods {
+			numMods[i] = len(modConf) / len(spiDevs)
+			if i < len(modConf)%len(spiDevs) {
+				numMods[i]++
+			}
+		}
+		for i, part := range ledgrid.SplitModuleConfig(modConf, numMods...) {
+			out, err := OpenDisplay(spiDevs[i], part)
+			if err != nil {
+				log.Fatalf("Couldn't open LED chain: %v", err)
+			}
+			outputs = append(outputs, out)
+		}
+		disp, err = ledgrid.NewMultiDisplayer(modConf, outputs...)
+	}
+	if err != nil {
+		log.Fatalf("Couldn't open LED chain: %v", err)
+	}
+	gridServer, err = ledgrid.NewGridServer(tcpPort, dataPort, rpcPort, disp)
+	if err != nil {
+		log.Fatalf("Couldn't start server: %v", err)
+	}
+	gridServer.SetIdleTimeout(idleTimeout)
+	if powerConfName != "" || powerBudget > 0 {
+		powerConf := ledgrid.DefaultPowerConfig()
+		if powerConfName != "" {
+			data, err := os.ReadFile("data/" + powerConfName + ".json")
+			if err != nil {
+				log.Fatalf("Couldn't load power configuration: %v", err)
+			}
+			if err = json.Unmarshal(data, &powerConf); err != nil {
+				log.Fatalf("Couldn't load power configuration: %v", err)
+			}
+		}
+		if powerBudget > 0 {
+			powerConf.Budget = powerBudget
+		}
+		if err = gridServer.SetPowerConfig(powerConf); err != nil {
+			log.Fatalf("Couldn't set power configuration: %v", err)
+		}
+	}
+	if calibName != "" {
+		cal, err := conf.LoadCalibration(conf.CalibFileName(calibName))
+		if err != nil {
+			log.Fatalf("Couldn't load calibration: %v", err)
+		}
+		if err = gridServer.SetCalibration(cal); err != nil {
+			log.Fatalf("Couldn't set calibration: %v", err)
+		}
+	}
+	if useDither {
+		disp.(interface{ SetDithering(on bool) }).SetDithering(true)
+	}
+	if maxValue < 255 {
+		val := uint8(maxValue)
+		gridServer.SetMaxValue(val, val, val)
 	}
 
-	ws2801 = ledgrid.NewWS2801(spiDevFile, baud, modConf)
-	gridServer = ledgrid.NewGridServer(dataPort, rpcPort, ws2801)
+	// Mit -missing und -defect angegebene LEDs werden zusaetzlich in der
+	// Status-Datei vermerkt.
+	if statusFile != "" {
+		if err := gridServer.LoadLedStatus(statusFile); err != nil {
+			log.Fatalf("Couldn't load LED status: %v", err)
+		}
+	}
 
 	if len(missingIDs) > 0 {
 		for _, str := range strings.Split(missingIDs, ",") {
@@ -135,7 +363,9 @@ func main() {
 			if err != nil {
 				log.Fatalf("Failed to parse 'missing': wrong format: %s", str)
 			}
-			gridServer.SetPixelStatus(int(val), ledgrid.LedMissing)
+			if err := gridServer.SetPixelStatus(int(val), ledgrid.LedMissing); err != nil {
+				log.Fatalf("Couldn't set status of LED %d: %v", val, err)
+			}
 		}
 	}
 
@@ -145,7 +375,44 @@ func main() {
 			if err != nil {
 				log.Fatalf("Failed to parse 'defect': wrong format: %s", str)
 			}
-			gridServer.SetPixelStatus(int(val), ledgrid.LedDefect)
+			if err := gridServer.SetPixelStatus(int(val), ledgrid.LedDefect); err != nil {
+				log.Fatalf("Couldn't set status of LED %d: %v", val, err)
+			}
+		}
+	}
+
+	if opcPort != 0 {
+		if err := gridServer.EnableOPC(opcPort); err != nil {
+			log.Fatalf("Couldn't enable OPC: %v", err)
+		}
+	}
+
+	if useE131 || useArtNet {
+		if dmxConfName != "" {
+			dmxConf, err = conf.LoadDMXConfig("data/dmx/" + dmxConfName + ".json")
+			if err != nil {
+				log.Fatalf("Couldn't load DMX mapping: %v", err)
+			}
+		} else {
+			dmxConf = conf.DefaultDMXConfig(disp.NumLeds())
+		}
+	}
+	if useE131 {
+		if err := gridServer.EnableE131(ledgrid.DefE131Port, dmxConf, true); err != nil {
+			log.Fatalf("Couldn't enable E1.31: %v", err)
+		}
+	}
+	if useArtNet {
+		if err := gridServer.EnableArtNet(ledgrid.DefArtNetPort, dmxConf); err != nil {
+			log.Fatalf("Couldn't enable Art-Net: %v", err)
+		}
+	}
+
+	// Ohne Multicast (bspw. in einem isolierten Netz) laeuft der Controller
+	// auch ohne mDNS, die Clients muessen den Hostnamen dann aber kennen.
+	if mdnsName != "" {
+		if err := gridServer.EnableMDNS(mdnsName); err != nil {
+			log.Printf("Couldn't enable mDNS: %v", err)
 		}
 	}
 
@@ -69,12 +69,18 @@ func main() {
 	}
 
 	if customConfName != "" {
-		modConf = conf.Load("data/" + customConfName + ".json")
+		if modConf, err = conf.Load(conf.CustomFileName(customConfName)); err != nil {
+			println("Couldn't load module config:", err.Error())
+			return
+		}
 		gridSize = modConf.Size()
 		width, height = gridSize.X, gridSize.Y
 	} else {
 		gridSize = image.Point{width, height}
-		modConf = conf.DefaultModuleConfig(gridSize)
+		if modConf, err = conf.DefaultModuleConfig(gridSize); err != nil {
+			println("Invalid grid size:", err.Error())
+			return
+		}
 	}
 
 	println("Setup connection to WS2891 by SPI bus")
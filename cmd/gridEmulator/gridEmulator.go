@@ -17,16 +17,15 @@ const (
 )
 
 func ResetStatistics(gridServer *ledgrid.GridServer) {
-	gridServer.Stopwatch().Reset()
-	gridServer.RecvBytes = 0
-	gridServer.SentBytes = 0
+	gridServer.ResetStatistics()
 }
 
 func PrintStatistics() {
 	log.Printf("Emulator statistics:")
 	log.Printf("   %v", gridServer.Stopwatch())
-	log.Printf("   %d bytes received by the controller", gridServer.RecvBytes)
-	log.Printf("   %d bytes sent by the controller", gridServer.SentBytes)
+	log.Printf("   %d bytes received by the controller", gridServer.RecvBytes())
+	log.Printf("   %d bytes sent by the controller", gridServer.SentBytes())
+	log.Printf("   compression ratio: %.1f", gridServer.CompressionRatio())
 	log.Printf("Current gamma values:")
 	r, g, b := gridServer.Gamma()
 	log.Printf("   R: %.1f, G: %.1f, B: %.1f", r, g, b)
@@ -46,37 +45,55 @@ var (
 
 func main() {
 	var width, height int
-	var dataPort, rpcPort uint
+	var tcpPort, dataPort, rpcPort uint
 	var pixelSize float64
 	var gridWindow *Window
 	var customConfName string
+	var mdnsName string
 	var gridSize image.Point
 	var modConf conf.ModuleConfig
+	var err error
 
 	flag.IntVar(&width, "width", defWidth, "Width of panel")
 	flag.IntVar(&height, "height", defHeight, "Height of panel")
-	flag.UintVar(&dataPort, "tcp", ledgrid.DefTCPPort, "TCP port")
+	flag.UintVar(&tcpPort, "tcp", ledgrid.DefTCPPort, "TCP port for legacy clients (raw frames)")
+	flag.UintVar(&dataPort, "data", ledgrid.DefDataPort, "Data port (framed protocol, TCP and UDP)")
 	flag.UintVar(&rpcPort, "rpc", ledgrid.DefRPCPort, "RPC port")
 	flag.Float64Var(&pixelSize, "size", defPixelSize, "Diameter of one LED in pixels")
-	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration")
+	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration (name or file)")
+	flag.StringVar(&mdnsName, "name", "", "Advertise the emulator via mDNS under this name (empty: disabled)")
 	flag.Parse()
 
 	StartProfiling()
 	defer StopProfiling()
 
 	if customConfName != "" {
-		modConf = conf.Load("data/" + customConfName + ".json")
+		modConf, err = conf.Load(conf.CustomFileName(customConfName))
+		if err != nil {
+			log.Fatalf("Couldn't load module configuration: %v", err)
+		}
 		gridSize = modConf.Size()
 		width, height = gridSize.X, gridSize.Y
 	} else {
 		gridSize = image.Point{width, height}
-		modConf = conf.DefaultModuleConfig(gridSize)
+		modConf, err = conf.DefaultModuleConfig(gridSize)
+		if err != nil {
+			log.Fatalf("Couldn't create module configuration: %v", err)
+		}
 	}
 
 	title := fmt.Sprintf("LEDGrid Emulator (Size: %d x %d; Port: %d)", gridSize.X, gridSize.Y, dataPort)
 
 	gridWindow = NewWindow(title, pixelSize, modConf)
-	gridServer = ledgrid.NewGridServer(dataPort, rpcPort, gridWindow)
+	gridServer, err = ledgrid.NewGridServer(tcpPort, dataPort, rpcPort, gridWindow)
+	if err != nil {
+		log.Fatalf("Couldn't start server: %v", err)
+	}
+	if mdnsName != "" {
+		if err = gridServer.EnableMDNS(mdnsName); err != nil {
+			log.Printf("Couldn't enable mDNS: %v", err)
+		}
+	}
 
 	gridServer.HandleEvents()
 	gridWindow.HandleEvents()
@@ -29,16 +29,19 @@ type Window struct {
 
 // A new grid object must only know it's size in order to get the
 // configuration of the emulated modules.
-func NewWindowBySize(title string, pixelSize float64, size image.Point) *Window {
-	modConf := conf.DefaultModuleConfig(size)
-	return NewWindow(title, pixelSize, modConf)
+func NewWindowBySize(title string, pixelSize float64, size image.Point) (*Window, error) {
+	modConf, err := conf.DefaultModuleConfig(size)
+	if err != nil {
+		return nil, err
+	}
+	return NewWindow(title, pixelSize, modConf), nil
 }
 
 func NewWindow(title string, pixelSize float64, modConf conf.ModuleConfig) *Window {
 	var err error
 
 	e := &Window{}
-	e.DisplayEmbed.Init(e, len(modConf)*conf.ModuleDim.X*conf.ModuleDim.Y)
+	e.DisplayEmbed.Init(e, modConf.NumLeds())
 	e.ModConf = modConf
 	e.size = e.ModConf.Size()
 
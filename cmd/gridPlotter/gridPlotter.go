@@ -5,20 +5,105 @@ import (
 	"fmt"
 	"github.com/stefan-muehlebach/ledgrid/conf"
 	"image"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
+// Gibt alle Eintraege der Status-Datenbank aus, zusammen mit dem Index der
+// LED auf der Kette der Modul-Konfiguration modConf.
+func listStatus(modConf conf.ModuleConfig, ledStatus conf.LedStatusDB) {
+	for _, e := range ledStatus.Leds {
+		chainIdx := "-"
+		for _, m := range modConf {
+			if m.SamePlace(e.Place()) && e.Idx < m.NumLeds() {
+				chainIdx = fmt.Sprintf("%d", m.Idx+e.Idx)
+				break
+			}
+		}
+		fmt.Printf("(%2d,%2d) %3d: %-7v (chain index: %s)\n", e.Col, e.Row,
+			e.Idx, e.Status, chainIdx)
+	}
+}
+
+// Unterkommando 'solve': sucht fuer eine Form (Zellen, welche von Modulen
+// belegt sind) eine Modul-Konfiguration mit moeglichst wenig
+// Kabelspruengen. Die Form wird als Text (siehe conf.ParseShape), als Datei
+// oder ueber eine bestehende Konfiguration angegeben.
+func solve(args []string) {
+	var shape, shapeFileName, customConfName, outName string
+	var cells []image.Point
+	var err error
+
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	flags.StringVar(&shape, "shape", "", "Shape with one line per row of modules, '#' marks a module, rows separated by '/'")
+	flags.StringVar(&shapeFileName, "file", "", "Read the shape from this file")
+	flags.StringVar(&customConfName, "custom", "", "Use the shape of this custom module configuration (name or file)")
+	flags.StringVar(&outName, "out", "solved", "Base name of the output files (.json and .png)")
+	flags.Parse(args)
+
+	switch {
+	case shape != "":
+		cells, err = conf.ParseShape(shape)
+	case shapeFileName != "":
+		var data []byte
+		if data, err = os.ReadFile(shapeFileName); err == nil {
+			cells, err = conf.ParseShape(string(data))
+		}
+	case customConfName != "":
+		var modConf conf.ModuleConfig
+		if modConf, err = conf.Load(conf.CustomFileName(customConfName)); err == nil {
+			cells = modConf.Cells()
+		}
+	default:
+		fmt.Printf("either shape, file or custom must be specified!")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Couldn't get shape: %v", err)
+	}
+
+	modConf, err := conf.SolveModuleConfig(cells)
+	if err != nil {
+		log.Fatalf("Couldn't solve module configuration: %v", err)
+	}
+	for _, modPos := range modConf {
+		fmt.Printf("(%2d,%2d) %v\n", modPos.Col, modPos.Row, modPos.Mod)
+	}
+	fmt.Printf("%d modules, %d cable jumps\n", len(modConf), modConf.CableJumps())
+	if err = modConf.Save(outName + ".json"); err != nil {
+		log.Fatalf("Couldn't save module configuration: %v", err)
+	}
+	if err = modConf.Plot(outName + ".png"); err != nil {
+		log.Fatalf("Couldn't plot module configuration: %v", err)
+	}
+}
+
 func main() {
 	var width, height int
 	var customConfName string
 	var modConf conf.ModuleConfig
 	var outFileName string
+	var statusFileName string
+	var ledStatus conf.LedStatusDB
 	var showList bool
+	var err error
 
 	flag.IntVar(&width, "width", 0, "Width of panel")
 	flag.IntVar(&height, "height", 0, "Height of panel")
-	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration")
+	flag.StringVar(&customConfName, "custom", "", "Use a non standard module configuration (name or file)")
+	flag.StringVar(&statusFileName, "status", "", "List and mark the defect and missing LEDs of this status file")
 	flag.BoolVar(&showList, "list", false, "list all custom configuration files")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n"+
+			"       %s solve [options]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	if len(os.Args) > 1 && os.Args[1] == "solve" {
+		solve(os.Args[2:])
+		return
+	}
 	flag.Parse()
 
 	if showList {
@@ -30,15 +115,27 @@ func main() {
 		if width > 0 && height > 0 {
 			gridSize := image.Point{width, height}
 			outFileName = fmt.Sprintf("default%dx%d.png", gridSize.X, gridSize.Y)
-			modConf = conf.DefaultModuleConfig(gridSize)
+			modConf, err = conf.DefaultModuleConfig(gridSize)
 		} else if customConfName != "" {
-			fileName := "data/" + customConfName + ".json"
-			outFileName = customConfName + ".png"
-			modConf = conf.Load(fileName)
+			outFileName = strings.TrimSuffix(filepath.Base(customConfName),
+				filepath.Ext(customConfName)) + ".png"
+			modConf, err = conf.Load(conf.CustomFileName(customConfName))
 		} else {
 			fmt.Printf("either width/height or custom must be specified!")
 			return
 		}
-		modConf.Plot(outFileName)
+		if err != nil {
+			log.Fatalf("Couldn't get module configuration: %v", err)
+		}
+		if statusFileName != "" {
+			ledStatus, err = conf.LoadLedStatus(statusFileName)
+			if err != nil {
+				log.Fatalf("Couldn't load LED status: %v", err)
+			}
+			listStatus(modConf, ledStatus)
+		}
+		if err = modConf.PlotStatus(outFileName, ledStatus); err != nil {
+			log.Fatalf("Couldn't plot module configuration: %v", err)
+		}
 	}
 }
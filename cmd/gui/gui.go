@@ -6,6 +6,7 @@ import (
 	"context"
 	"flag"
 	"image"
+	"log"
 	"math/rand"
 	"strings"
 	"time"
@@ -121,10 +122,11 @@ func main() {
 	var network string
 	var gR, gG, gB float64
 	var modConf conf.ModuleConfig
+	var err error
 
 	flag.IntVar(&width, "width", defWidth, "Width (for 'out' option only)")
 	flag.IntVar(&height, "height", defHeight, "Height (for 'out' option only)")
-	flag.StringVar(&host, "host", defHost, "Controller hostname")
+	flag.StringVar(&host, "host", defHost, "Controller hostname ('auto': search via mDNS)")
 	flag.BoolVar(&useTCP, "tcp", false, "Use TCP for data")
 	flag.UintVar(&dataPort, "data", ledgrid.DefDataPort, "Data Port")
 	flag.UintVar(&rpcPort, "rpc", ledgrid.DefRPCPort, "RPC Port")
@@ -135,7 +137,17 @@ func main() {
 	} else {
 		network = "udp"
 	}
-	gridClient = ledgrid.NewNetGridClient(host, network, dataPort, rpcPort)
+	if host == "auto" {
+		svc, err := ledgrid.DiscoverGrid(ledgrid.DefDiscoverTimeout)
+		if err != nil {
+			log.Fatalf("Couldn't find controller: %v", err)
+		}
+		host, dataPort, rpcPort = svc.Addr.String(), svc.DataPort, svc.RPCPort
+	}
+	gridClient, err = ledgrid.NewNetGridClient(host, network, dataPort, rpcPort)
+	if err != nil {
+		log.Fatalf("Couldn't connect to grid server: %v", err)
+	}
 	modConf = gridClient.ModuleConfig()
 	ledGrid = ledgrid.NewLedGrid(gridClient, modConf)
 	gR, gG, gB = gridClient.Gamma()
@@ -144,7 +156,7 @@ func main() {
 	width = gridSize.X
 	height = gridSize.Y
 
-	canvas = ledGrid.Canvas(0)
+	canvas, _ = ledGrid.Canvas(0)
 	animCtrl = ledGrid.AnimCtrl
 
 	//------------------------------------------------------------------------
@@ -267,8 +279,17 @@ func main() {
 		container.NewTabItem("Preferences", prefTab),
 	)
 
+	// The status of the connection is updated by the grid client, even
+	// while it tries to reconnect in the background.
+	connStatus := binding.NewString()
+	connStatus.Set("Connection: " + ledgrid.ConnOnline.String())
+	gridClient.(*ledgrid.NetGridClient).OnStateChange(func(state ledgrid.ConnState, err error) {
+		connStatus.Set("Connection: " + state.String())
+	})
+	connLabel := widget.NewLabelWithData(connStatus)
+
 	quitBtn := widget.NewButton("Quit", Quit)
-	btnBox := container.NewHBox(layout.NewSpacer(), quitBtn)
+	btnBox := container.NewHBox(connLabel, layout.NewSpacer(), quitBtn)
 
 	root := container.NewVBox(
 		tabs,
@@ -48,9 +48,14 @@ func SignalHandler(timeout time.Duration) {
 func main() {
 	var modConf conf.ModuleConfig
 	var timeout time.Duration
+	var err error
 	// var gR, gG, gB float64
 
-	gridClient = ledgrid.NewDirectGridClient(ledgrid.NewWS2801avr(conf.DefaultModuleConfig(image.Point{width, height})))
+	if modConf, err = conf.DefaultModuleConfig(image.Point{width, height}); err != nil {
+		println("Invalid grid size:", err.Error())
+		return
+	}
+	gridClient = ledgrid.NewDirectGridClient(ledgrid.NewWS2801avr(modConf))
 	modConf = gridClient.ModuleConfig()
 	ledGrid = ledgrid.NewLedGrid(gridClient, modConf)
 	// gR, gG, gB = ledGrid.Client.Gamma()
@@ -59,7 +64,7 @@ func main() {
 	width = gridSize.X
 	height = gridSize.Y
 
-	canvas = ledGrid.Canvas(0)
+	canvas, _ = ledGrid.Canvas(0)
 	animCtrl = ledGrid.AnimCtrl
 
 	ledGrid.StartRefresh()
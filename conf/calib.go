@@ -0,0 +1,231 @@
+package conf
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// NeoPixels of different batches have visibly different white points. A
+// Calibration corrects them with a 3x3 color matrix and a gain factor per
+// color, either for whole modules or for single LEDs. Modules are
+// identified by their position (Col, Row and the offset X, Y, see
+// ModulePosition) in the module configuration, LEDs additionally by their
+// index Idx within the module (in the order of the chain). Entries for
+// single LEDs take precedence over the ones of their module.
+type Calibration struct {
+	Modules []ModuleCalibration `json:"Modules,omitempty"`
+	Leds    []LedCalibration    `json:"Leds,omitempty"`
+}
+
+// The correction of a color (r, g, b) is computed as
+//
+//	gain * (matrix * (r, g, b))
+//
+// A missing matrix is treated as the identity and a missing (all zero)
+// gain as (1, 1, 1).
+type ColorCorrection struct {
+	Matrix *[3][3]float64 `json:"Matrix,omitempty"`
+	Gain   [3]float64     `json:"Gain"`
+}
+
+// Largest absolute value of an entry of the combined matrix (see
+// ColorCorrection.Combined). Larger values make no sense for a color
+// correction and would overflow the fixed point arithmetic of the
+// displayers.
+const MaxCalibFactor = 16.0
+
+type ModuleCalibration struct {
+	Col int `json:"Col"`
+	Row int `json:"Row"`
+	X   int `json:"X,omitempty"`
+	Y   int `json:"Y,omitempty"`
+	ColorCorrection
+}
+
+type LedCalibration struct {
+	Col int `json:"Col"`
+	Row int `json:"Row"`
+	X   int `json:"X,omitempty"`
+	Y   int `json:"Y,omitempty"`
+	Idx int `json:"Idx"`
+	ColorCorrection
+}
+
+// Returns the position of the calibrated module.
+func (mc ModuleCalibration) Place() ModulePosition {
+	return ModulePosition{Col: mc.Col, Row: mc.Row, X: mc.X, Y: mc.Y}
+}
+
+// Returns the position of the module of the calibrated LED.
+func (lc LedCalibration) Place() ModulePosition {
+	return ModulePosition{Col: lc.Col, Row: lc.Row, X: lc.X, Y: lc.Y}
+}
+
+// Returns the combined matrix of the correction, i.e. the matrix with the
+// gains applied to its rows.
+func (c ColorCorrection) Combined() [3][3]float64 {
+	m := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	if c.Matrix != nil {
+		m = *c.Matrix
+	}
+	if c.Gain != [3]float64{} {
+		for i := range m {
+			for j := range m[i] {
+				m[i][j] *= c.Gain[i]
+			}
+		}
+	}
+	return m
+}
+
+// Computes the matrix of every LED on the chain described by modConf. LEDs
+// without a correction get nil. If no LED is corrected at all, the result
+// is nil.
+func (cal Calibration) Matrices(modConf ModuleConfig) []*[3][3]float64 {
+	var matrices []*[3][3]float64
+
+	set := func(idx int, c ColorCorrection) {
+		if matrices == nil {
+			matrices = make([]*[3][3]float64, modConf.NumLeds())
+		}
+		m := c.Combined()
+		matrices[idx] = &m
+	}
+	for _, mc := range cal.Modules {
+		if pos := modConf.find(mc.Place()); pos != nil {
+			for i := range pos.NumLeds() {
+				set(pos.Idx+i, mc.ColorCorrection)
+			}
+		}
+	}
+	for _, lc := range cal.Leds {
+		if pos := modConf.find(lc.Place()); pos != nil &&
+			lc.Idx >= 0 && lc.Idx < pos.NumLeds() {
+			set(pos.Idx+lc.Idx, lc.ColorCorrection)
+		}
+	}
+	return matrices
+}
+
+// Returns the module at the same position as place or nil.
+func (conf ModuleConfig) find(place ModulePosition) *ModulePosition {
+	for i := range conf {
+		if conf[i].SamePlace(place) {
+			return &conf[i]
+		}
+	}
+	return nil
+}
+
+// Checks the calibration against a module configuration: every entry must
+// refer to an existing module (and LED), all gains must be positive and no
+// entry of the combined matrix may exceed MaxCalibFactor. Errors are
+// reported with ErrBadConfig.
+func (cal Calibration) Verify(modConf ModuleConfig) error {
+	check := func(c ColorCorrection) error {
+		for _, g := range c.Gain {
+			if g < 0 {
+				return fmt.Errorf("negative gain %v", c.Gain)
+			}
+		}
+		for _, row := range c.Combined() {
+			for _, v := range row {
+				if !(math.Abs(v) <= MaxCalibFactor) {
+					return fmt.Errorf("matrix entry %v out of range", v)
+				}
+			}
+		}
+		return nil
+	}
+	for i, mc := range cal.Modules {
+		if modConf.find(mc.Place()) == nil {
+			return fmt.Errorf("%w: module entry %d: no module at (%d,%d)",
+				ErrBadConfig, i, mc.Col, mc.Row)
+		}
+		if err := check(mc.ColorCorrection); err != nil {
+			return fmt.Errorf("%w: module entry %d: %v", ErrBadConfig, i, err)
+		}
+	}
+	for i, lc := range cal.Leds {
+		pos := modConf.find(lc.Place())
+		if pos == nil {
+			return fmt.Errorf("%w: LED entry %d: no module at (%d,%d)",
+				ErrBadConfig, i, lc.Col, lc.Row)
+		}
+		if lc.Idx < 0 || lc.Idx >= pos.NumLeds() {
+			return fmt.Errorf("%w: LED entry %d: invalid index %d",
+				ErrBadConfig, i, lc.Idx)
+		}
+		if err := check(lc.ColorCorrection); err != nil {
+			return fmt.Errorf("%w: LED entry %d: %v", ErrBadConfig, i, err)
+		}
+	}
+	return nil
+}
+
+// Sets the correction of the module at the position of place, replacing an
+// existing entry.
+func (cal *Calibration) SetModule(place ModulePosition, c ColorCorrection) {
+	for i, mc := range cal.Modules {
+		if mc.Place().SamePlace(place) {
+			cal.Modules[i].ColorCorrection = c
+			return
+		}
+	}
+	cal.Modules = append(cal.Modules, ModuleCalibration{place.Col, place.Row,
+		place.X, place.Y, c})
+}
+
+// Returns the correction of the module at the position of place.
+func (cal Calibration) Module(place ModulePosition) (ColorCorrection, bool) {
+	for _, mc := range cal.Modules {
+		if mc.Place().SamePlace(place) {
+			return mc.ColorCorrection, true
+		}
+	}
+	return ColorCorrection{}, false
+}
+
+//go:embed data/calib/*.json
+var calibFiles embed.FS
+
+// Returns the file name for the calibration name given on the command
+// line: names with an extension are file names already, otherwise the name
+// denotes the file data/calib/name.json (see LoadCalibration).
+func CalibFileName(name string) string {
+	if filepath.Ext(name) != "" {
+		return name
+	}
+	return "data/calib/" + name + ".json"
+}
+
+// Reads a calibration. As with Load, fileName is first looked up in the
+// file system, then in the embedded files (see data/calib). Calibrations
+// created with colorEdit can therefore be used without recompiling.
+func LoadCalibration(fileName string) (Calibration, error) {
+	var cal Calibration
+
+	data, err := readFile(calibFiles, fileName)
+	if err != nil {
+		return cal, err
+	}
+	if err = json.Unmarshal(data, &cal); err != nil {
+		return cal, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return cal, nil
+}
+
+// Writes the calibration to the file fileName. It can be loaded from there
+// directly (see LoadCalibration); to be embedded, the file must be copied
+// to data/calib.
+func (cal Calibration) Save(fileName string) error {
+	data, err := json.MarshalIndent(cal, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
@@ -5,14 +5,20 @@
 package conf
 
 import (
+	"bytes"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
-	"log"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+
+	"github.com/BurntSushi/toml"
 )
 
 // Es zeichnet sich ab, dass groessere LED-Panels aus quadratischen Modulen
@@ -25,6 +31,8 @@ import (
 // Trotzdem muss ein Anwender die Idee hinter dieser Konfiguration verstehen -
 // schliesslich ist er für die Erstellung der Module und deren Verkabelung
 // zustaendig.
+// Fuer beliebige Formen (auch mit Loechern) sucht SolveModuleConfig eine
+// solche Anordnung, im gridPlotter als Unterkommando 'solve' verfuegbar.
 //
 //   +--------+--------+--------+
 //   |I      O|I      O|I       |
@@ -79,64 +87,148 @@ import (
 //   +--------+--------+...+--------+
 //                         .        .
 
-// This denotes the number of LEDs on a single module.
+// This denotes the number of LEDs on the classic 10x10 module (see ModLR
+// and ModRL). It is also the grid on which modules are placed with Col and
+// Row (see ModulePosition).
 var (
 	ModuleDim = image.Point{10, 10}
 )
 
-// There are two module types (with respect to the cabeling).
-type ModuleType int
+// Besides the 10x10 modules, there are LED matrices of other sizes (e.g.
+// flexible 8x32 matrices or 16x16 panels) with other wiring patterns. The
+// wiring of a module type is described by the direction in which the chain
+// runs first (columns or rows), whether it turns back at the end of a
+// column/row (serpentine) or starts every column/row on the same side
+// (progressive) and the corner where the chain starts.
+type Wiring int
+
+const (
+	// The chain runs down the first column, up the second, etc.
+	ColSerpentine Wiring = iota
+	// The chain runs along the first row, back along the second, etc.
+	RowSerpentine
+	// Every column is run in the same direction.
+	ColProgressive
+	// Every row is run in the same direction.
+	RowProgressive
+)
+
+var wiringNames = []string{"CS", "RS", "CP", "RP"}
+
+func (w Wiring) String() string {
+	if w < 0 || int(w) >= len(wiringNames) {
+		return "(unknown)"
+	}
+	return wiringNames[w]
+}
+
+// The corner of a module (in its base position) where the chain starts.
+type Corner int
 
 const (
+	TopLeft Corner = iota
+	TopRight
+	BottomLeft
+	BottomRight
+)
+
+var cornerNames = []string{"TL", "TR", "BL", "BR"}
+
+func (c Corner) String() string {
+	if c < 0 || int(c) >= len(cornerNames) {
+		return "(unknown)"
+	}
+	return cornerNames[c]
+}
+
+// A module type describes the size of a module (in pixels) and its wiring.
+// The zero value has no LEDs.
+type ModuleType struct {
+	Size   image.Point
+	Wiring Wiring
+	Start  Corner
+}
+
+// The classic 10x10 modules come in two types (with respect to the
+// cabeling).
+var (
 	// Modules of this type start the cabeling at the top left corner and
 	// end at the top right corner, therefore the cabeling runs from left to
 	// right.
-	ModLR ModuleType = iota
+	ModLR = ModuleType{ModuleDim, ColSerpentine, TopLeft}
 	// This module type start the cabeling at the top right corner and end at
 	// the top left corner (running the cable from right to left).
-	ModRL
+	ModRL = ModuleType{ModuleDim, ColSerpentine, TopRight}
 )
 
+// Returns the number of LEDs on a module of this type.
+func (m ModuleType) NumLeds() int {
+	return m.Size.X * m.Size.Y
+}
+
+// Mirrors pt such that the chain of the module type starts at the top left
+// corner. The mapping is its own inverse.
+func (m ModuleType) mirror(pt image.Point) image.Point {
+	if m.Start == TopRight || m.Start == BottomRight {
+		pt.X = m.Size.X - 1 - pt.X
+	}
+	if m.Start == BottomLeft || m.Start == BottomRight {
+		pt.Y = m.Size.Y - 1 - pt.Y
+	}
+	return pt
+}
+
 // Returns the index of a pixel within a certain type of module. pt is a
-// point with coordinates in the range of ModuleDim. The returned value
-// is a number in the interval [0,99] or -1 if the coordinates are outside
-// the module.
+// point with coordinates in the range of Size. The returned value is a
+// number in the interval [0,NumLeds()-1] or -1 if the coordinates are
+// outside the module.
 func (m ModuleType) Index(pt image.Point) int {
-	idx := 0
-	if m == ModRL {
-		pt.X = ModuleDim.X - 1 - pt.X
+	if !pt.In(image.Rectangle{Max: m.Size}) {
+		return -1
 	}
-	idx = ModuleDim.Y * pt.X
-	if pt.X%2 == 0 {
-		idx += pt.Y
-	} else {
-		idx += (ModuleDim.Y - 1 - pt.Y)
+	pt = m.mirror(pt)
+	switch m.Wiring {
+	case RowSerpentine, RowProgressive:
+		if m.Wiring == RowSerpentine && pt.Y%2 == 1 {
+			pt.X = m.Size.X - 1 - pt.X
+		}
+		return m.Size.X*pt.Y + pt.X
+	default:
+		if m.Wiring == ColSerpentine && pt.X%2 == 1 {
+			pt.Y = m.Size.Y - 1 - pt.Y
+		}
+		return m.Size.Y*pt.X + pt.Y
 	}
-	return idx
 }
 
 // Returns the coordinates of a pixel with index idx. idx must be a number
-// in the interval [0,99] and the returned coordinate is within a rectangle
-// of size ModuleDim. If idx is outside the valid interval, the returned
-// coordinates are (-1,-1).
+// in the interval [0,NumLeds()-1] and the returned coordinate is within a
+// rectangle of size Size. If idx is outside the valid interval, the
+// returned coordinates are (-1,-1).
 func (m ModuleType) Coord(idx int) image.Point {
-	col, row := 0, 0
-	x := idx / ModuleDim.Y
-	y := idx % ModuleDim.Y
-	if m == ModLR {
-		col = x
-	} else {
-		col = (ModuleDim.X - 1 - x)
+	var pt image.Point
+
+	if idx < 0 || idx >= m.NumLeds() {
+		return image.Point{-1, -1}
 	}
-	if x%2 == 0 {
-		row = y
-	} else {
-		row = (ModuleDim.Y - 1 - y)
+	switch m.Wiring {
+	case RowSerpentine, RowProgressive:
+		pt = image.Point{idx % m.Size.X, idx / m.Size.X}
+		if m.Wiring == RowSerpentine && pt.Y%2 == 1 {
+			pt.X = m.Size.X - 1 - pt.X
+		}
+	default:
+		pt = image.Point{idx / m.Size.Y, idx % m.Size.Y}
+		if m.Wiring == ColSerpentine && pt.X%2 == 1 {
+			pt.Y = m.Size.Y - 1 - pt.Y
+		}
 	}
-	return image.Point{col, row}
+	return m.mirror(pt)
 }
 
-// Returns the correct description of the module ("LR" or "RL").
+// Returns the description of the module type: "LR" or "RL" for the classic
+// modules, otherwise size, wiring and start corner like in "8x32-CS-TL"
+// (see Wiring and Corner for the abbreviations).
 func (m ModuleType) String() string {
 	switch m {
 	case ModLR:
@@ -144,18 +236,35 @@ func (m ModuleType) String() string {
 	case ModRL:
 		return "RL"
 	}
-	return "(unknown)"
+	return fmt.Sprintf("%dx%d-%v-%v", m.Size.X, m.Size.Y, m.Wiring, m.Start)
 }
 
 // This method is used to set the type of a module according to a description
-// (a string) in a configuration file.
+// (a string) in a configuration file (see String).
 func (m *ModuleType) Set(v string) error {
+	var w, h int
+
 	switch v {
 	case "LR":
 		*m = ModLR
+		return nil
 	case "RL":
 		*m = ModRL
+		return nil
+	}
+	fields := strings.Split(v, "-")
+	if len(fields) != 3 {
+		return fmt.Errorf("unknown module type '%s'", v)
+	}
+	if _, err := fmt.Sscanf(fields[0], "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 {
+		return fmt.Errorf("invalid size in module type '%s'", v)
+	}
+	wiring := slices.Index(wiringNames, fields[1])
+	start := slices.Index(cornerNames, fields[2])
+	if wiring < 0 || start < 0 {
+		return fmt.Errorf("invalid wiring in module type '%s'", v)
 	}
+	*m = ModuleType{image.Point{w, h}, Wiring(wiring), Corner(start)}
 	return nil
 }
 
@@ -194,6 +303,8 @@ func (r *RotationType) Set(v string) error {
 		*r = Rot180
 	case "270":
 		*r = Rot270
+	default:
+		return fmt.Errorf("unknown rotation '%s'", v)
 	}
 	return nil
 }
@@ -218,17 +329,31 @@ var (
 	ModRL270 = Module{ModRL, Rot270}
 )
 
+// Returns the size of the module in its rotated position.
+func (m Module) Size() image.Point {
+	if m.Rot == Rot090 || m.Rot == Rot270 {
+		return image.Point{m.Type.Size.Y, m.Type.Size.X}
+	}
+	return m.Type.Size
+}
+
+// Returns the number of LEDs on this module.
+func (m Module) NumLeds() int {
+	return m.Type.NumLeds()
+}
+
 // Returns the index of a point pt within this module. If pt is outside the
-// range ModuleDim, the method returns -1. See [ModuleType.Index] for more
+// range Size(), the method returns -1. See [ModuleType.Index] for more
 // information.
 func (m Module) Index(pt image.Point) int {
+	size := m.Type.Size
 	switch m.Rot {
 	case Rot090:
-		pt.X, pt.Y = (ModuleDim.X - 1 - pt.Y), pt.X
+		pt.X, pt.Y = (size.X - 1 - pt.Y), pt.X
 	case Rot180:
-		pt.X, pt.Y = (ModuleDim.X - 1 - pt.X), (ModuleDim.Y - 1 - pt.Y)
+		pt.X, pt.Y = (size.X - 1 - pt.X), (size.Y - 1 - pt.Y)
 	case Rot270:
-		pt.X, pt.Y = pt.Y, (ModuleDim.Y - 1 - pt.X)
+		pt.X, pt.Y = pt.Y, (size.Y - 1 - pt.X)
 	}
 	return m.Type.Index(pt)
 }
@@ -236,14 +361,18 @@ func (m Module) Index(pt image.Point) int {
 // Returns the coordinate of a pixel given its index within the chain, forming
 // this module. See [ModuleType.Coord] for more information.
 func (m Module) Coord(idx int) image.Point {
+	size := m.Type.Size
 	pt := m.Type.Coord(idx)
+	if pt.X < 0 {
+		return pt
+	}
 	switch m.Rot {
 	case Rot090:
-		pt.X, pt.Y = pt.Y, (ModuleDim.Y - 1 - pt.X)
+		pt.X, pt.Y = pt.Y, (size.X - 1 - pt.X)
 	case Rot180:
-		pt.X, pt.Y = (ModuleDim.X - 1 - pt.X), (ModuleDim.Y - 1 - pt.Y)
+		pt.X, pt.Y = (size.X - 1 - pt.X), (size.Y - 1 - pt.Y)
 	case Rot270:
-		pt.X, pt.Y = (ModuleDim.X - 1 - pt.Y), pt.X
+		pt.X, pt.Y = (size.Y - 1 - pt.Y), pt.X
 	}
 	return pt
 }
@@ -278,56 +407,91 @@ func (m Module) MarshalText() ([]byte, error) {
 // UnmarshalText implement the TextUnmarshaler interface of package encoding.
 // This allows to configure modules using JSON files.
 func (m *Module) UnmarshalText(text []byte) error {
-	slc := strings.Split(string(text), ":")
-	m.Type.Set(slc[0])
-	m.Rot.Set(slc[1])
-	return nil
+	typ, rot, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("module '%s' has no rotation", text)
+	}
+	if err := m.Type.Set(typ); err != nil {
+		return err
+	}
+	return m.Rot.Set(rot)
 }
 
 // This type finally puts all information together: what module is placed at
 // column Col and row Row of the LedGrid. Col and Row denote the position of
-// the module (not a pixel) within the LedGrid. Idx finally is the index of
-// the first pixel of this module within the whole chain of pixels, therefore
-// this number must be a multiple of ModuleDim.X*ModuleDim.Y.
+// the module on a grid of ModuleDim pixels. Modules of other sizes can be
+// placed at arbitrary pixels with the offset (X, Y), which is added to the
+// position on the grid. Together, Col, Row, X and Y identify a module (e.g.
+// in a Calibration). Idx finally is the index of the first pixel of this
+// module within the whole chain of pixels. Order is the color order of the
+// NeoPixels on this module, if it differs from the one of the displayer (see
+// ColorOrder).
 type ModulePosition struct {
-	Col int    `json:"Col"`
-	Row int    `json:"Row"`
-	Mod Module `json:"Mod"`
-	Idx int    `json:"-"`
+	Col   int        `json:"Col" toml:"Col"`
+	Row   int        `json:"Row" toml:"Row"`
+	X     int        `json:"X,omitempty" toml:"X,omitzero"`
+	Y     int        `json:"Y,omitempty" toml:"Y,omitzero"`
+	Mod   Module     `json:"Mod" toml:"Mod"`
+	Order ColorOrder `json:"Order,omitempty" toml:"Order,omitzero"`
+	Idx   int        `json:"-" toml:"-"`
+}
+
+// Returns the position of the top left pixel of this module.
+func (m ModulePosition) Origin() image.Point {
+	return image.Point{m.Col*ModuleDim.X + m.X, m.Row*ModuleDim.Y + m.Y}
 }
 
 // Returns the enclosing rectangle of this module, specified in pixel
 // coordinates.
 func (m ModulePosition) Bounds() image.Rectangle {
-	x0, y0 := m.Col*ModuleDim.X, m.Row*ModuleDim.Y
-	x1, y1 := x0+ModuleDim.X, y0+ModuleDim.Y
-	return image.Rect(x0, y0, x1, y1)
+	origin := m.Origin()
+	return image.Rectangle{origin, origin.Add(m.Mod.Size())}
+}
+
+// Returns the number of LEDs on this module.
+func (m ModulePosition) NumLeds() int {
+	return m.Mod.NumLeds()
+}
+
+// Returns true if m is placed at the same position as other, i.e. if both
+// refer to the same module.
+func (m ModulePosition) SamePlace(other ModulePosition) bool {
+	return m.Col == other.Col && m.Row == other.Row &&
+		m.X == other.X && m.Y == other.Y
 }
 
 func (m ModulePosition) Index(pt image.Point) int {
-	pt.X, pt.Y = pt.X%ModuleDim.X, pt.Y%ModuleDim.Y
-	return m.Idx + m.Mod.Index(pt)
+	return m.Idx + m.Mod.Index(pt.Sub(m.Origin()))
 }
 
 func (m ModulePosition) Coord(idx int) image.Point {
-	pt := image.Point{m.Col * ModuleDim.X, m.Row * ModuleDim.Y}
-	return pt.Add(m.Mod.Coord(idx - m.Idx))
+	return m.Origin().Add(m.Mod.Coord(idx - m.Idx))
 }
 
+var (
+	// A requested panel size isn't a multiple of ModuleDim.
+	ErrBadSize = errors.New("size is not a multiple of the module size")
+	// A configuration is not valid, e.g. two consecutive modules on the
+	// chain are not adjacent.
+	ErrBadConfig = errors.New("invalid module configuration")
+)
+
 // Der Typ ModuleConfig schliesslich dient dazu, eine komplette
 // Modul-Konfiguration zu speichern. Die Reihenfolge der Module ist relevant
 // und entspricht der Verkabelung (d.h. die Einspeisung beginnt beim Modul
 // an Position [0], geht dann zum Modul an Position [1] weiter, etc.)
 type ModuleConfig []ModulePosition
 
-func DefaultModuleConfig(size image.Point) ModuleConfig {
+// Creates a module configuration for a rectangular panel of the given size
+// (in pixels). Returns ErrBadSize if size is not a multiple of ModuleDim.
+func DefaultModuleConfig(size image.Point) (ModuleConfig, error) {
 	var col, row int
 	var conf ModuleConfig
 	var mod Module
 
 	if size.X <= 0 || size.Y <= 0 ||
 		size.X%ModuleDim.X != 0 || size.Y%ModuleDim.Y != 0 {
-		log.Fatalf("Requested size of LED-Grid '%v' does not match with size of a module '%v'", size, ModuleDim)
+		return nil, fmt.Errorf("%w: requested size of LED-Grid '%v' does not match with size of a module '%v'", ErrBadSize, size, ModuleDim)
 	}
 	cols, rows := size.X/ModuleDim.X, size.Y/ModuleDim.Y
 
@@ -343,10 +507,12 @@ func DefaultModuleConfig(size image.Point) ModuleConfig {
 			if col == cols-1 {
 				mod = ModRL090
 			}
-			conf.AddModule(col, row, mod)
+			if err := conf.AddModule(col, row, mod); err != nil {
+				return nil, err
+			}
 		}
 	}
-	return conf
+	return conf, nil
 }
 
 //go:embed data/*.json
@@ -360,85 +526,262 @@ func AllCustomFiles() (fileList []string) {
 	return fileList
 }
 
-func Load(fileName string) ModuleConfig {
-	var conf ModuleConfig
+// Returns the file name for the configuration name given on the command
+// line (option -custom): names with an extension (".json" or ".toml") are
+// file names already, otherwise the name denotes the file data/name.json
+// (see Load).
+func CustomFileName(name string) string {
+	if filepath.Ext(name) != "" {
+		return name
+	}
+	return "data/" + name + ".json"
+}
 
-	data, err := customFiles.ReadFile(fileName)
+// Loads a module configuration. fileName is first looked up in the file
+// system (absolute or relative to the working directory), then in the
+// embedded configuration files (see AllCustomFiles). New configurations can
+// therefore be added without recompiling, and a file like data/tetris.json
+// in the working directory takes precedence over the embedded one. See
+// LoadFS for the formats and the validation.
+func Load(fileName string) (ModuleConfig, error) {
+	data, err := readFile(customFiles, fileName)
 	if err != nil {
-		log.Fatalf("Couldn't read file: %v", err)
+		return nil, err
+	}
+	return decodeModuleConfig(fileName, data)
+}
+
+// Reads the file fileName from the file system or, if there is no such
+// file, from the embedded files efs.
+func readFile(efs embed.FS, fileName string) ([]byte, error) {
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) && fs.ValidPath(path.Clean(fileName)) {
+		data, err = efs.ReadFile(path.Clean(fileName))
+	}
+	return data, err
+}
+
+// Loads a module configuration from the file system fsys. Files with the
+// extension ".toml" are read as TOML with a table [[Modules]] per module,
+// all others as JSON. Errors of the file system are returned as they are
+// (e.g. fs.ErrNotExist). Undecodable data, unknown fields and invalid
+// configurations (see Verify) are reported with ErrBadConfig and the name
+// of the file.
+func LoadFS(fsys fs.FS, fileName string) (ModuleConfig, error) {
+	data, err := fs.ReadFile(fsys, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return decodeModuleConfig(fileName, data)
+}
+
+// The TOML representation of a module configuration: TOML has no arrays
+// at the top level.
+type tomlModuleConfig struct {
+	Modules []ModulePosition
+}
+
+func decodeModuleConfig(fileName string, data []byte) (ModuleConfig, error) {
+	var modList []ModulePosition
+	var err error
+
+	if strings.EqualFold(path.Ext(fileName), ".toml") {
+		var doc tomlModuleConfig
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), &doc)
+		if undecoded := md.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = fmt.Errorf("unknown field '%v'", undecoded[0])
+		}
+		modList = doc.Modules
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = jsonErrorLine(data, dec.Decode(&modList))
 	}
-	err = json.Unmarshal(data, &conf)
 	if err != nil {
-		log.Fatalf("Couldn't decode json data: %v", err)
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadConfig, fileName, err)
+	}
+	conf := ModuleConfig(modList)
+	conf.updateIndices()
+	if err = conf.Verify(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Adds the line number to errors of the JSON decoder, which only know the
+// offset within data.
+func jsonErrorLine(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	line := 1 + bytes.Count(data[:min(offset, int64(len(data)))], []byte("\n"))
+	return fmt.Errorf("line %d: %w", line, err)
+}
+
+// Idx is not part of the JSON representation, since it is given by the
+// position of the module within the chain. It is set here, after decoding,
+// in order to have a usable configuration regardless of the source
+// (file or REST API of the GridServer).
+func (conf *ModuleConfig) UnmarshalJSON(data []byte) error {
+	var modList []ModulePosition
+
+	if err := json.Unmarshal(data, &modList); err != nil {
+		return err
 	}
+	ModuleConfig(modList).updateIndices()
+	*conf = modList
+	return nil
+}
+
+// Sets the indices of the modules (see ModulePosition) according to their
+// position in the chain.
+func (conf ModuleConfig) updateIndices() {
+	idx := 0
 	for i := range conf {
-		conf[i].Idx = i * ModuleDim.X * ModuleDim.Y
+		conf[i].Idx = idx
+		idx += conf[i].NumLeds()
 	}
-	return conf
 }
 
-// Speichert die Konfiguration in conf in der Datei fileName ab.
-func (conf ModuleConfig) Save(fileName string) {
-	data, err := json.MarshalIndent(conf, "", "    ")
-	if err != nil {
-		log.Fatalf("Couldn't encode data: %v", err)
+// Speichert die Konfiguration in conf in der Datei fileName ab. Hat
+// fileName die Endung ".toml", wird TOML verwendet, sonst JSON.
+func (conf ModuleConfig) Save(fileName string) error {
+	var data []byte
+	var err error
+
+	if strings.EqualFold(filepath.Ext(fileName), ".toml") {
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		err = enc.Encode(tomlModuleConfig{conf})
+		data = buf.Bytes()
+	} else {
+		data, err = json.MarshalIndent(conf, "", "    ")
 	}
-	err = os.WriteFile(fileName, data, 0644)
 	if err != nil {
-		log.Fatalf("Couldn't write to file: %v", err)
+		return err
 	}
+	return os.WriteFile(fileName, data, 0644)
 }
 
 // Helps to build up a module configuration. Important: the Add's must
 // be done along the LED chain. The configuration will be verified after
-// each add.
-func (conf *ModuleConfig) AddModule(col, row int, mod Module) {
-	modPos := ModulePosition{Col: col, Row: row, Mod: mod, Idx: len(*conf) * ModuleDim.X * ModuleDim.Y}
+// each add; if the new module doesn't fit, it is removed again and the
+// error is returned.
+func (conf *ModuleConfig) AddModule(col, row int, mod Module) error {
+	return conf.AddModulePosition(ModulePosition{Col: col, Row: row, Mod: mod})
+}
+
+// Like AddModule, but the module is given with its complete position (e.g.
+// with a pixel offset). Idx is set by this method.
+func (conf *ModuleConfig) AddModulePosition(modPos ModulePosition) error {
+	modPos.Idx = conf.NumLeds()
 	*conf = append(*conf, modPos)
 	if err := conf.VerifyModule(len(*conf) - 1); err != nil {
-		log.Fatalf("Can't add module: %v", err)
+		*conf = (*conf)[:len(*conf)-1]
+		return err
 	}
+	return nil
 }
 
+// Checks the module with index i: it must have LEDs, must not start at a
+// negative pixel position, must not be placed at the same position as or
+// overlap one of the preceding modules and its first LED must be adjacent
+// to the last LED of the preceding module.
 func (conf ModuleConfig) VerifyModule(i int) error {
+	if i < 0 || i >= len(conf) {
+		return fmt.Errorf("%w: no module with index %d", ErrBadConfig, i)
+	}
+	modPos := conf[i]
+	if modPos.NumLeds() == 0 {
+		return fmt.Errorf("%w: module %d has no LEDs", ErrBadConfig, i)
+	}
+	if origin := modPos.Origin(); origin.X < 0 || origin.Y < 0 {
+		return fmt.Errorf("%w: module %d starts at negative position %v",
+			ErrBadConfig, i, origin)
+	}
+	for j, other := range conf[:i] {
+		if modPos.SamePlace(other) {
+			return fmt.Errorf("%w: modules %d and %d are both placed at (%d,%d)",
+				ErrBadConfig, j, i, modPos.Col, modPos.Row)
+		}
+		if modPos.Bounds().Overlaps(other.Bounds()) {
+			return fmt.Errorf("%w: modules %d and %d overlap in %v", ErrBadConfig,
+				j, i, modPos.Bounds().Intersect(other.Bounds()))
+		}
+	}
 	if i == 0 {
 		return nil
 	}
-	if i >= len(conf) {
-		return errors.New(fmt.Sprintf("no module with index %d", i))
-	}
-	idxA := i*ModuleDim.X*ModuleDim.Y - 1
-	idxB := idxA + 1
-	ptA := conf.Coord(idxA)
-	ptB := conf.Coord(idxB)
+	prev := conf[i-1]
+	ptA := prev.Coord(prev.Idx + prev.NumLeds() - 1)
+	ptB := modPos.Coord(modPos.Idx)
 	dx := abs(ptA.X - ptB.X)
 	dy := abs(ptA.Y - ptB.Y)
 	if dx > 1 || dy > 1 {
-		return errors.New(fmt.Sprintf("from module %d to %d: %v and %v are not adjacent", i-1, i, ptA, ptB))
+		return fmt.Errorf("%w: from module %d to %d: %v and %v are not adjacent", ErrBadConfig, i-1, i, ptA, ptB)
 	}
 	return nil
 }
 
+// Checks all modules of the configuration (see VerifyModule). All problems
+// found are reported, each on a line of its own.
 func (conf ModuleConfig) Verify() error {
-	for i := range conf[1:] {
-		err := conf.VerifyModule(i + 1)
-		if err != nil {
-			return err
-		}
+	var errs []error
+
+	if len(conf) == 0 {
+		return fmt.Errorf("%w: no modules", ErrBadConfig)
 	}
-	return nil
+	for i := range conf {
+		errs = append(errs, conf.VerifyModule(i))
+	}
+	return errors.Join(errs...)
 }
 
 // Returns the size of the LEDGrid as number of pixels.
 func (conf ModuleConfig) Size() image.Point {
 	size := image.Point{}
 	for _, modPos := range conf {
-		size.X = max(size.X, ModuleDim.X*(modPos.Col+1))
-		size.Y = max(size.Y, ModuleDim.Y*(modPos.Row+1))
+		size.X = max(size.X, modPos.Bounds().Max.X)
+		size.Y = max(size.Y, modPos.Bounds().Max.Y)
 	}
 	return size
 }
 
+// Returns the number of LEDs on the whole chain.
+func (conf ModuleConfig) NumLeds() int {
+	numLeds := 0
+	for _, modPos := range conf {
+		numLeds += modPos.NumLeds()
+	}
+	return numLeds
+}
+
+// Returns the module which contains the LED with index idx on the chain or
+// nil.
+func (conf ModuleConfig) Module(idx int) *ModulePosition {
+	i, found := slices.BinarySearchFunc(conf, idx, func(m ModulePosition, idx int) int {
+		return m.Idx - idx
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || i >= len(conf) || idx >= conf[i].Idx+conf[i].NumLeds() {
+		return nil
+	}
+	return &conf[i]
+}
+
 // Returns the index of the position pt within the LED chain or -1 if this
 // position is not on a module.
 func (conf ModuleConfig) Index(pt image.Point) int {
@@ -450,9 +793,13 @@ func (conf ModuleConfig) Index(pt image.Point) int {
 	return -1
 }
 
-// Returns the coordinates of the LED with index idx.
+// Returns the coordinates of the LED with index idx or (-1,-1) if there is
+// no such LED.
 func (conf ModuleConfig) Coord(idx int) image.Point {
-	modPos := conf[idx/(ModuleDim.X*ModuleDim.Y)]
+	modPos := conf.Module(idx)
+	if modPos == nil {
+		return image.Point{-1, -1}
+	}
 	return modPos.Coord(idx)
 }
 
@@ -481,22 +828,61 @@ type CoordMap []image.Point
 func (conf ModuleConfig) IndexMap() IndexMap {
 	var idxMap IndexMap
 
-	size := conf.Size()
-	idxMap = make([][]int, size.X)
+	pixMap := conf.PixelMap()
+	idxMap = make([][]int, pixMap.Size.X)
 	for col := range idxMap {
-		idxMap[col] = make([]int, size.Y)
-	}
-	for row := range size.Y {
-		for col := range size.X {
-			idxMap[col][row] = conf.Index(image.Point{col, row})
+		idxMap[col] = make([]int, pixMap.Size.Y)
+		for row := range idxMap[col] {
+			idxMap[col][row] = pixMap.Index[row*pixMap.Size.X+col]
 		}
 	}
 	return idxMap
 }
 
+// Die PixelMap ist die vorberechnete Zuordnung zwischen Pixeln und LEDs in
+// flacher Form, womit grosse Panels (auch mit Loechern) ohne Suche ueber die
+// Module angesteuert werden koennen. Index enthaelt zeilenweise fuer jedes
+// Pixel den Index der LED auf der Kette (oder -1, wenn an dieser Stelle
+// kein Modul ist). Gather enthaelt fuer jede LED der Kette den Offset ihres
+// Pixels in Index (y*Size.X+x); dies ist gleichzeitig die Nummer des
+// Pixels in einem image.RGBA der Groesse Size.
+type PixelMap struct {
+	Size   image.Point
+	Index  []int
+	Gather []int
+}
+
+// Erstellt die PixelMap zu dieser Konfiguration.
+func (conf ModuleConfig) PixelMap() PixelMap {
+	pixMap := PixelMap{Size: conf.Size()}
+	pixMap.Index = make([]int, pixMap.Size.X*pixMap.Size.Y)
+	for i := range pixMap.Index {
+		pixMap.Index[i] = -1
+	}
+	pixMap.Gather = make([]int, conf.NumLeds())
+	for _, modPos := range conf {
+		for idx := modPos.Idx; idx < modPos.Idx+modPos.NumLeds(); idx++ {
+			pt := modPos.Coord(idx)
+			offset := pt.Y*pixMap.Size.X + pt.X
+			pixMap.Index[offset] = idx
+			pixMap.Gather[idx] = offset
+		}
+	}
+	return pixMap
+}
+
+// Liefert den Index der LED an Position (x, y) oder -1, falls sich dort
+// keine LED befindet.
+func (m PixelMap) Lookup(x, y int) int {
+	if x < 0 || y < 0 || x >= m.Size.X || y >= m.Size.Y {
+		return -1
+	}
+	return m.Index[y*m.Size.X+x]
+}
+
 // Mit dieser Methode kann der entsprechende CoordMap erstellt werden.
 func (conf ModuleConfig) CoordMap() CoordMap {
-	coordMap := make([]image.Point, len(conf)*ModuleDim.X*ModuleDim.Y)
+	coordMap := make([]image.Point, conf.NumLeds())
 	for idx := range coordMap {
 		coordMap[idx] = conf.Coord(idx)
 	}
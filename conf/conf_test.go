@@ -1,8 +1,17 @@
 package conf
 
 import (
+	"encoding/json"
+	"errors"
 	"image"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 const (
@@ -107,7 +116,10 @@ func TestDefaultModuleConfig(t *testing.T) {
 	}
 	idxList = []int{0, 109, 290, 399}
 
-	modConf := DefaultModuleConfig(image.Point{20, 20})
+	modConf, err := DefaultModuleConfig(image.Point{20, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Logf("Module config: %v", modConf)
 	t.Logf("Testing index map")
 	idxMap := modConf.IndexMap()
@@ -119,32 +131,148 @@ func TestDefaultModuleConfig(t *testing.T) {
 	for _, idx := range idxList {
 		t.Logf("  %2d -> %v", idx, coordMap[idx])
 	}
+
+	if _, err = DefaultModuleConfig(image.Point{15, 20}); !errors.Is(err, ErrBadSize) {
+		t.Errorf("expected ErrBadSize, got %v", err)
+	}
+}
+
+func TestModuleGeometry(t *testing.T) {
+	sizes := []image.Point{{8, 32}, {3, 5}, ModuleDim}
+	rots := []RotationType{Rot000, Rot090, Rot180, Rot270}
+
+	for _, size := range sizes {
+		for wiring := range Wiring(len(wiringNames)) {
+			for start := range Corner(len(cornerNames)) {
+				modType := ModuleType{size, wiring, start}
+				var parsed ModuleType
+				if err := parsed.Set(modType.String()); err != nil || parsed != modType {
+					t.Errorf("Set(%q): got %v, %v", modType.String(), parsed, err)
+				}
+				for _, rot := range rots {
+					mod := Module{modType, rot}
+					bounds := image.Rectangle{Max: mod.Size()}
+					for idx := range mod.NumLeds() {
+						pt := mod.Coord(idx)
+						if !pt.In(bounds) || mod.Index(pt) != idx {
+							t.Fatalf("%v: %d -> %v -> %d", mod, idx, pt, mod.Index(pt))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var modType ModuleType
+	for _, v := range []string{"8x32", "0x8-CS-TL", "8x8-XY-TL", "8x8-CS-XY"} {
+		if err := modType.Set(v); err == nil {
+			t.Errorf("expected error for module type '%s'", v)
+		}
+	}
+}
+
+func TestMixedConfig(t *testing.T) {
+	modConf, err := Load("data/mixed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = modConf.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if size := modConf.Size(); size != (image.Point{34, 32}) {
+		t.Errorf("Size: got %v", size)
+	}
+	if n := modConf.NumLeds(); n != 612 {
+		t.Errorf("NumLeds: got %d", n)
+	}
+	if mod := modConf.Module(300); mod == nil || mod.Idx != 256 {
+		t.Errorf("Module(300): got %+v", mod)
+	}
+
+	// Jede LED muss genau einmal im IndexMap vorkommen, Pixel ohne Modul
+	// haben den Index -1.
+	idxMap := modConf.IndexMap()
+	coordMap := modConf.CoordMap()
+	count := 0
+	for col := range idxMap {
+		for row, idx := range idxMap[col] {
+			if idx < 0 {
+				continue
+			}
+			count++
+			if coordMap[idx] != (image.Point{col, row}) {
+				t.Errorf("(%d,%d) -> %d -> %v", col, row, idx, coordMap[idx])
+			}
+		}
+	}
+	if count != len(coordMap) {
+		t.Errorf("IndexMap has %d LEDs, CoordMap %d", count, len(coordMap))
+	}
+
+	// Die PixelMap muss die gleiche Zuordnung liefern.
+	pixMap := modConf.PixelMap()
+	for col := range idxMap {
+		for row, idx := range idxMap[col] {
+			if got := pixMap.Lookup(col, row); got != idx {
+				t.Errorf("Lookup(%d,%d): got %d, want %d", col, row, got, idx)
+			}
+		}
+	}
+	for idx, offset := range pixMap.Gather {
+		pt := coordMap[idx]
+		if offset != pt.Y*pixMap.Size.X+pt.X {
+			t.Errorf("Gather[%d]: got %d, want %v", idx, offset, pt)
+		}
+	}
+	if pixMap.Lookup(-1, 0) != -1 || pixMap.Lookup(34, 0) != -1 {
+		t.Errorf("Lookup outside of the map must return -1")
+	}
+
+	// Ein weiteres Modul, welches ein bestehendes ueberlappt, darf nicht
+	// hinzugefuegt werden.
+	err = modConf.AddModulePosition(ModulePosition{Col: 3, Row: 0,
+		Mod: Module{ModuleType{image.Point{4, 4}, RowSerpentine, TopLeft}, Rot000}})
+	if !errors.Is(err, ErrBadConfig) || len(modConf) != 3 {
+		t.Errorf("expected ErrBadConfig for overlapping module, got %v", err)
+	}
 }
 
 func TestVerify(t *testing.T) {
 	t.Logf("Verify Default Configuration")
-	modConf := DefaultModuleConfig(image.Point{30, 30})
-	err := modConf.Verify()
+	modConf, err := DefaultModuleConfig(image.Point{30, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = modConf.Verify()
 	if err != nil {
 		t.Error(err)
 	}
 
 	t.Logf("Verify Custom Configuration (Tetris)")
-	modConf = Load("data/tetris.json")
+	modConf, err = Load("data/tetris.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = modConf.Verify()
 	if err != nil {
 		t.Error(err)
 	}
 
 	t.Logf("Verify Custom Configuration (LowerCurve)")
-	modConf = Load("data/lowerCurve.json")
+	modConf, err = Load("data/lowerCurve.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = modConf.Verify()
 	if err != nil {
 		t.Error(err)
 	}
 
 	t.Logf("Verify Custom Configuration (SquareWithHole)")
-	modConf = Load("data/squareWithHole.json")
+	modConf, err = Load("data/squareWithHole.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = modConf.Verify()
 	if err != nil {
 		t.Error(err)
@@ -200,6 +328,351 @@ func TestLoadCustomConf(t *testing.T) {
 	var conf ModuleConfig
 
 	t.Logf("Load custom configuration")
-	conf = Load("data/tetris.json")
+	conf, err := Load("data/tetris.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Logf("%v", conf)
+
+	if _, err = Load("data/noSuchFile.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"wall.json": {Data: []byte(`[
+    {"Col": 0, "Row": 0, "Mod": "LR:0"},
+    {"Col": 1, "Row": 0, "Mod": "RL:90"}
+]`)},
+		"wall.toml": {Data: []byte(`
+[[Modules]]
+Col = 0
+Row = 0
+Mod = "LR:0"
+
+[[Modules]]
+Col = 1
+Row = 0
+Mod = "RL:90"
+Order = "GRB"
+`)},
+		"typo.json":   {Data: []byte(`[{"Col": 0, "Rwo": 0, "Mod": "LR:0"}]`)},
+		"typo.toml":   {Data: []byte("[[Modules]]\nCol = 0\nRwo = 0\nMod = \"LR:0\"\n")},
+		"syntax.json": {Data: []byte("[\n    {\"Col\": 0,\n    {\"Col\": 1}\n]")},
+		"bad.json": {Data: []byte(`[
+    {"Col": 0, "Row": 0, "Mod": "LR:0"},
+    {"Col": 0, "Row": 0, "Mod": "LR:0"},
+    {"Col": 3, "Row": 0, "Mod": "LR:0"}
+]`)},
+		"negative.json": {Data: []byte(`[{"Col": 0, "Row": 0, "X": -5, "Mod": "LR:0"}]`)},
+	}
+
+	jsonConf, err := LoadFS(fsys, "wall.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomlConf, err := LoadFS(fsys, "wall.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tomlConf) != 2 || tomlConf[1].Idx != 100 || tomlConf[1].Order != GRB ||
+		tomlConf[1].Mod != jsonConf[1].Mod {
+		t.Errorf("TOML configuration: %+v", tomlConf)
+	}
+
+	for _, tc := range []struct {
+		fileName string
+		msgs     []string
+	}{
+		{"typo.json", []string{"Rwo"}},
+		{"typo.toml", []string{"Rwo"}},
+		{"syntax.json", []string{"line 3"}},
+		{"bad.json", []string{"modules 0 and 1 are both placed at (0,0)",
+			"from module 1 to 2"}},
+		{"negative.json", []string{"module 0 starts at negative position (-5,0)"}},
+	} {
+		_, err := LoadFS(fsys, tc.fileName)
+		if !errors.Is(err, ErrBadConfig) {
+			t.Errorf("%s: expected ErrBadConfig, got %v", tc.fileName, err)
+			continue
+		}
+		for _, msg := range tc.msgs {
+			if !strings.Contains(err.Error(), msg) || !strings.Contains(err.Error(), tc.fileName) {
+				t.Errorf("%s: error '%v' doesn't mention '%s'", tc.fileName, err, msg)
+			}
+		}
+	}
+	if _, err = LoadFS(fsys, "noSuchFile.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	// Dateien im Dateisystem haben Vorrang vor den eingebetteten.
+	fileName := filepath.Join(t.TempDir(), "wall.toml")
+	if err = tomlConf.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := Load(fileName); err != nil || !slices.Equal(loaded, tomlConf) {
+		t.Errorf("Load(%s): got %+v, %v", fileName, loaded, err)
+	}
+	if CustomFileName("tetris") != "data/tetris.json" || CustomFileName(fileName) != fileName {
+		t.Errorf("CustomFileName: unexpected file names")
+	}
+}
+
+func TestColorOrder(t *testing.T) {
+	var order ColorOrder
+
+	if ch := GRB.Channels(); ch != [3]int{1, 0, 2} {
+		t.Errorf("GRB: expected channels [1 0 2], got %v", ch)
+	}
+	if ch := BGR.Channels(); ch != [3]int{2, 1, 0} {
+		t.Errorf("BGR: expected channels [2 1 0], got %v", ch)
+	}
+	if err := order.Set("BRG"); err != nil || order != BRG {
+		t.Errorf("Set: expected BRG, got %v (%v)", order, err)
+	}
+	if err := order.Set("XYZ"); err == nil {
+		t.Errorf("Set: expected error for unknown order")
+	}
+
+	modConf, err := DefaultModuleConfig(image.Point{Width, Height})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modConf[1].Order = GRB
+	data, err := json.Marshal(modConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded ModuleConfig
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	orders := loaded.ColorOrders(RGB)
+	modSize := ModuleDim.X * ModuleDim.Y
+	if orders[0] != RGB || orders[modSize] != GRB || orders[2*modSize-1] != GRB {
+		t.Errorf("unexpected orders after JSON round trip: %v, %v, %v",
+			orders[0], orders[modSize], orders[2*modSize-1])
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	modConf, err := DefaultModuleConfig(image.Point{Width, Height})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal, err := LoadCalibration("data/calib/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cal.Verify(modConf); err != nil {
+		t.Fatal(err)
+	}
+	matrices := cal.Matrices(modConf)
+	modSize := ModuleDim.X * ModuleDim.Y
+	if matrices[0] != nil || matrices[modSize] == nil || matrices[17] == nil {
+		t.Fatalf("unexpected matrices")
+	}
+	if m := matrices[modSize]; m[1][1] != 0.92 || m[0][1] != 0.0 {
+		t.Errorf("module matrix: %v", *m)
+	}
+	if m := matrices[17]; m[0][0] != 0.9 || math.Abs(m[0][1]-0.045) > 1e-9 {
+		t.Errorf("LED matrix: %v", *m)
+	}
+
+	// Gespeicherte Kalibrierungen koennen ohne Neukompilieren geladen werden.
+	fileName := filepath.Join(t.TempDir(), "calib.json")
+	if err = cal.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadCalibration(CalibFileName(fileName)); err != nil ||
+		len(loaded.Modules) != len(cal.Modules) || len(loaded.Leds) != len(cal.Leds) {
+		t.Errorf("LoadCalibration(%s): got %+v, %v", fileName, loaded, err)
+	}
+	if CalibFileName("example") != "data/calib/example.json" {
+		t.Errorf("CalibFileName: got %s", CalibFileName("example"))
+	}
+
+	// Zu grosse Werte wuerden die Festkomma-Arithmetik der Displayer zum
+	// Ueberlaufen bringen.
+	bad := Calibration{Leds: []LedCalibration{{Idx: 3,
+		ColorCorrection: ColorCorrection{Gain: [3]float64{100, 1, 1}}}}}
+	if err = bad.Verify(modConf); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("expected ErrBadConfig for gain 100, got %v", err)
+	}
+	bad = Calibration{}
+	bad.SetModule(ModulePosition{}, ColorCorrection{Matrix: &[3][3]float64{
+		{1, 50, 0}, {0, 1, 0}, {0, 0, 1}}})
+	if err = bad.Verify(modConf); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("expected ErrBadConfig for matrix entry 50, got %v", err)
+	}
+
+	cal.SetModule(ModulePosition{Col: 5, Row: 5}, ColorCorrection{})
+	if err = cal.Verify(modConf); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("expected ErrBadConfig for missing module, got %v", err)
+	}
+}
+
+func TestLedStatus(t *testing.T) {
+	var db LedStatusDB
+
+	modSize := ModuleDim.X * ModuleDim.Y
+	modConf, _ := DefaultModuleConfig(image.Point{Width, Height})
+	mod := modConf.Module(modSize + 17)
+	if mod == nil || mod.Idx != modSize {
+		t.Fatalf("Module: got %+v", mod)
+	}
+	db.Set(*mod, 17, LedDefect)
+	db.Set(*mod, 3, LedMissing)
+	db.Set(*mod, 3, LedOK)
+	if len(db.Leds) != 1 || db.Get(*mod, 17) != LedDefect {
+		t.Fatalf("unexpected entries: %+v", db.Leds)
+	}
+
+	// Nach dem Vertauschen der ersten beiden Module muss der Status mit dem
+	// Modul wandern.
+	swapped := slices.Clone(modConf)
+	swapped[0].Col, swapped[0].Row, swapped[1].Col, swapped[1].Row =
+		swapped[1].Col, swapped[1].Row, swapped[0].Col, swapped[0].Row
+	if list := db.StatusList(swapped); list[17] != LedDefect || list[modSize+17] != LedOK {
+		t.Errorf("status didn't move with the module")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "status.json")
+	if empty, err := LoadLedStatus(fileName); err != nil || len(empty.Leds) != 0 {
+		t.Errorf("LoadLedStatus of missing file: %+v, %v", empty, err)
+	}
+	if err := db.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadLedStatus(fileName)
+	if err != nil || !slices.Equal(loaded.Leds, db.Leds) {
+		t.Errorf("LoadLedStatus: got %+v, %v", loaded, err)
+	}
+	loaded.Leds = append(loaded.Leds, LedStatusEntry{Col: mod.Col,
+		Row: mod.Row, Idx: 17, Status: LedMissing})
+	if err := loaded.Verify(); err == nil {
+		t.Error("expected error for duplicate entry")
+	}
+}
+
+func TestLayout(t *testing.T) {
+	ring, err := LoadLayout("data/layout/ring24.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.NumLeds() != 24 || ring.Size() != (image.Point{15, 15}) {
+		t.Errorf("ring: %d LEDs, size %v", ring.NumLeds(), ring.Size())
+	}
+	spiral, err := LoadLayout("data/layout/spiral60.csv")
+	if err != nil || spiral.NumLeds() != 60 {
+		t.Fatalf("spiral: %d LEDs, %v", spiral.NumLeds(), err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "spiral.csv")
+	if err = spiral.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if loaded, err := ReadLayoutCSV(fh); err != nil || !slices.Equal(loaded, spiral) {
+		t.Errorf("ReadLayoutCSV: got %v, %v", loaded, err)
+	}
+	if loaded, err := LoadLayout(fileName); err != nil || !slices.Equal(loaded, spiral) {
+		t.Errorf("LoadLayout(%s): got %v, %v", fileName, loaded, err)
+	}
+
+	if _, err = ReadLayoutCSV(strings.NewReader("1,2\n3,abc\n")); err == nil {
+		t.Error("expected error for invalid number")
+	}
+	if err = (Layout{{1, 2}, {-1, 0}}).Verify(); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("expected ErrBadConfig for negative position, got %v", err)
+	}
+
+	modConf, _ := DefaultModuleConfig(image.Point{Width, Height})
+	layout := modConf.Layout()
+	if layout.Size() != modConf.Size() {
+		t.Errorf("layout of module configuration: size %v", layout.Size())
+	}
+	for idx, pt := range modConf.CoordMap() {
+		if layout[idx] != (LedPos{float64(pt.X), float64(pt.Y)}) {
+			t.Fatalf("layout of module configuration: %d -> %v", idx, layout[idx])
+		}
+	}
+}
+
+func TestSolveModuleConfig(t *testing.T) {
+	for _, name := range []string{"squareWithHole", "tetris", "complex"} {
+		modConf, err := Load("data/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		solved, err := SolveModuleConfig(modConf.Cells())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err = solved.Verify(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		cells := solved.Cells()
+		for _, cell := range modConf.Cells() {
+			if !slices.Contains(cells, cell) {
+				t.Errorf("%s: cell %v not covered", name, cell)
+			}
+		}
+		if solved.CableJumps() > modConf.CableJumps() {
+			t.Errorf("%s: %d cable jumps, hand-made configuration has %d",
+				name, solved.CableJumps(), modConf.CableJumps())
+		}
+	}
+
+	// Zwei Module, die sich nur an einer Ecke beruehren, brauchen einen
+	// Kabelsprung; getrennte Module koennen nicht verbunden werden.
+	cells, _ := ParseShape("#./.#")
+	if solved, err := SolveModuleConfig(cells); err != nil || solved.CableJumps() != 1 {
+		t.Errorf("diagonal cells: %v, %v", solved, err)
+	}
+	cells, _ = ParseShape("#.#")
+	if _, err := SolveModuleConfig(cells); !errors.Is(err, ErrNoChain) {
+		t.Errorf("expected ErrNoChain, got %v", err)
+	}
+}
+
+// Suche des LED-Index auf einem Panel mit 80x60 Pixeln: linear ueber die
+// Module...
+func BenchmarkIndex(b *testing.B) {
+	modConf, _ := DefaultModuleConfig(image.Point{80, 60})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := range 60 {
+			for x := range 80 {
+				modConf.Index(image.Point{x, y})
+			}
+		}
+	}
+}
+
+// ... und via PixelMap.
+func BenchmarkPixelMapLookup(b *testing.B) {
+	modConf, _ := DefaultModuleConfig(image.Point{80, 60})
+	pixMap := modConf.PixelMap()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := range 60 {
+			for x := range 80 {
+				pixMap.Lookup(x, y)
+			}
+		}
+	}
+}
+
+func BenchmarkPixelMap(b *testing.B) {
+	modConf, _ := DefaultModuleConfig(image.Point{80, 60})
+	for i := 0; i < b.N; i++ {
+		modConf.PixelMap()
+	}
 }
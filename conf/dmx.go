@@ -0,0 +1,108 @@
+package conf
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Lighting desks address their fixtures with DMX universes of 512 channels
+// each. With a DMXConfig, parts of a universe are mapped onto a range of
+// LEDs on the chain. Each LED uses three consecutive channels (R, G, B),
+// therefore at most 170 LEDs fit into a single universe.
+const (
+	DMXUniverseSize = 512
+	DMXMaxLeds      = DMXUniverseSize / 3
+)
+
+// A single mapping of DMX channels to LEDs. StartChannel is the first
+// channel in the universe (1-based, as on every lighting desk), FirstLed
+// the index of the first LED on the chain and NumLeds the number of LEDs
+// which are controlled by this mapping.
+type DMXMapping struct {
+	Universe     int `json:"Universe"`
+	StartChannel int `json:"StartChannel"`
+	FirstLed     int `json:"FirstLed"`
+	NumLeds      int `json:"NumLeds"`
+}
+
+// The complete mapping of universes onto the LED chain.
+type DMXConfig []DMXMapping
+
+// Distributes numLeds LEDs onto as many universes as needed, starting with
+// universe 1 and using at most DMXMaxLeds LEDs per universe.
+func DefaultDMXConfig(numLeds int) DMXConfig {
+	var dmxConf DMXConfig
+
+	for idx, univ := 0, 1; idx < numLeds; idx, univ = idx+DMXMaxLeds, univ+1 {
+		dmxConf = append(dmxConf, DMXMapping{
+			Universe:     univ,
+			StartChannel: 1,
+			FirstLed:     idx,
+			NumLeds:      min(DMXMaxLeds, numLeds-idx),
+		})
+	}
+	return dmxConf
+}
+
+//go:embed data/dmx/*.json
+var dmxFiles embed.FS
+
+// Reads a DMX mapping from the embedded files (see data/dmx).
+func LoadDMXConfig(fileName string) (DMXConfig, error) {
+	var dmxConf DMXConfig
+
+	data, err := dmxFiles.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &dmxConf); err != nil {
+		return nil, err
+	}
+	return dmxConf, nil
+}
+
+// Checks the mapping against a module configuration: every mapping must fit
+// into its universe and address only LEDs on the chain described by
+// modConf, and no LED may be addressed by more than one mapping. As the
+// same mapping is used for E1.31 and Art-Net, universes must be in the range
+// of both protocols (1..32767, E1.31 has no universe 0).
+func (dmxConf DMXConfig) Verify(modConf ModuleConfig) error {
+	numLeds := modConf.NumLeds()
+	used := make([]bool, numLeds)
+	for i, m := range dmxConf {
+		if m.Universe < 1 || m.Universe > 0x7fff {
+			return fmt.Errorf("mapping %d: invalid universe %d", i, m.Universe)
+		}
+		if m.StartChannel < 1 || m.NumLeds < 0 ||
+			m.StartChannel-1+3*m.NumLeds > DMXUniverseSize {
+			return fmt.Errorf("mapping %d: channels %d..%d don't fit into a universe",
+				i, m.StartChannel, m.StartChannel-1+3*m.NumLeds)
+		}
+		if m.FirstLed < 0 || m.FirstLed+m.NumLeds > numLeds {
+			return fmt.Errorf("mapping %d: LEDs %d..%d are not on the chain (%d LEDs)",
+				i, m.FirstLed, m.FirstLed+m.NumLeds-1, numLeds)
+		}
+		for idx := m.FirstLed; idx < m.FirstLed+m.NumLeds; idx++ {
+			if used[idx] {
+				return fmt.Errorf("mapping %d: LED %d is used by another mapping",
+					i, idx)
+			}
+			used[idx] = true
+		}
+	}
+	return nil
+}
+
+// Returns the list of (distinct) universes used by this mapping.
+func (dmxConf DMXConfig) Universes() []int {
+	var univList []int
+
+	for _, m := range dmxConf {
+		if !slices.Contains(univList, m.Universe) {
+			univList = append(univList, m.Universe)
+		}
+	}
+	return univList
+}
@@ -0,0 +1,193 @@
+package conf
+
+import (
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Not every installation is a grid of modules: NeoPixels may be arranged in
+// rings, spirals or as strips along a wall. A Layout describes such an
+// installation by the position of every LED, in the order of the chain. The
+// positions are given in pixels of the canvas (the LED at (3,2) shows the
+// color of the pixel at column 3, row 2), but don't need to be integers.
+// Positions between pixels are sampled by the LedGrid.
+type Layout []LedPos
+
+// Position of a single LED, see Layout.
+type LedPos struct {
+	X float64 `json:"X"`
+	Y float64 `json:"Y"`
+}
+
+// Returns the number of LEDs on the chain.
+func (l Layout) NumLeds() int {
+	return len(l)
+}
+
+// Returns the size of the canvas which covers all LEDs of the layout.
+func (l Layout) Size() image.Point {
+	size := image.Point{}
+	for _, pos := range l {
+		size.X = max(size.X, int(pos.X)+1)
+		size.Y = max(size.Y, int(pos.Y)+1)
+	}
+	return size
+}
+
+// Checks that the layout has LEDs and that all positions are finite and
+// not negative.
+func (l Layout) Verify() error {
+	if len(l) == 0 {
+		return fmt.Errorf("%w: layout has no LEDs", ErrBadConfig)
+	}
+	for i, pos := range l {
+		if !(pos.X >= 0 && pos.Y >= 0) || math.IsInf(pos.X, 0) || math.IsInf(pos.Y, 0) {
+			return fmt.Errorf("%w: LED %d has invalid position (%g,%g)",
+				ErrBadConfig, i, pos.X, pos.Y)
+		}
+	}
+	return nil
+}
+
+// Returns the layout of a module configuration: every LED is placed at the
+// pixel given by the CoordMap.
+func (conf ModuleConfig) Layout() Layout {
+	coordMap := conf.CoordMap()
+	layout := make(Layout, len(coordMap))
+	for i, pt := range coordMap {
+		layout[i] = LedPos{float64(pt.X), float64(pt.Y)}
+	}
+	return layout
+}
+
+// Creates the layout of a ring with numLeds LEDs with the given center and
+// radius (in pixels). The first LED is placed at the top, the chain runs
+// clockwise.
+func RingLayout(numLeds int, centerX, centerY, radius float64) Layout {
+	layout := make(Layout, numLeds)
+	for i := range layout {
+		phi := 2.0 * math.Pi * float64(i) / float64(numLeds)
+		layout[i] = LedPos{centerX + radius*math.Sin(phi),
+			centerY - radius*math.Cos(phi)}
+	}
+	return layout
+}
+
+// Creates the layout of a straight strip with numLeds LEDs from (x0,y0) to
+// (x1,y1).
+func StripLayout(numLeds int, x0, y0, x1, y1 float64) Layout {
+	layout := make(Layout, numLeds)
+	for i := range layout {
+		t := 0.0
+		if numLeds > 1 {
+			t = float64(i) / float64(numLeds-1)
+		}
+		layout[i] = LedPos{x0 + t*(x1-x0), y0 + t*(y1-y0)}
+	}
+	return layout
+}
+
+// Reads a layout in CSV format: one LED per line with the coordinates X and
+// Y, separated by a comma. Empty lines, lines starting with '#' and a header
+// line ("x,y") are ignored.
+func ReadLayoutCSV(r io.Reader) (Layout, error) {
+	var layout Layout
+
+	rd := csv.NewReader(r)
+	rd.Comment = '#'
+	rd.FieldsPerRecord = 2
+	rd.TrimLeadingSpace = true
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(layout) == 0 && strings.EqualFold(rec[0], "x") {
+			continue
+		}
+		x, errX := strconv.ParseFloat(rec[0], 64)
+		y, errY := strconv.ParseFloat(rec[1], 64)
+		if err = errors.Join(errX, errY); err != nil {
+			line, _ := rd.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		layout = append(layout, LedPos{x, y})
+	}
+	return layout, nil
+}
+
+// Writes the layout in CSV format (see ReadLayoutCSV).
+func (l Layout) WriteCSV(w io.Writer) error {
+	wr := csv.NewWriter(w)
+	wr.Write([]string{"x", "y"})
+	for _, pos := range l {
+		wr.Write([]string{strconv.FormatFloat(pos.X, 'g', -1, 64),
+			strconv.FormatFloat(pos.Y, 'g', -1, 64)})
+	}
+	wr.Flush()
+	return wr.Error()
+}
+
+//go:embed data/layout/*
+var layoutFiles embed.FS
+
+// Reads a layout. As with Load, fileName is first looked up in the file
+// system, then in the embedded files (see data/layout). Files with the
+// extension ".csv" are read in CSV format (see ReadLayoutCSV), all others
+// as JSON array of positions. The layout is verified.
+func LoadLayout(fileName string) (Layout, error) {
+	var layout Layout
+
+	data, err := readFile(layoutFiles, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if path.Ext(fileName) == ".csv" {
+		layout, err = ReadLayoutCSV(bytes.NewReader(data))
+	} else {
+		err = json.Unmarshal(data, &layout)
+	}
+	if err == nil {
+		err = layout.Verify()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadConfig, fileName, err)
+	}
+	return layout, nil
+}
+
+// Writes the layout to the file fileName, in CSV format if the extension
+// of fileName is ".csv", as JSON otherwise. It can be loaded from there
+// directly (see LoadLayout); to be embedded, the file must be copied to
+// data/layout.
+func (l Layout) Save(fileName string) error {
+	var data []byte
+	var err error
+
+	if filepath.Ext(fileName) == ".csv" {
+		var buf bytes.Buffer
+		err = l.WriteCSV(&buf)
+		data = buf.Bytes()
+	} else {
+		data, err = json.MarshalIndent(l, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
@@ -0,0 +1,103 @@
+package conf
+
+import (
+	"fmt"
+)
+
+// Depending on the chip and the batch, NeoPixels expect the three color
+// values in different orders on the wire. A ColorOrder describes this
+// order; it can be set for a whole displayer and for single modules (see
+// ModulePosition.Order), since modules of different batches may share one
+// chain.
+type ColorOrder uint8
+
+const (
+	// The module uses the order of the displayer.
+	DefOrder ColorOrder = iota
+	RGB
+	RBG
+	GRB
+	GBR
+	BRG
+	BGR
+	NumColorOrders
+)
+
+var colorOrderNames = [NumColorOrders]string{"", "RGB", "RBG", "GRB", "GBR", "BRG", "BGR"}
+
+func (o ColorOrder) String() string {
+	if o == DefOrder {
+		return "default"
+	}
+	if o < NumColorOrders {
+		return colorOrderNames[o]
+	}
+	return fmt.Sprintf("ColorOrder(%d)", uint8(o))
+}
+
+// Channels returns for each position on the wire the index of the color
+// (0: red, 1: green, 2: blue) which has to be sent there. DefOrder is
+// treated like RGB.
+func (o ColorOrder) Channels() [3]int {
+	var ch [3]int
+
+	if o == DefOrder || o >= NumColorOrders {
+		return [3]int{0, 1, 2}
+	}
+	for i, c := range colorOrderNames[o] {
+		switch c {
+		case 'R':
+			ch[i] = 0
+		case 'G':
+			ch[i] = 1
+		case 'B':
+			ch[i] = 2
+		}
+	}
+	return ch
+}
+
+// Set, MarshalText and UnmarshalText allow to use the order by name in
+// command line flags and JSON files.
+func (o *ColorOrder) Set(v string) error {
+	for order := RGB; order < NumColorOrders; order++ {
+		if v == colorOrderNames[order] {
+			*o = order
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown color order '%s'", v)
+}
+
+func (o ColorOrder) MarshalText() ([]byte, error) {
+	if o == DefOrder {
+		return []byte{}, nil
+	}
+	return []byte(o.String()), nil
+}
+
+func (o *ColorOrder) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*o = DefOrder
+		return nil
+	}
+	return o.Set(string(text))
+}
+
+// Returns the color order of each LED on the chain. Modules without an
+// order of their own get order defOrder.
+func (conf ModuleConfig) ColorOrders(defOrder ColorOrder) []ColorOrder {
+	orders := make([]ColorOrder, conf.NumLeds())
+	for _, m := range conf {
+		order := m.Order
+		if order == DefOrder {
+			order = defOrder
+		}
+		for i := range m.NumLeds() {
+			if m.Idx+i < len(orders) {
+				orders[m.Idx+i] = order
+			}
+		}
+	}
+	return orders
+}
@@ -4,7 +4,6 @@ package conf
 
 import (
 	"fmt"
-	"log"
 	"math"
 
 	"golang.org/x/image/font"
@@ -50,22 +49,22 @@ var (
 	LedFillColor      = colors.White.Alpha(0.7)
 	LedStartFillColor = colors.DarkGreen.Alpha(0.8)
 	LedEndFillColor   = colors.FireBrick.Alpha(0.8)
+	LedDefectColor    = colors.DarkOrange
+	LedMissingColor   = colors.DimGray
 	LedTextFont       = fonts.GoRegular
 	LedTextSize       = 16.0 * scaleFactor
 	LedTextColor      = colors.Black
 	LedTextColorInv   = colors.White
 
 	// Trace is...
-	TraceWidth     = 15.0 * scaleFactor
-	TraceColor     = colors.DarkSlateGray
-	TraceBezierPos = 0.8
+	TraceWidth = 15.0 * scaleFactor
+	TraceColor = colors.DarkSlateGray
 
 	sizeFact = 0.8
 
 	axesFontFace, moduleFontFace font.Face
+	moduleFontFaces              = make(map[float64]font.Face)
 	ledFontFaces                 [7]font.Face
-
-	pTL, pTR geom.Point
 )
 
 func init() {
@@ -78,17 +77,22 @@ func init() {
 		}
 		ledFontFaces[i], _ = fonts.NewFace(LedTextFont, size)
 	}
-	pTL = geom.Point{-ModuleSize / 2.0, -ModuleSize / 2.0}
-	pTR = pTL.AddXY(ModuleSize, 0.0)
 }
 
 // With Plot, you can create a PNG file, showing the exact module config,
 // the cabeling and the mapping between pixel coordinates and index on the
 // LED chain.
-func (conf ModuleConfig) Plot(fileName string) {
+func (conf ModuleConfig) Plot(fileName string) error {
+	return conf.PlotStatus(fileName, LedStatusDB{})
+}
+
+// PlotStatus works like Plot, but additionally marks the defect and missing
+// LEDs of the status database db: defect LEDs get a colored ring
+// (LedDefectColor), missing LEDs are crossed out (LedMissingColor).
+func (conf ModuleConfig) PlotStatus(fileName string, db LedStatusDB) error {
 	err := conf.Verify()
 	if err != nil {
-		log.Fatalf("Cannot plot configuration: %v", err)
+		return err
 	}
 
 	size := conf.Size()
@@ -97,29 +101,39 @@ func (conf ModuleConfig) Plot(fileName string) {
 	gc.SetFillColor(colors.White)
 	gc.Clear()
 
-	conf.Draw(gc)
+	conf.DrawStatus(gc, db.StatusList(conf))
 
-	err = gc.SavePNG(fileName)
-	if err != nil {
-		log.Fatalf("Couldn't save configuration: %v", err)
-	}
+	return gc.SavePNG(fileName)
 }
 
 // Draw is the workhorse of Plot. With Draw, you can create the configuration
 // image into the graphical context, provided by the parameter gc.
 func (conf ModuleConfig) Draw(gc *gg.Context) {
+	conf.DrawStatus(gc, nil)
+}
+
+// DrawStatus works like Draw and marks the LEDs according to the list
+// status, which contains the status of every LED on the chain (see
+// LedStatusDB.StatusList). The list may be nil.
+func (conf ModuleConfig) DrawStatus(gc *gg.Context, status []LedStatus) {
 
 	conf.DrawAxes(gc)
 
 	// And then draw the individual modules
-	p0 := geom.Point{MarginLeft, MarginTop}.AddXY(ModuleSize/2.0, ModuleSize/2.0)
-	for i, modPos := range conf {
-		pt := p0.Add(geom.Point{float64(modPos.Col), float64(modPos.Row)}.Mul(ModuleSize))
+	p0 := geom.Point{MarginLeft, MarginTop}
+	for _, modPos := range conf {
+		bounds := modPos.Bounds()
+		center := geom.Point{float64(bounds.Min.X+bounds.Max.X) / 2.0,
+			float64(bounds.Min.Y+bounds.Max.Y) / 2.0}
+		pt := p0.Add(center.Mul(LedFieldSize))
 
 		gc.Push()
 		gc.Translate(pt.X, pt.Y)
-		gc.Rotate(-gg.Radians(float64(modPos.Mod.Rot)))
-		modPos.Mod.Draw(gc, i)
+		var modStatus []LedStatus
+		if status != nil {
+			modStatus = status[modPos.Idx : modPos.Idx+modPos.NumLeds()]
+		}
+		modPos.Mod.draw(gc, modPos.Idx, modStatus)
 		gc.Pop()
 	}
 }
@@ -162,84 +176,74 @@ func (conf ModuleConfig) DrawAxes(gc *gg.Context) {
 }
 
 // This method draws a single module. The calling method/function must ensure,
-// using translation and possibly rotation, that the origin of the coordinate
-// system is positioned at the center of the module.
+// using translation, that the origin of the coordinate system is positioned
+// at the center of the module; the rotation of the module is taken into
+// account by the method itself. The LEDs are labeled as if all preceding
+// modules on the chain were of the same type.
 func (mod Module) Draw(gc *gg.Context, idxMod int) {
-	// p0 und p1 are reference points which are placed on the top left and top
-	// right of the module.
-	// p0 := geom.Point{-ModuleSize / 2.0, -ModuleSize / 2.0}
-	// p1 := p0.Add(geom.Point{ModuleSize, 0})
+	mod.draw(gc, idxMod*mod.NumLeds(), nil)
+}
+
+// Returns the corners of the module with the origin at its center.
+func (mod Module) rect() (topLeft, size geom.Point) {
+	s := mod.Size()
+	size = geom.Point{float64(s.X), float64(s.Y)}.Mul(LedFieldSize)
+	return size.Div(-2.0), size
+}
+
+// Returns the midpoint of the LED with index idx (within the module).
+func (mod Module) ledPoint(idx int) geom.Point {
+	topLeft, _ := mod.rect()
+	pt := mod.Coord(idx)
+	return topLeft.Add(geom.Point{float64(pt.X) + 0.5, float64(pt.Y) + 0.5}.Mul(LedFieldSize))
+}
+
+// Returns the font face for the type name of the module. The size of the
+// text is adapted to the size of the module (ModuleTextSize is the size
+// for the classic 10x10 modules), but the name must fit into the module.
+func (mod Module) textFace(gc *gg.Context, name string) font.Face {
+	size := mod.Type.Size
+	textSize := ModuleTextSize * float64(min(size.X, size.Y)) /
+		float64(ModuleDim.X)
+	gc.SetFontFace(moduleFontFace)
+	w, _ := gc.MeasureString(name)
+	textSize = min(textSize, 0.9*ModuleTextSize*float64(size.X)*LedFieldSize/w)
+	if textSize == ModuleTextSize {
+		return moduleFontFace
+	}
+	face, ok := moduleFontFaces[textSize]
+	if !ok {
+		face, _ = fonts.NewFace(ModuleTextFont, textSize)
+		moduleFontFaces[textSize] = face
+	}
+	return face
+}
+
+func (mod Module) draw(gc *gg.Context, idxFirst int, status []LedStatus) {
+	numLeds := mod.NumLeds()
 
 	// Draws the filling of the module - the border will be drawn later.
-	gc.DrawRectangle(pTL.X, pTL.Y, ModuleSize, ModuleSize)
+	topLeft, size := mod.rect()
+	gc.DrawRectangle(topLeft.X, topLeft.Y, size.X, size.Y)
 	gc.SetFillColor(ModuleFillColor)
 	gc.Fill()
 
-	// Draw the module type name in huge letters in the middle of the module.
-	//gc.Push()
-	//gc.Rotate(gg.Radians(float64(mod.Rot)))
-	gc.SetFontFace(moduleFontFace)
+	// Draw the module type name in huge letters in the middle of the module,
+	// oriented like the module.
+	name := fmt.Sprintf("%v", mod.Type)
+	gc.Push()
+	gc.Rotate(-gg.Radians(float64(mod.Rot)))
+	gc.SetFontFace(mod.textFace(gc, name))
 	gc.SetTextColor(ModuleTextColor)
-	gc.DrawStringAnchored(fmt.Sprintf("%v", mod.Type), 0.0, 0.0, 0.5, 0.5)
-	//gc.Pop()
+	gc.DrawStringAnchored(name, 0.0, 0.0, 0.5, 0.5)
+	gc.Pop()
 
 	mod.DrawTrace(gc)
 
 	// Draw the individual LEDs or table tennis balls, respectively.
-	// Set first some orientation dependent variables:
-	// mp0: the midpoint of the first LED to be drawn.
-	// dx:  step in x-direction between two LEDs
-	// dy:  step in y-direction between two LEDs
-	// turn: angle (in radians) to turn at the end of a column
-	mp0 := pTL.AddXY(LedFieldSize/2.0, LedFieldSize/2.0)
-	dx := geom.Point{LedFieldSize, 0.0}
-	dy := geom.Point{0.0, LedFieldSize}
-	turn := gg.Radians(-90.0)
-	dir := math.Pi
-	if mod.Type == ModRL {
-		mp0 = pTR.AddXY(-LedFieldSize/2.0, LedFieldSize/2.0)
-		dx = geom.Point{-LedFieldSize, 0.0}
-		dy = geom.Point{0.0, LedFieldSize}
-		turn = gg.Radians(90.0)
-	}
-
-	// mp is the midpoint of the next LED to be drawn.
-	mp := mp0
-	// mode denotes the part of the led chain we are currently drawing.
-	// Values of mode are
-	// 0: on an even column, next led will be drawn under the current led
-	// 1: at the buttom of an even column
-	// 2: on a odd column, next led will be drawn on top of the current led
-	// 3: at the top of a odd column
-	mode := 0
-	// dp is the step to take in order to get to the next LED position.
-	dp := dy
-
-	// In this loop, we iterate over all of the 100 LEDs of the module.
-	for idx := range ModuleDim.X * ModuleDim.Y {
-		idxLed := 100*idxMod + idx
-		switch mode {
-		case 0:
-			if idx%ModuleDim.Y == 9 {
-				dir += turn
-				dp = dx
-				mode++
-			}
-		case 1:
-			dir += turn
-			dp = dy.Neg()
-			mode++
-		case 2:
-			if idx%ModuleDim.Y == 9 {
-				dir -= turn
-				dp = dx
-				mode++
-			}
-		case 3:
-			dir -= turn
-			dp = dy
-			mode = 0
-		}
+	for idx := range numLeds {
+		idxLed := idxFirst + idx
+		mp := mod.ledPoint(idx)
 
 		// Draw the LED (or table tennis ball) as a filled circle.
 		gc.DrawCircle(mp.X, mp.Y, LedSize/2.0)
@@ -247,85 +251,67 @@ func (mod Module) Draw(gc *gg.Context, idxMod int) {
 		gc.SetLineColor(LedBorderColor)
 		if idx == 0 {
 			gc.SetFillColor(LedStartFillColor)
-		} else if idx == ModuleDim.X*ModuleDim.Y-1 {
+		} else if idx == numLeds-1 {
 			gc.SetFillColor(LedEndFillColor)
 		} else {
 			gc.SetFillColor(LedFillColor)
 		}
 		gc.FillStroke()
 
+		// Mark defect and missing LEDs.
+		if status != nil {
+			mod.drawStatus(gc, mp, status[idx])
+		}
+
 		// Label the LED with the index number of this LED within the whole
 		// LED chain.
-		gc.Push()
-		gc.Translate(mp.X, mp.Y)
-		gc.Rotate(gg.Radians(float64(mod.Rot)))
-		i := int(math.Log10(float64(max(idxLed, 1))))
+		i := min(int(math.Log10(float64(max(idxLed, 1)))), len(ledFontFaces)-1)
 		gc.SetFontFace(ledFontFaces[i])
-		if idx > 0 && idx < ModuleDim.X*ModuleDim.Y-1 {
+		if idx > 0 && idx < numLeds-1 {
 			gc.SetTextColor(LedTextColor)
 		} else {
 			gc.SetTextColor(LedTextColorInv)
 		}
-		gc.DrawStringAnchored(fmt.Sprintf("%d", idxLed), 0.0, 0.0, 0.5, 0.5)
-		gc.Pop()
-
-		mp = mp.Add(dp)
+		gc.DrawStringAnchored(fmt.Sprintf("%d", idxLed), mp.X, mp.Y, 0.5, 0.5)
 	}
 
 	mod.DrawBorder(gc)
 }
 
-func (mod Module) DrawTrace(gc *gg.Context) {
-
-	// Verlauf der Verkabelung als graues, maeandrierendes Band darstellen.
-	var mpA, mpB, mpANew, mpBNew, mpC1, mpC2 geom.Point
-
-	// p0 := geom.Point{-ModuleSize / 2.0, -ModuleSize / 2.0}
-	// p1 := p0.Add(geom.Point{ModuleSize, 0})
-	mp0 := pTL.AddXY(LedFieldSize/2.0, LedFieldSize/2.0)
-	dx := geom.Point{LedFieldSize, 0.0}
-	dy := geom.Point{0.0, LedFieldSize}
-	if mod.Type == ModRL {
-		mp0 = pTR.AddXY(-LedFieldSize/2.0, LedFieldSize/2.0)
-		dx = geom.Point{-LedFieldSize, 0.0}
-		dy = geom.Point{0.0, LedFieldSize}
+// Marks the LED at mp according to its status stat.
+func (mod Module) drawStatus(gc *gg.Context, mp geom.Point, stat LedStatus) {
+	switch stat {
+	case LedDefect:
+		gc.DrawCircle(mp.X, mp.Y, LedSize/2.0-2.0*LedBorderWidth)
+		gc.SetLineWidth(4.0 * LedBorderWidth)
+		gc.SetLineColor(LedDefectColor)
+		gc.Stroke()
+	case LedMissing:
+		d := LedSize / (2.0 * math.Sqrt2)
+		gc.DrawLine(mp.X-d, mp.Y-d, mp.X+d, mp.Y+d)
+		gc.DrawLine(mp.X-d, mp.Y+d, mp.X+d, mp.Y-d)
+		gc.SetLineWidth(4.0 * LedBorderWidth)
+		gc.SetLineColor(LedMissingColor)
+		gc.Stroke()
 	}
+}
 
-	mpA = mp0.Add(dy.Div(2))
-	mpB = mpA.Add(dy.Mul(8))
+// Verlauf der Verkabelung als graues Band durch die Mittelpunkte der LEDs
+// darstellen.
+func (mod Module) DrawTrace(gc *gg.Context) {
 	gc.SetLineWidth(TraceWidth)
 	gc.SetLineColor(TraceColor)
-	gc.MoveTo(mp0.AsCoord())
-	gc.LineTo(mpA.AsCoord())
-	for i := range 10 {
-		if i%2 == 0 {
-			gc.LineTo(mpB.AsCoord())
-		} else {
-			gc.LineTo(mpA.AsCoord())
-		}
-		mpANew = mpA.Add(dx)
-		mpBNew = mpB.Add(dx)
-		if i < 9 {
-			if i%2 == 0 {
-				mpC1 = mpB.Add(dy.Mul(TraceBezierPos))
-				mpC2 = mpBNew.Add(dy.Mul(TraceBezierPos))
-				gc.CubicTo(mpC1.X, mpC1.Y, mpC2.X, mpC2.Y, mpBNew.X, mpBNew.Y)
-			} else {
-				mpC1 = mpA.Sub(dy.Mul(TraceBezierPos))
-				mpC2 = mpANew.Sub(dy.Mul(TraceBezierPos))
-				gc.CubicTo(mpC1.X, mpC1.Y, mpC2.X, mpC2.Y, mpANew.X, mpANew.Y)
-			}
-		}
-		mpA = mpANew
-		mpB = mpBNew
+	gc.MoveTo(mod.ledPoint(0).AsCoord())
+	for idx := 1; idx < mod.NumLeds(); idx++ {
+		gc.LineTo(mod.ledPoint(idx).AsCoord())
 	}
-	gc.LineTo(mp0.Add(dx.Mul(9)).AsCoord())
 	gc.Stroke()
 }
 
 func (mod Module) DrawBorder(gc *gg.Context) {
 	// Draw the border of the module.
-	gc.DrawRectangle(pTL.X, pTL.Y, ModuleSize, ModuleSize)
+	topLeft, size := mod.rect()
+	gc.DrawRectangle(topLeft.X, topLeft.Y, size.X, size.Y)
 	gc.SetLineWidth(ModuleBorderWidth)
 	gc.SetLineColor(ModuleBorderColor)
 	gc.Stroke()
@@ -0,0 +1,268 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+)
+
+// The solver creates module configurations for arbitrary shapes: given the
+// cells (Col, Row) which are covered by a classic 10x10 module, it searches
+// an order of the modules together with their type (LR or RL) and rotation,
+// such that every module starts next to the end of the preceding one (see
+// VerifyModule). Among all such chains, the solver prefers the ones with the
+// fewest cable jumps (see CableJumps).
+//
+// The search is a depth first search which tries the cells with the fewest
+// free neighbours first. Shapes which can't be covered by a chain are
+// usually detected early; the number of steps is nevertheless limited by
+// SolverMaxSteps.
+
+var (
+	// The shape can't be covered by a chain of modules.
+	ErrNoChain = errors.New("no module chain found")
+
+	// Maximum number of modules placed by the solver during the search.
+	SolverMaxSteps = 2_000_000
+)
+
+// The modules the solver chooses from, the straight ones first.
+var solverModules = []Module{
+	ModLR000, ModLR090, ModLR180, ModLR270,
+	ModRL000, ModRL090, ModRL180, ModRL270,
+}
+
+// Counts the cable jumps of the configuration: connections between two
+// consecutive modules where the last LED of the first module and the first
+// LED of the second one only touch diagonally. Such a connection needs a
+// longer cable, which runs across the corner of a module.
+func (conf ModuleConfig) CableJumps() int {
+	jumps := 0
+	for i := 1; i < len(conf); i++ {
+		prev := conf[i-1]
+		ptA := prev.Coord(prev.Idx + prev.NumLeds() - 1)
+		ptB := conf[i].Coord(conf[i].Idx)
+		if abs(ptA.X-ptB.X) == 1 && abs(ptA.Y-ptB.Y) == 1 {
+			jumps++
+		}
+	}
+	return jumps
+}
+
+// Returns the cells (Col, Row) of the modules of the configuration.
+func (conf ModuleConfig) Cells() []image.Point {
+	cells := make([]image.Point, len(conf))
+	for i, modPos := range conf {
+		cells[i] = image.Point{modPos.Col, modPos.Row}
+	}
+	return cells
+}
+
+// Parses a shape, given as text with one line per row of modules. Every
+// '#' or 'X' marks a cell covered by a module, all other characters (e.g.
+// '.' or blanks) mark empty cells. Lines can also be separated by '/'.
+func ParseShape(text string) ([]image.Point, error) {
+	var cells []image.Point
+
+	text = strings.ReplaceAll(text, "/", "\n")
+	for row, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		for col, ch := range line {
+			if ch == '#' || ch == 'X' {
+				cells = append(cells, image.Point{col, row})
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("%w: empty shape", ErrBadConfig)
+	}
+	return cells, nil
+}
+
+type solver struct {
+	cells     []image.Point
+	neighbors [][]int
+	used      []bool
+	chain     ModuleConfig
+	chainCell []int
+	jumps     int
+	best      ModuleConfig
+	bestJumps int
+	steps     int
+}
+
+// Searches a module configuration covering the cells (Col, Row), see the
+// description of the solver above. The configuration with the fewest cable
+// jumps found is returned; if the search had to be stopped after
+// SolverMaxSteps, there may be a better one. ErrNoChain is returned if
+// there is no chain at all or if none was found within SolverMaxSteps.
+func SolveModuleConfig(cells []image.Point) (ModuleConfig, error) {
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("%w: no cells", ErrBadConfig)
+	}
+	s := &solver{bestJumps: len(cells)}
+	index := make(map[image.Point]int)
+	for _, cell := range cells {
+		if cell.X < 0 || cell.Y < 0 {
+			return nil, fmt.Errorf("%w: invalid cell %v", ErrBadConfig, cell)
+		}
+		if _, ok := index[cell]; ok {
+			continue
+		}
+		index[cell] = len(s.cells)
+		s.cells = append(s.cells, cell)
+	}
+	s.neighbors = make([][]int, len(s.cells))
+	for i, cell := range s.cells {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if j, ok := index[cell.Add(image.Point{dx, dy})]; ok && j != i {
+					s.neighbors[i] = append(s.neighbors[i], j)
+				}
+			}
+		}
+	}
+	s.used = make([]bool, len(s.cells))
+
+	s.search()
+	if s.best == nil {
+		if s.steps > SolverMaxSteps {
+			return nil, fmt.Errorf("%w: search stopped after %d steps",
+				ErrNoChain, SolverMaxSteps)
+		}
+		return nil, ErrNoChain
+	}
+	return s.best, nil
+}
+
+// Returns the number of unused neighbours of cell i.
+func (s *solver) degree(i int) int {
+	n := 0
+	for _, j := range s.neighbors[i] {
+		if !s.used[j] {
+			n++
+		}
+	}
+	return n
+}
+
+// Checks whether the unused cells can still be covered by the rest of the
+// chain, which continues at cell last: they must be reachable from last
+// and at most one of them may be a dead end (a cell with only one unused
+// neighbour, which is not next to last), since a dead end can only be the
+// last module.
+func (s *solver) feasible(last int) bool {
+	var stack []int
+
+	deadEnds, numFree := 0, 0
+	for i, used := range s.used {
+		if used {
+			continue
+		}
+		numFree++
+		if s.degree(i) <= 1 && !slices.Contains(s.neighbors[last], i) {
+			deadEnds++
+		}
+	}
+	if deadEnds > 1 {
+		return false
+	}
+	seen := make([]bool, len(s.cells))
+	seen[last] = true
+	stack = append(stack, last)
+	numFree++
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		numFree--
+		for _, j := range s.neighbors[i] {
+			if !s.used[j] && !seen[j] {
+				seen[j] = true
+				stack = append(stack, j)
+			}
+		}
+	}
+	return numFree == 0
+}
+
+type solverCandidate struct {
+	cell, degree, jump int
+	modPos             ModulePosition
+}
+
+// Places the next module of the chain. Returns false if the search has to
+// be stopped.
+func (s *solver) search() bool {
+	if len(s.chain) == len(s.cells) {
+		if s.jumps < s.bestJumps {
+			s.best = slices.Clone(s.chain)
+			s.bestJumps = s.jumps
+		}
+		return s.bestJumps > 0
+	}
+	if s.jumps >= s.bestJumps {
+		return true
+	}
+	s.steps++
+	if s.steps > SolverMaxSteps {
+		return false
+	}
+
+	last := -1
+	if len(s.chain) > 0 {
+		last = len(s.chain) - 1
+	}
+	var candidates []solverCandidate
+	for i, cell := range s.cells {
+		if s.used[i] {
+			continue
+		}
+		if last >= 0 && !slices.Contains(s.neighbors[s.chainCell[last]], i) {
+			continue
+		}
+		for _, mod := range solverModules {
+			modPos := ModulePosition{Col: cell.X, Row: cell.Y, Mod: mod,
+				Idx: len(s.chain) * mod.NumLeds()}
+			jump := 0
+			if last >= 0 {
+				prev := s.chain[last]
+				ptA := prev.Coord(prev.Idx + prev.NumLeds() - 1)
+				ptB := modPos.Coord(modPos.Idx)
+				dx, dy := abs(ptA.X-ptB.X), abs(ptA.Y-ptB.Y)
+				if dx > 1 || dy > 1 {
+					continue
+				}
+				if dx == 1 && dy == 1 {
+					jump = 1
+				}
+			}
+			candidates = append(candidates, solverCandidate{i, s.degree(i), jump, modPos})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b solverCandidate) int {
+		if a.jump != b.jump {
+			return a.jump - b.jump
+		}
+		return a.degree - b.degree
+	})
+
+	for _, c := range candidates {
+		s.used[c.cell] = true
+		s.chain = append(s.chain, c.modPos)
+		s.chainCell = append(s.chainCell, c.cell)
+		s.jumps += c.jump
+		ok := true
+		if s.feasible(c.cell) {
+			ok = s.search()
+		}
+		s.jumps -= c.jump
+		s.chain = s.chain[:len(s.chain)-1]
+		s.chainCell = s.chainCell[:len(s.chainCell)-1]
+		s.used[c.cell] = false
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package conf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// This type has been introduced in order to mark some NeoPixels on the chain
+// as 'ok' (the default), 'defect' or 'missing' (see constants LedOK,
+// LedDefect or LedMissing for more information).
+type LedStatus byte
+
+const (
+	// LedOK is the default state of a NeoPixel.
+	LedOK LedStatus = iota
+	// NeoPixels with status LedDefect will be blacked out. This means that
+	// the sent color data for this pixel will always be (0,0,0), i.e. black.
+	// This status can be used if a NeoPixel propagates data as expected
+	// but is not able to correctly display its own color.
+	LedDefect
+	// LedMissing can be used, if a NeoPixel does not even propagate data
+	// to NeoPixels further down the chain. Such a pixel has to be cut out
+	// of the chain and the wires need to be shortened. For the time till a
+	// replacement pixel is organized and soldered in, the pixel must have
+	// status LedMissing.
+	LedMissing
+)
+
+func (s LedStatus) String() string {
+	switch s {
+	case LedOK:
+		return "ok"
+	case LedDefect:
+		return "defect"
+	case LedMissing:
+		return "missing"
+	}
+	return fmt.Sprintf("LedStatus(%d)", byte(s))
+}
+
+// MarshalText and UnmarshalText allow to use the status by name in JSON
+// files or in the REST API of the GridServer.
+func (s LedStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *LedStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "ok":
+		*s = LedOK
+	case "defect":
+		*s = LedDefect
+	case "missing":
+		*s = LedMissing
+	default:
+		return fmt.Errorf("unknown LED status '%s'", text)
+	}
+	return nil
+}
+
+// The status of the NeoPixels is a property of the hardware and not of the
+// chain. A LedStatusDB therefore identifies a NeoPixel by the position of
+// its module (Col, Row and the offset X, Y, see ModulePosition) and its
+// index Idx within the module (in the order of the chain), like a
+// Calibration does. When the modules are rearranged in the module
+// configuration, the status moves with them. Entries of modules which are
+// not part of the configuration are kept, but ignored. Only NeoPixels with
+// a status other than LedOK are stored.
+type LedStatusDB struct {
+	Leds []LedStatusEntry `json:"Leds"`
+}
+
+type LedStatusEntry struct {
+	Col    int       `json:"Col"`
+	Row    int       `json:"Row"`
+	X      int       `json:"X,omitempty"`
+	Y      int       `json:"Y,omitempty"`
+	Idx    int       `json:"Idx"`
+	Status LedStatus `json:"Status"`
+}
+
+// Returns the position of the module of the NeoPixel.
+func (e LedStatusEntry) Place() ModulePosition {
+	return ModulePosition{Col: e.Col, Row: e.Row, X: e.X, Y: e.Y}
+}
+
+// Reads the status database from the file fileName. Unlike module
+// configurations, the database is not embedded, since it is updated at
+// runtime. A file that doesn't exist yet results in an empty database.
+func LoadLedStatus(fileName string) (LedStatusDB, error) {
+	var db LedStatusDB
+
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return db, err
+	}
+	if err = json.Unmarshal(data, &db); err != nil {
+		return db, fmt.Errorf("%w: %s: %v", ErrBadConfig, fileName, err)
+	}
+	if err = db.Verify(); err != nil {
+		return db, fmt.Errorf("%w: %s: %v", ErrBadConfig, fileName, err)
+	}
+	return db, nil
+}
+
+// Writes the database to the file fileName.
+func (db LedStatusDB) Save(fileName string) error {
+	if db.Leds == nil {
+		db.Leds = []LedStatusEntry{}
+	}
+	data, err := json.MarshalIndent(db, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
+
+// Checks that all indices are valid and that no NeoPixel has more than one
+// entry. Since the database may contain modules which are not part of the
+// current configuration, indices beyond the end of a module are only
+// ignored by StatusList.
+func (db LedStatusDB) Verify() error {
+	type key struct{ col, row, x, y, idx int }
+
+	seen := make(map[key]bool)
+	for i, e := range db.Leds {
+		if e.Idx < 0 {
+			return fmt.Errorf("entry %d: invalid index %d", i, e.Idx)
+		}
+		k := key{e.Col, e.Row, e.X, e.Y, e.Idx}
+		if seen[k] {
+			return fmt.Errorf("entry %d: duplicate entry for (%d,%d) %d",
+				i, e.Col, e.Row, e.Idx)
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// Returns the status of the NeoPixel with index idx in the module at the
+// position of place.
+func (db LedStatusDB) Get(place ModulePosition, idx int) LedStatus {
+	for _, e := range db.Leds {
+		if e.Place().SamePlace(place) && e.Idx == idx {
+			return e.Status
+		}
+	}
+	return LedOK
+}
+
+// Sets the status of the NeoPixel with index idx in the module at the
+// position of place. Setting LedOK removes the entry.
+func (db *LedStatusDB) Set(place ModulePosition, idx int, stat LedStatus) {
+	for i, e := range db.Leds {
+		if e.Place().SamePlace(place) && e.Idx == idx {
+			if stat == LedOK {
+				db.Leds = append(db.Leds[:i], db.Leds[i+1:]...)
+			} else {
+				db.Leds[i].Status = stat
+			}
+			return
+		}
+	}
+	if stat != LedOK {
+		db.Leds = append(db.Leds, LedStatusEntry{place.Col, place.Row,
+			place.X, place.Y, idx, stat})
+	}
+}
+
+// Computes the status of every NeoPixel on the chain described by modConf.
+func (db LedStatusDB) StatusList(modConf ModuleConfig) []LedStatus {
+	list := make([]LedStatus, modConf.NumLeds())
+	for _, e := range db.Leds {
+		if pos := modConf.find(e.Place()); pos != nil && e.Idx < pos.NumLeds() {
+			list[pos.Idx+e.Idx] = e.Status
+		}
+	}
+	return list
+}
@@ -46,38 +46,48 @@ type Displayer interface {
 	Close()
 }
 
-// This type has been introduced in order to mark some NeoPixels on the chain
-// as 'ok' (the default), 'defect' or 'missing' (see constants LedOK,
-// LedDefect or LedMissing for more information).
-type LedStatusType byte
+// Displayers implementing this interface limit the brightness of the three
+// colors to the maximum values of the GridServer. DisplayEmbed scales the
+// values after the gamma correction; displayers for NeoPixels with a global
+// brightness field in hardware (e.g. APA102) use this field instead.
+type BrightnessLimiter interface {
+	SetMaxValue(r, g, b uint8)
+}
+
+// The status of a NeoPixel on the chain ('ok', 'defect' or 'missing'). The
+// type and its constants are defined in package conf (see conf.LedStatus),
+// where the status can also be stored persistently (see conf.LedStatusDB).
+type LedStatusType = conf.LedStatus
 
 const (
-	// LedOK is the default state of a NeoPixel.
-	LedOK LedStatusType = iota
-	// NeoPixels with status LedDefect will be blacked out. This means that
-	// the sent color data for this pixel will always be (0,0,0), i.e. black.
-	// This status can be used if a NeoPixel propagates data as expected
-	// but is not able to correctly display its own color.
-	LedDefect
-	// LedMissing can be used, if a NeoPixel does not even propagate data
-	// to NeoPixels further down the chain. Such a pixel has to be cut out
-	// of the chain and the wires need to be shortened. For the time till a
-	// replacement pixel is organized and soldered in, the pixel must have
-	// status LedMissing.
-	LedMissing
+	LedOK      = conf.LedOK
+	LedDefect  = conf.LedDefect
+	LedMissing = conf.LedMissing
 )
 
 // Each implementation of a Displayer should embed this embeddable. It
 // provides default implementations for a number of general methods.
 type DisplayEmbed struct {
-	ModConf    conf.ModuleConfig
-	impl       Displayer
-	numLeds    int
-	size       image.Point
-	buffer     []byte
-	gammaVal   [3]float64
-	gammaTbl   [3][256]byte
-	statusList []LedStatusType
+	ModConf     conf.ModuleConfig
+	impl        Displayer
+	numLeds     int
+	size        image.Point
+	buffer      []byte
+	gammaVal    [3]float64
+	gammaTbl    [3][256]byte
+	gammaTbl16  [3][256]uint16
+	levelTbl    [3][256]byte
+	hwBright    float64
+	statusList  []LedStatusType
+	order       conf.ColorOrder
+	chanTbl     [][3]uint8
+	bytesPerLed int
+	whitePt     [3]int
+	power       *powerLimiter
+	dither      bool
+	ditherAcc   []byte
+	calib       conf.Calibration
+	calTbl      []*calMatrix
 }
 
 // An embedding type needs to call this method once in order to set initial
@@ -85,9 +95,17 @@ type DisplayEmbed struct {
 func (d *DisplayEmbed) Init(impl Displayer, numLeds int) {
 	d.impl = impl
 	d.numLeds = numLeds
+	d.bytesPerLed = 3
 	d.buffer = make([]byte, 3*numLeds)
 	d.statusList = make([]LedStatusType, numLeds)
+	for colorIdx := range d.levelTbl {
+		for i := range 256 {
+			d.levelTbl[colorIdx][i] = byte(i)
+		}
+	}
+	d.hwBright = 1.0
 	d.SetGamma(impl.DefaultGamma())
+	d.power = newPowerLimiter(DefaultPowerConfig(), nil, numLeds)
 }
 
 // See NumLeds in interface Displayer.
@@ -114,6 +132,51 @@ func (d *DisplayEmbed) ModuleConfig() conf.ModuleConfig {
 func (d *DisplayEmbed) SetModuleConfig(cnf conf.ModuleConfig) {
 	d.ModConf = cnf
 	d.size = cnf.Size()
+	d.updateChannels()
+	if d.power != nil && d.SetPowerConfig(d.power.cfg) != nil {
+		d.SetPowerConfig(DefaultPowerConfig())
+	}
+	d.updateCalibration()
+}
+
+// Returns the color order of the displayer.
+func (d *DisplayEmbed) ColorOrder() conf.ColorOrder {
+	return d.order
+}
+
+// Sets the order in which the displayer sends the colors on the wire.
+// Modules with an order of their own (see conf.ModulePosition) override
+// it. Displayers without a physical order (e.g. emulators) don't call this
+// method; for them, the colors are always passed as R, G, B to Send.
+func (d *DisplayEmbed) SetColorOrder(order conf.ColorOrder) {
+	d.order = order
+	d.updateChannels()
+}
+
+// Computes the channel table used by Display. If all LEDs use RGB, no
+// table is needed.
+func (d *DisplayEmbed) updateChannels() {
+	d.chanTbl = nil
+	if d.order == conf.DefOrder {
+		return
+	}
+	orders := d.ModConf.ColorOrders(d.order)
+	for idx, order := range orders {
+		if order == conf.RGB {
+			continue
+		}
+		if d.chanTbl == nil {
+			d.chanTbl = make([][3]uint8, d.numLeds)
+			for i := range d.chanTbl {
+				d.chanTbl[i] = [3]uint8{0, 1, 2}
+			}
+		}
+		if idx < len(d.chanTbl) {
+			for i, c := range order.Channels() {
+				d.chanTbl[idx][i] = uint8(c)
+			}
+		}
+	}
 }
 
 // See Gamma in interface Displayer.
@@ -121,37 +184,100 @@ func (d *DisplayEmbed) Gamma() (r, g, b float64) {
 	return d.gammaVal[0], d.gammaVal[1], d.gammaVal[2]
 }
 
-// See SetGamma in interface Displayer.
+// See SetGamma in interface Displayer. Besides the 8 bit table, a table
+// with 8 additional fractional bits is computed for the temporal dithering
+// (see SetDithering).
 func (d *DisplayEmbed) SetGamma(r, g, b float64) {
 	d.gammaVal[0], d.gammaVal[1], d.gammaVal[2] = r, g, b
 	for colorIdx, val := range d.gammaVal {
+		lvl := &d.levelTbl[colorIdx]
+		for i := range 256 {
+			x := 255.0 * math.Pow(float64(i)/255.0, val)
+			d.gammaTbl[colorIdx][i] = lvl[byte(x)]
+			lo := min(int(x), 254)
+			y := float64(lvl[lo]) + (x-float64(lo))*(float64(lvl[lo+1])-float64(lvl[lo]))
+			d.gammaTbl16[colorIdx][i] = uint16(min(math.Round(256.0*y), 255.0*256.0))
+		}
+	}
+}
+
+// Limits the brightness of the three colors to the given values by scaling
+// them after the gamma correction (see BrightnessLimiter). Displayers with a
+// global brightness in hardware (e.g. APA102) override this method.
+func (d *DisplayEmbed) SetMaxValue(r, g, b uint8) {
+	var tbl [3][256]byte
+
+	for colorIdx, val := range [3]uint8{r, g, b} {
 		for i := range 256 {
-			d.gammaTbl[colorIdx][i] = byte(255.0 * math.Pow(float64(i)/255.0, val))
+			tbl[colorIdx][i] = byte((i*int(val) + 127) / 255)
 		}
 	}
+	d.setLevels(tbl, 1.0)
 }
 
-// See Display in interface Displayer.
+// Displayers can set an additional mapping per color which is applied
+// after the gamma correction (e.g. APA102 to compensate its hardware
+// brightness). hwBright is the factor (0.0 - 1.0) by which the hardware
+// dims the LEDs additionally; the power limiter multiplies its estimate by
+// this factor.
+func (d *DisplayEmbed) setLevels(tbl [3][256]byte, hwBright float64) {
+	d.levelTbl = tbl
+	d.hwBright = hwBright
+	d.SetGamma(d.Gamma())
+}
+
+// See Display in interface Displayer. The colors are first corrected with
+// the calibration (see SetCalibration), then gamma corrected. For RGBW
+// displayers (see SetWhitePoint), 4 bytes per NeoPixel are passed to Send.
+// The current of the frame is estimated and limited by the power limiter
+// (see SetPowerConfig).
 func (d *DisplayEmbed) Display(buffer []byte) {
 	var srcIdx, dstIdx int
 	var src, dst []byte
+	var rgb, cal [3]byte
+	var w byte
 
+	bpp := d.bytesPerLed
+	ch := [3]uint8{0, 1, 2}
+	calTbl := d.calTbl
+	power := d.power
+	power.reset()
 	for srcIdx, dstIdx = 0, 0; srcIdx < len(buffer)/3; srcIdx++ {
 		if d.statusList[srcIdx] == LedMissing {
 			continue
 		}
-		dst = d.buffer[3*dstIdx : 3*dstIdx+3 : 3*dstIdx+3]
+		dst = d.buffer[bpp*dstIdx : bpp*dstIdx+bpp : bpp*dstIdx+bpp]
 		if d.statusList[srcIdx] == LedDefect {
-			dst[0] = 0x00
-			dst[1] = 0x00
-			dst[2] = 0x00
+			clear(dst)
+			power.add(srcIdx, dstIdx, [3]byte{}, 0)
+			dstIdx++
+			continue
+		}
+		src = buffer[3*srcIdx : 3*srcIdx+3 : 3*srcIdx+3]
+		if calTbl != nil && calTbl[srcIdx] != nil {
+			cal = calTbl[srcIdx].apply(src)
+			src = cal[:]
+		}
+		if d.dither {
+			d.ditherLed(srcIdx, src, &rgb)
 		} else {
-			src = buffer[3*srcIdx : 3*srcIdx+3 : 3*srcIdx+3]
-			dst[0] = d.gammaTbl[0][src[0]]
-			dst[1] = d.gammaTbl[1][src[1]]
-			dst[2] = d.gammaTbl[2][src[2]]
+			rgb[0] = d.gammaTbl[0][src[0]]
+			rgb[1] = d.gammaTbl[1][src[1]]
+			rgb[2] = d.gammaTbl[2][src[2]]
+		}
+		if bpp == 4 {
+			w = d.extractWhite(&rgb)
+			dst[3] = w
+		}
+		power.add(srcIdx, dstIdx, rgb, w)
+		if d.chanTbl != nil {
+			ch = d.chanTbl[srcIdx]
 		}
+		dst[0] = rgb[ch[0]]
+		dst[1] = rgb[ch[1]]
+		dst[2] = rgb[ch[2]]
 		dstIdx++
 	}
+	power.limit(d.buffer, bpp, dstIdx, d.hwBright)
 	d.impl.Send(d.buffer)
 }
@@ -0,0 +1,42 @@
+package ledgrid
+
+// With a gamma value of 2.5, the bottom of the 8 bit range collapses: the
+// first 40 input values are all mapped to 0, 1 or 2, so slow fades step
+// visibly in a dark environment. With temporal dithering, the gamma
+// correction uses a table with 8 additional fractional bits. The fractions
+// are accumulated per LED and color over consecutive frames; whenever the
+// accumulated error exceeds 1, the LED is shown one step brighter. Averaged
+// over time, the LED thus shows the exact value of the gamma curve.
+//
+// Dithering only works if frames are sent continuously (i.e. by an
+// animation) and is best suited for NeoPixels without PWM of their own
+// (e.g. WS2801) which are updated at a high frame rate.
+
+// Turns the temporal dithering on or off.
+func (d *DisplayEmbed) SetDithering(on bool) {
+	d.dither = on
+	if on && d.ditherAcc == nil {
+		d.ditherAcc = make([]byte, 3*d.numLeds)
+	}
+}
+
+// Returns true, if the temporal dithering is active.
+func (d *DisplayEmbed) Dithering() bool {
+	return d.dither
+}
+
+// Computes the gamma corrected values of the LED with index idx on the
+// chain and updates its accumulated error.
+func (d *DisplayEmbed) ditherLed(idx int, src []byte, rgb *[3]byte) {
+	acc := d.ditherAcc[3*idx : 3*idx+3 : 3*idx+3]
+	for c := range 3 {
+		val := d.gammaTbl16[c][src[c]]
+		sum := int(acc[c]) + int(val&0xff)
+		rgb[c] = byte(val >> 8)
+		if sum >= 256 {
+			rgb[c]++
+			sum -= 256
+		}
+		acc[c] = byte(sum)
+	}
+}
@@ -0,0 +1,51 @@
+package ledgrid
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// Ueber N Bilder gemittelt muss die Ausgabe dem exakten Wert der
+// Gamma-Kurve entsprechen, ohne Dithering ist sie auf ganze Werte gerundet.
+func TestDithering(t *testing.T) {
+	const numFrames = 256
+
+	disp := newTestDisplayer(testModConf(image.Point{10, 10}))
+	disp.SetGamma(2.5, 2.5, 2.5)
+	frame := make([]byte, 3*disp.NumLeds())
+	for i := range frame {
+		frame[i] = byte(i % 48)
+	}
+
+	average := func() []float64 {
+		sums := make([]float64, len(frame))
+		for range numFrames {
+			disp.Display(frame)
+			b := <-disp.frames
+			for i, v := range b {
+				sums[i] += float64(v)
+			}
+		}
+		for i := range sums {
+			sums[i] /= numFrames
+		}
+		return sums
+	}
+
+	var errPlain, errDither float64
+	avg := average()
+	disp.SetDithering(true)
+	avgDither := average()
+	for i, v := range frame {
+		want := 255.0 * math.Pow(float64(v)/255.0, 2.5)
+		errPlain = max(errPlain, math.Abs(avg[i]-want))
+		errDither = max(errDither, math.Abs(avgDither[i]-want))
+	}
+	if errDither > 2.0/256.0 {
+		t.Errorf("dithered average deviates by %.4f", errDither)
+	}
+	if errPlain < 0.5 {
+		t.Errorf("expected larger deviation without dithering, got %.4f", errPlain)
+	}
+}
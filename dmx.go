@@ -0,0 +1,324 @@
+package ledgrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// Lighting desks don't speak the ledgrid protocol but E1.31 (also known as
+// streaming ACN or sACN) or Art-Net. Both protocols transport DMX universes
+// via UDP. The GridServer can listen for them and maps the received
+// universes onto the LED chain, using a conf.DMXConfig.
+const (
+	DefE131Port   = 5568
+	DefArtNetPort = 6454
+)
+
+var (
+	ErrNotE131   = errors.New("not an E1.31 data packet")
+	ErrNotArtNet = errors.New("not an Art-Net DMX packet")
+)
+
+// DMXPacket contains the relevant part of a received E1.31 or Art-Net
+// packet. Data holds the channel values, Data[0] is channel 1. Priority is
+// only transmitted by E1.31 (0..200), Art-Net packets use DefPriority.
+type DMXPacket struct {
+	Universe int
+	Seq      uint8
+	Priority Priority
+	Data     []byte
+}
+
+// Layout of an E1.31 data packet (all values big endian). Only the fields
+// needed here are listed.
+const (
+	e131VectorRoot    = 0x00000004
+	e131VectorFraming = 0x00000002
+	e131VectorDMP     = 0x02
+	e131OptTerminated = 0x40
+	e131HeaderSize    = 126
+)
+
+var e131PacketID = []byte("ASC-E1.17\x00\x00\x00")
+
+// Decodes an E1.31 data packet. Stream terminated packets and packets with a
+// start code other than 0 (e.g. per channel priority) return a packet
+// without data.
+func ParseE131(b []byte) (DMXPacket, error) {
+	var pkt DMXPacket
+
+	if len(b) < e131HeaderSize || !bytes.Equal(b[4:16], e131PacketID) ||
+		binary.BigEndian.Uint32(b[18:22]) != e131VectorRoot ||
+		binary.BigEndian.Uint32(b[40:44]) != e131VectorFraming ||
+		b[117] != e131VectorDMP {
+		return pkt, ErrNotE131
+	}
+	pkt.Priority = Priority(b[108])
+	pkt.Seq = b[111]
+	pkt.Universe = int(binary.BigEndian.Uint16(b[113:115]))
+	if b[112]&e131OptTerminated != 0 || b[125] != 0x00 {
+		return pkt, nil
+	}
+	count := int(binary.BigEndian.Uint16(b[123:125]))
+	if count < 1 || e131HeaderSize+count-1 > len(b) {
+		return pkt, ErrNotE131
+	}
+	pkt.Data = b[e131HeaderSize : e131HeaderSize+count-1]
+	return pkt, nil
+}
+
+// Creates an E1.31 data packet. This is the counterpart of ParseE131 and is
+// mainly used for testing.
+func NewE131Packet(universe int, seq uint8, data []byte) []byte {
+	return NewE131PacketPrio(universe, DefPriority, seq, data)
+}
+
+// Same as NewE131Packet, but with the given priority.
+func NewE131PacketPrio(universe int, prio Priority, seq uint8, data []byte) []byte {
+	b := make([]byte, e131HeaderSize+len(data))
+	binary.BigEndian.PutUint16(b[0:2], 0x0010)
+	copy(b[4:16], e131PacketID)
+	binary.BigEndian.PutUint16(b[16:18], 0x7000|uint16(len(b)-16))
+	binary.BigEndian.PutUint32(b[18:22], e131VectorRoot)
+	binary.BigEndian.PutUint16(b[38:40], 0x7000|uint16(len(b)-38))
+	binary.BigEndian.PutUint32(b[40:44], e131VectorFraming)
+	copy(b[44:108], "ledgrid")
+	b[108] = byte(prio)
+	b[111] = seq
+	binary.BigEndian.PutUint16(b[113:115], uint16(universe))
+	binary.BigEndian.PutUint16(b[115:117], 0x7000|uint16(len(b)-115))
+	b[117] = e131VectorDMP
+	b[118] = 0xa1
+	binary.BigEndian.PutUint16(b[121:123], 1)
+	binary.BigEndian.PutUint16(b[123:125], uint16(len(data)+1))
+	copy(b[e131HeaderSize:], data)
+	return b
+}
+
+// Returns the multicast group on which universe is sent.
+func E131MulticastAddr(universe int, port uint) *net.UDPAddr {
+	ip := netip.AddrFrom4([4]byte{239, 255, byte(universe >> 8), byte(universe)})
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+}
+
+// Layout of an Art-Net ArtDmx packet. The opcode is little endian, the
+// length big endian.
+const (
+	artNetOpDmx      = 0x5000
+	artNetHeaderSize = 18
+)
+
+var artNetID = []byte("Art-Net\x00")
+
+// Decodes an ArtDmx packet. All other Art-Net packets (ArtPoll, ArtSync,
+// etc.) return ErrNotArtNet.
+func ParseArtNet(b []byte) (DMXPacket, error) {
+	var pkt DMXPacket
+
+	if len(b) < artNetHeaderSize || !bytes.Equal(b[0:8], artNetID) ||
+		binary.LittleEndian.Uint16(b[8:10]) != artNetOpDmx {
+		return pkt, ErrNotArtNet
+	}
+	pkt.Priority = DefPriority
+	pkt.Seq = b[12]
+	pkt.Universe = int(b[15]&0x7f)<<8 | int(b[14])
+	length := int(binary.BigEndian.Uint16(b[16:18]))
+	if artNetHeaderSize+length > len(b) {
+		return pkt, ErrNotArtNet
+	}
+	pkt.Data = b[artNetHeaderSize : artNetHeaderSize+length]
+	return pkt, nil
+}
+
+// Creates an ArtDmx packet, the counterpart of ParseArtNet.
+func NewArtNetPacket(universe int, seq uint8, data []byte) []byte {
+	b := make([]byte, artNetHeaderSize+len(data))
+	copy(b[0:8], artNetID)
+	binary.LittleEndian.PutUint16(b[8:10], artNetOpDmx)
+	b[11] = 14
+	b[12] = seq
+	b[14] = byte(universe)
+	b[15] = byte(universe>>8) & 0x7f
+	binary.BigEndian.PutUint16(b[16:18], uint16(len(data)))
+	copy(b[artNetHeaderSize:], data)
+	return b
+}
+
+// The DMXReceiver collects the universes of one frame in a buffer and
+// passes it to the displayer as soon as all mapped universes have been
+// received, or when a universe is received a second time before the frame
+// was complete. The receiver is a single source for the arbiter (see
+// arbiter.go); its priority is the one of the last received packet.
+type DMXReceiver struct {
+	server       *GridServer
+	dmxConf      conf.DMXConfig
+	buffer       []byte
+	universes    []int
+	pending      map[int]bool
+	lastSeq      map[int]uint8
+	seqZeroValid bool
+	conns        []*net.UDPConn
+	parse        func(b []byte) (DMXPacket, error)
+	source       *FrameSource
+	mutex        sync.Mutex
+}
+
+func newDMXReceiver(server *GridServer, name string, dmxConf conf.DMXConfig,
+	parse func(b []byte) (DMXPacket, error)) (*DMXReceiver, error) {
+	if err := dmxConf.Verify(server.ModuleConfig()); err != nil {
+		return nil, err
+	}
+	r := &DMXReceiver{server: server, dmxConf: dmxConf, parse: parse}
+	r.source = server.OpenSource(name, DefPriority)
+	r.buffer = make([]byte, server.bufferSize)
+	r.universes = dmxConf.Universes()
+	r.pending = make(map[int]bool)
+	r.lastSeq = make(map[int]uint8)
+	return r, nil
+}
+
+// Enables the reception of E1.31 packets on port. If multicast is true,
+// the receiver joins the multicast groups of all universes in dmxConf,
+// otherwise only unicast packets are received.
+func (p *GridServer) EnableE131(port uint, dmxConf conf.DMXConfig, multicast bool) error {
+	var err error
+
+	p.e131Recv, err = newDMXReceiver(p, "e131", dmxConf, ParseE131)
+	if err != nil {
+		return err
+	}
+	p.e131Recv.seqZeroValid = true
+	if !multicast {
+		return p.e131Recv.listen(port)
+	}
+	for _, univ := range dmxConf.Universes() {
+		conn, err := net.ListenMulticastUDP("udp4", nil, E131MulticastAddr(univ, port))
+		if err != nil {
+			p.e131Recv.Close()
+			return err
+		}
+		p.e131Recv.conns = append(p.e131Recv.conns, conn)
+	}
+	return nil
+}
+
+// Enables the reception of Art-Net (ArtDmx) packets on port.
+func (p *GridServer) EnableArtNet(port uint, dmxConf conf.DMXConfig) error {
+	var err error
+
+	p.artNetRecv, err = newDMXReceiver(p, "artnet", dmxConf, ParseArtNet)
+	if err != nil {
+		return err
+	}
+	return p.artNetRecv.listen(port)
+}
+
+func (r *DMXReceiver) listen(port uint) error {
+	addrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(port))
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(addrPort))
+	if err != nil {
+		return err
+	}
+	r.conns = append(r.conns, conn)
+	return nil
+}
+
+// Starts one reading goroutine per socket.
+func (r *DMXReceiver) HandleEvents() {
+	for _, conn := range r.conns {
+		go r.handleConn(conn)
+	}
+}
+
+// Closes all sockets of this receiver.
+func (r *DMXReceiver) Close() {
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	r.source.Close()
+}
+
+// Returns the local addresses of the sockets.
+func (r *DMXReceiver) Addrs() []net.Addr {
+	addrList := make([]net.Addr, len(r.conns))
+	for i, conn := range r.conns {
+		addrList[i] = conn.LocalAddr()
+	}
+	return addrList
+}
+
+func (r *DMXReceiver) handleConn(conn *net.UDPConn) {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Failed DMX Read(): %v", err)
+			}
+			return
+		}
+		pkt, err := r.parse(buffer[:n])
+		if err != nil {
+			continue
+		}
+		r.server.countRecv(n)
+		r.Receive(pkt)
+	}
+}
+
+// Processes a single universe. Packets of universes which are not part
+// of the mapping, as well as duplicated or outdated packets (according to
+// the sequence number) are ignored.
+func (r *DMXReceiver) Receive(pkt DMXPacket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Art-Net uses a sequence number of 0 to signal that sequencing is
+	// disabled. For E1.31, 0 is a regular value.
+	if last, ok := r.lastSeq[pkt.Universe]; ok && (pkt.Seq != 0 || r.seqZeroValid) {
+		if diff := int8(pkt.Seq - last); diff <= 0 && diff > -20 {
+			return
+		}
+	}
+	r.lastSeq[pkt.Universe] = pkt.Seq
+	if pkt.Data == nil || !slices.Contains(r.universes, pkt.Universe) {
+		return
+	}
+	if pkt.Priority != r.source.Priority() {
+		r.source.SetPriority(pkt.Priority)
+	}
+
+	if r.pending[pkt.Universe] {
+		r.show()
+	}
+	for _, m := range r.dmxConf {
+		if m.Universe != pkt.Universe {
+			continue
+		}
+		src := pkt.Data[min(len(pkt.Data), m.StartChannel-1):]
+		dst := r.buffer[3*m.FirstLed : 3*(m.FirstLed+m.NumLeds)]
+		copy(dst, src)
+	}
+	r.pending[pkt.Universe] = true
+	if len(r.pending) == len(r.universes) {
+		r.show()
+	}
+}
+
+func (r *DMXReceiver) show() {
+	clear(r.pending)
+	r.source.Display(r.buffer)
+}
+
+func (r *DMXReceiver) String() string {
+	return fmt.Sprintf("DMX receiver on %v (%d universes)", r.Addrs(), len(r.universes))
+}
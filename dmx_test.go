@@ -0,0 +1,103 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"net"
+	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// Sendet die Pakete via UDP an den ersten Socket des Empfaengers.
+func sendDMX(t *testing.T, recv *DMXReceiver, pktList ...[]byte) {
+	t.Helper()
+	conn, err := net.DialUDP("udp4", nil, recv.Addrs()[0].(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, pkt := range pktList {
+		if _, err = conn.Write(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testDMX(t *testing.T, enable func(*GridServer, conf.DMXConfig) (*DMXReceiver, error),
+	newPacket func(int, uint8, []byte) []byte) {
+	modConf := testModConf(image.Point{20, 10})
+	disp := newTestDisplayer(modConf)
+	server := newTestServer(disp)
+	dmxConf := conf.DefaultDMXConfig(disp.NumLeds())
+	if len(dmxConf) != 2 {
+		t.Fatalf("expected 2 universes for %d LEDs, got %d", disp.NumLeds(), len(dmxConf))
+	}
+	recv, err := enable(server, dmxConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close()
+	recv.HandleEvents()
+
+	frame := testFrame(3*disp.NumLeds(), 5)
+	sendDMX(t, recv,
+		newPacket(1, 1, frame[:3*conf.DMXMaxLeds]),
+		newPacket(1, 1, frame[:3*conf.DMXMaxLeds]),
+		newPacket(7, 1, frame[:3*conf.DMXMaxLeds]),
+		newPacket(2, 1, frame[3*conf.DMXMaxLeds:]))
+	expectFrame(t, disp, frame)
+}
+
+func TestE131(t *testing.T) {
+	testDMX(t, func(p *GridServer, dmxConf conf.DMXConfig) (*DMXReceiver, error) {
+		err := p.EnableE131(0, dmxConf, false)
+		return p.e131Recv, err
+	}, NewE131Packet)
+}
+
+func TestArtNet(t *testing.T) {
+	testDMX(t, func(p *GridServer, dmxConf conf.DMXConfig) (*DMXReceiver, error) {
+		err := p.EnableArtNet(0, dmxConf)
+		return p.artNetRecv, err
+	}, NewArtNetPacket)
+}
+
+func TestDMXMapping(t *testing.T) {
+	modConf := testModConf(image.Point{20, 10})
+	disp := newTestDisplayer(modConf)
+	server := newTestServer(disp)
+
+	// Universum 3 steuert ab Kanal 10 die LEDs 100..109 an.
+	dmxConf := conf.DMXConfig{{Universe: 3, StartChannel: 10, FirstLed: 100, NumLeds: 10}}
+	recv, err := newDMXReceiver(server, "e131", dmxConf, ParseE131)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := testFrame(9+30, 1)
+	pkt, err := ParseE131(NewE131Packet(3, 0, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.Receive(pkt)
+	frame := make([]byte, 3*disp.NumLeds())
+	copy(frame[300:], data[9:])
+	got := <-disp.frames
+	if !bytes.Equal(got, frame) {
+		t.Errorf("universe not mapped onto LEDs 100..109")
+	}
+
+	dmxConf[0].FirstLed = 195
+	if err = dmxConf.Verify(modConf); err == nil {
+		t.Errorf("mapping beyond the end of the chain must fail")
+	}
+	dmxConf[0].FirstLed = 100
+	if err = append(dmxConf, conf.DMXMapping{Universe: 2, StartChannel: 1,
+		FirstLed: 105, NumLeds: 10}).Verify(modConf); err == nil {
+		t.Errorf("overlapping mappings must fail")
+	}
+	dmxConf[0].Universe = 0
+	if err = dmxConf.Verify(modConf); err == nil {
+		t.Errorf("universe 0 must fail")
+	}
+}
@@ -0,0 +1,233 @@
+package ledgrid
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Starting with protocol version 2, the payload of a frame begins with a
+// byte denoting the encoding of the remaining data. This allows a client
+// to send only the changes with respect to the previous frame, which saves
+// a lot of bandwidth on large panels where most pixels don't change from
+// one frame to the next.
+type FrameEncoding uint8
+
+const (
+	// The data contains the color values of all LEDs, as in version 1.
+	EncRaw FrameEncoding = iota
+	// The whole frame is compressed with run-length encoding (see RLE).
+	EncRLE
+	// The frame is XOR'ed with the previous frame and the result is
+	// compressed with RLE. Only allowed, if the receiver has decoded the
+	// previous frame.
+	EncDelta
+	NumEncodings
+)
+
+func (e FrameEncoding) String() string {
+	switch e {
+	case EncRaw:
+		return "raw"
+	case EncRLE:
+		return "rle"
+	case EncDelta:
+		return "delta"
+	}
+	return fmt.Sprintf("FrameEncoding(%d)", uint8(e))
+}
+
+// Used as a command line flag.
+func (e *FrameEncoding) Set(v string) error {
+	for enc := range NumEncodings {
+		if enc.String() == v {
+			*e = enc
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown frame encoding '%s'", v)
+}
+
+// The encodings are shown by name in the REST API.
+func (e FrameEncoding) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+func (e *FrameEncoding) UnmarshalText(text []byte) error {
+	return e.Set(string(text))
+}
+
+var (
+	ErrBadEncoding = errors.New("invalid frame encoding")
+	ErrNoKeyframe  = errors.New("delta frame without preceding frame")
+)
+
+// The run-length encoding is similar to PackBits: a control byte n in
+// the range 0..127 is followed by n+1 literal bytes, a control byte in the
+// range 128..255 is followed by a single byte which has to be repeated
+// n-126 times (2..129).
+const (
+	rleMaxLiteral = 128
+	rleMaxRun     = 129
+)
+
+// Appends the run-length encoded form of src to dst.
+func RLE(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && run < rleMaxRun && src[i+run] == src[i] {
+			run++
+		}
+		if run >= 2 {
+			dst = append(dst, byte(run+126), src[i])
+			i += run
+			continue
+		}
+		j := i + 1
+		for j < len(src) && j-i < rleMaxLiteral &&
+			(j+1 >= len(src) || src[j] != src[j+1]) {
+			j++
+		}
+		dst = append(dst, byte(j-i-1))
+		dst = append(dst, src[i:j]...)
+		i = j
+	}
+	return dst
+}
+
+// Decodes the run-length encoded data in src into dst. The decoded data
+// must fill dst completely.
+func UnRLE(dst, src []byte) error {
+	var n int
+
+	for i := 0; i < len(src); {
+		ctrl := int(src[i])
+		i++
+		if ctrl < rleMaxLiteral {
+			cnt := ctrl + 1
+			if i+cnt > len(src) || n+cnt > len(dst) {
+				return ErrBadEncoding
+			}
+			n += copy(dst[n:], src[i:i+cnt])
+			i += cnt
+		} else {
+			cnt := ctrl - 126
+			if i >= len(src) || n+cnt > len(dst) {
+				return ErrBadEncoding
+			}
+			for k := range cnt {
+				dst[n+k] = src[i]
+			}
+			n += cnt
+			i++
+		}
+	}
+	if n != len(dst) {
+		return ErrBadEncoding
+	}
+	return nil
+}
+
+// A FrameEncoder keeps the previous frame and encodes new frames according
+// to the chosen encoding. With EncDelta, every KeyInterval'th frame is sent
+// as a keyframe (with EncRLE). If an encoded frame would be larger than the
+// raw frame, EncRaw is used instead.
+type FrameEncoder struct {
+	Encoding    FrameEncoding
+	KeyInterval int
+	prev, tmp   []byte
+	count       int
+	buffer      []byte
+}
+
+func NewFrameEncoder(enc FrameEncoding, keyInterval int) *FrameEncoder {
+	return &FrameEncoder{Encoding: enc, KeyInterval: max(1, keyInterval)}
+}
+
+// Returns the payload (encoding byte and data) for frame. The returned
+// slice is valid until the next call to Encode.
+func (e *FrameEncoder) Encode(frame []byte) []byte {
+	enc := e.Encoding
+	if enc == EncDelta && (len(e.prev) != len(frame) || e.count%e.KeyInterval == 0) {
+		enc = EncRLE
+	}
+	e.buffer = append(e.buffer[:0], byte(enc))
+	switch enc {
+	case EncRLE:
+		e.buffer = RLE(e.buffer, frame)
+	case EncDelta:
+		e.tmp = append(e.tmp[:0], frame...)
+		for i, b := range e.prev {
+			e.tmp[i] ^= b
+		}
+		e.buffer = RLE(e.buffer, e.tmp)
+	}
+	if enc == EncRaw || len(e.buffer) > len(frame)+1 {
+		e.buffer = append(e.buffer[:0], byte(EncRaw))
+		e.buffer = append(e.buffer, frame...)
+	}
+	e.prev = append(e.prev[:0], frame...)
+	e.count++
+	return e.buffer
+}
+
+// Forces the next frame to be sent as a keyframe. Must be called whenever
+// the receiver may have lost a frame.
+func (e *FrameEncoder) Reset() {
+	e.prev = e.prev[:0]
+	e.count = 0
+}
+
+// The FrameDecoder is the counterpart of FrameEncoder and keeps the last
+// decoded frame in order to apply delta frames.
+type FrameDecoder struct {
+	frame, tmp []byte
+	valid      bool
+}
+
+func NewFrameDecoder(size int) *FrameDecoder {
+	return &FrameDecoder{frame: make([]byte, size), tmp: make([]byte, size)}
+}
+
+// Decodes the payload and returns the complete frame. The returned slice is
+// owned by the decoder and valid until the next call to Decode.
+func (d *FrameDecoder) Decode(payload []byte) ([]byte, error) {
+	if len(payload) < 1 {
+		return nil, ErrBadEncoding
+	}
+	data := payload[1:]
+	switch FrameEncoding(payload[0]) {
+	case EncRaw:
+		if len(data) != len(d.frame) {
+			d.valid = false
+			return nil, fmt.Errorf("%w: expected %d bytes, got %d",
+				ErrBadLength, len(d.frame), len(data))
+		}
+		copy(d.frame, data)
+	case EncRLE:
+		if err := UnRLE(d.frame, data); err != nil {
+			d.valid = false
+			return nil, err
+		}
+	case EncDelta:
+		if !d.valid {
+			return nil, ErrNoKeyframe
+		}
+		if err := UnRLE(d.tmp, data); err != nil {
+			d.valid = false
+			return nil, err
+		}
+		for i, b := range d.tmp {
+			d.frame[i] ^= b
+		}
+	default:
+		return nil, ErrBadEncoding
+	}
+	d.valid = true
+	return d.frame, nil
+}
+
+// Marks the last frame as invalid (e.g. after a lost frame). Delta frames
+// are rejected until the next keyframe.
+func (d *FrameDecoder) Invalidate() {
+	d.valid = false
+}
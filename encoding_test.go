@@ -0,0 +1,114 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestRLE(t *testing.T) {
+	rnd := rand.New(rand.NewSource(123_456_789))
+	for _, size := range []int{1, 2, 3, 127, 128, 129, 130, 1200, 4800} {
+		src := make([]byte, size)
+		// Zufaellige Mischung von Wiederholungen und Einzelwerten.
+		for i := 0; i < size; {
+			n := min(size-i, 1+rnd.Intn(200))
+			val := byte(rnd.Intn(4))
+			for j := range n {
+				if rnd.Intn(3) == 0 {
+					src[i+j] = byte(rnd.Intn(256))
+				} else {
+					src[i+j] = val
+				}
+			}
+			i += n
+		}
+		enc := RLE(nil, src)
+		dst := make([]byte, size)
+		if err := UnRLE(dst, enc); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(src, dst) {
+			t.Errorf("size %d: decoded data differs", size)
+		}
+	}
+}
+
+func TestFrameEncoder(t *testing.T) {
+	const numFrames = 20
+
+	frame := make([]byte, 3*1600)
+	enc := NewFrameEncoder(EncDelta, 10)
+	dec := NewFrameDecoder(len(frame))
+	raw, encoded := 0, 0
+	for i := range numFrames {
+		// Pro Bild aendern sich nur wenige Pixel.
+		frame[3*i] = 0xff
+		frame[3*i+1] = byte(i)
+		payload := enc.Encode(frame)
+		if i%10 == 0 && FrameEncoding(payload[0]) != EncRLE {
+			t.Errorf("frame %d: expected keyframe, got %v", i, FrameEncoding(payload[0]))
+		}
+		out, err := dec.Decode(payload)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(out, frame) {
+			t.Fatalf("frame %d: decoded frame differs", i)
+		}
+		raw += len(frame)
+		encoded += len(payload)
+	}
+	if ratio := float64(raw) / float64(encoded); ratio < 20.0 {
+		t.Errorf("compression ratio too low: %.1f", ratio)
+	}
+
+	// Nach einem verlorenen Bild werden Delta-Frames verworfen.
+	enc.Encode(frame)
+	dec.Invalidate()
+	if _, err := dec.Decode(enc.Encode(frame)); err != ErrNoKeyframe {
+		t.Errorf("expected ErrNoKeyframe, got %v", err)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{40, 40}))
+	server := newTestServer(disp)
+	srvConn, cltConn := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		server.HandleMessage(srvConn)
+		close(done)
+	}()
+
+	client := &NetGridClient{conn: cltConn, network: "tcp",
+		stopwatch: NewStopwatch()}
+	if err := client.handshake(cltConn); err != nil {
+		t.Fatal(err)
+	}
+	client.encoder = NewFrameEncoder(EncDelta, 25)
+	frame := make([]byte, 3*disp.NumLeds())
+	for i := range 10 {
+		frame[i] = 0xff
+		client.Send(frame)
+		expectFrame(t, disp, frame)
+	}
+	client.Close()
+	<-disp.frames
+	<-done
+	ratio := server.CompressionRatio()
+	if ratio < 10.0 {
+		t.Errorf("compression ratio too low: %.1f", ratio)
+	}
+
+	// Bilder anderer Quellen veraendern das Verhaeltnis nicht.
+	src := server.OpenSource("opc:test", DefPriority)
+	src.Display(frame)
+	<-disp.frames
+	if server.CompressionRatio() != ratio {
+		t.Errorf("compression ratio changed by another source: %.1f",
+			server.CompressionRatio())
+	}
+}
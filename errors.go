@@ -0,0 +1,41 @@
+package ledgrid
+
+import (
+	"errors"
+)
+
+// Sentinel errors of the package. Functions wrap them together with the
+// underlying cause (e.g. the error of the network or the file system), so
+// callers can check for both with errors.Is. The errors of the wire
+// protocol are defined in protocol.go and encoding.go, the ones of the
+// module configuration in package conf.
+var (
+	// The connection to the GridServer couldn't be established.
+	ErrConnect = errors.New("can't connect to grid server")
+	// Sending data to the GridServer failed, the connection is probably
+	// lost.
+	ErrSend = errors.New("can't send data to grid server")
+	// A call of the REST API failed.
+	ErrAPI = errors.New("grid server API call failed")
+	// The network is neither "tcp" nor "udp".
+	ErrNetwork = errors.New("unsupported network")
+	// A port of the GridServer couldn't be opened.
+	ErrListen = errors.New("can't open port")
+	// The LED hardware couldn't be opened or initialized.
+	ErrDevice = errors.New("can't open device")
+	// The content of a file (image, BlinkenLights animation, etc.) is
+	// invalid.
+	ErrBadFormat = errors.New("invalid file format")
+	// There's no canvas for the requested layer.
+	ErrNoCanvas = errors.New("no canvas for this layer")
+	// After a reconnect, the GridServer reported a module configuration
+	// different from the one the client was started with.
+	ErrGeometry = errors.New("module configuration of grid server has changed")
+	// No GridServer answered the mDNS query.
+	ErrNoGrid = errors.New("no grid server found")
+	// More than one GridServer answered the mDNS query.
+	ErrManyGrids = errors.New("several grid servers found")
+	// The configuration of the power limiter doesn't match the module
+	// configuration or contains invalid values.
+	ErrBadPowerConfig = errors.New("invalid power configuration")
+)
@@ -0,0 +1,54 @@
+package ledgrid
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	_, err := NewNetGridClient("127.0.0.1", "sctp", DefTCPPort, 0)
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("expected ErrNetwork, got %v", err)
+	}
+
+	// Auf einem eben geschlossenen Port nimmt niemand Verbindungen an.
+	lsnr, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint(lsnr.Addr().(*net.TCPAddr).Port)
+	lsnr.Close()
+	_, err = NewNetGridClient("127.0.0.1", "tcp", port, 0)
+	if !errors.Is(err, ErrConnect) {
+		t.Errorf("expected ErrConnect, got %v", err)
+	}
+
+	grid := NewLedGrid(nil, testModConf(image.Point{20, 10}))
+	if _, err = grid.Canvas(0); err != nil {
+		t.Errorf("canvas 0: %v", err)
+	}
+	if _, err = grid.Canvas(1); !errors.Is(err, ErrNoCanvas) {
+		t.Errorf("expected ErrNoCanvas, got %v", err)
+	}
+	if _, err = NewLedGridBySize(nil, image.Point{15, 10}); err == nil {
+		t.Errorf("expected error for bad grid size")
+	}
+
+	dir := t.TempDir()
+	if _, err = LoadImage(filepath.Join(dir, "none.png")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	fileName := filepath.Join(dir, "bad.bml")
+	os.WriteFile(fileName, []byte("<blm><frame>"), 0644)
+	if _, err = ReadBlinkenFile(fileName); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("expected ErrBadFormat, got %v", err)
+	}
+	if _, err = LoadImage(fileName); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("expected ErrBadFormat, got %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package ledgrid
 import (
 	"fmt"
 	"image"
-	"log"
 	"os"
 
 	"golang.org/x/image/font/basicfont"
@@ -50,14 +49,14 @@ var (
 // This function can be used to produce a new fixed font by scaling an existing
 // fixed font. Scaling factors can only be positive integers. The new font
 // is
-func ScaleFixedFont(face *basicfont.Face, factor int, newName string) {
+func ScaleFixedFont(face *basicfont.Face, factor int, newName string) error {
 	width := face.Width
 	height := face.Ascent
 	mask := face.Mask.(*image.Alpha)
 	fileName := fmt.Sprintf("font%s.go", newName)
 	fh, err := os.Create(fileName)
 	if err != nil {
-		log.Fatalf("Couldn't create file: %v", err)
+		return err
 	}
 	defer fh.Close()
 	fmt.Fprintf(fh, "// DO NOT EDIT THIS FILE!\n//\n")
@@ -101,4 +100,5 @@ func ScaleFixedFont(face *basicfont.Face, factor int, newName string) {
 	}
 	fmt.Fprintf(fh, "    },\n")
 	fmt.Fprintf(fh, "}\n")
+	return nil
 }
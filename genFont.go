@@ -3,11 +3,17 @@
 package main
 
 import (
+	"log"
+
 	"github.com/stefan-muehlebach/ledgrid"
 )
 
 func main() {
-	ledgrid.ScaleFixedFont(ledgrid.Pico3x5, 2, "Pico6x10")
-	ledgrid.ScaleFixedFont(ledgrid.Pico3x5, 3, "Pico9x15")
+	if err := ledgrid.ScaleFixedFont(ledgrid.Pico3x5, 2, "Pico6x10"); err != nil {
+		log.Fatal(err)
+	}
+	if err := ledgrid.ScaleFixedFont(ledgrid.Pico3x5, 3, "Pico9x15"); err != nil {
+		log.Fatal(err)
+	}
 	ledgrid.BlowupFixedFont(ledgrid.Pico3x5, "Pico5x9")
 }
@@ -3,6 +3,7 @@ module github.com/stefan-muehlebach/ledgrid
 go 1.27.0
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/stefan-muehlebach/gg v1.5.1
 	golang.org/x/image v0.45.0
 	periph.io/x/conn/v3 v3.7.3
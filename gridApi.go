@@ -0,0 +1,324 @@
+package ledgrid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// The GridServer is controlled via a JSON REST API on the RPC port (see
+// DefRPCPort). All requests and responses use JSON (Content-Type
+// application/json). Errors are reported with a HTTP status code other than
+// 200 and an object of the form {"error": "message"}.
+//
+//	GET    /api/numleds       {"numLeds": 400}
+//	GET    /api/config        the module configuration as in the files of
+//	                          package conf, e.g.
+//	                          [{"Col": 0, "Row": 0, "Mod": "LR:0"}, ...]
+//	GET    /api/gamma         {"red": 2.5, "green": 2.5, "blue": 2.5}
+//	PUT    /api/gamma         same as GET; sets new gamma values
+//	GET    /api/maxvalue      {"red": 255, "green": 255, "blue": 255}
+//	PUT    /api/maxvalue      same as GET; sets the brightness cap
+//	GET    /api/pixels        the status database as in conf.LedStatusDB
+//	                          {"Leds": [{"Col": 1, "Row": 0, "Idx": 17,
+//	                            "Status": "defect"}, ...]}
+//	PUT    /api/pixels        same as GET; replaces the status database
+//	PUT    /api/pixels/{idx}  {"status": "ok"|"defect"|"missing"}; sets the
+//	                          status of the LED with index idx on the chain
+//	                          and stores it in the status database
+//	POST   /api/testpattern   toggles the test pattern; returns
+//	                          {"testPattern": true|false}
+//	GET    /api/stats         {"recvBytes": 1200, "sentBytes": 1200,
+//	                           "compressionRatio": 1.0,
+//	                           "stopwatch": {"num": 1, "total": 1234,
+//	                            "avg": 1234, "min": 1234, "max": 1234}}
+//	                          durations are given in nanoseconds; also
+//	                          contains "power" (see /api/power)
+//	DELETE /api/stats         resets the statistics
+//	GET    /api/power         estimated current of the last frame in mA
+//	                          {"requested": 5200, "drawn": 4000,
+//	                           "budget": 4000, "segments": [...]}
+//	GET    /api/power/config  configuration of the power limiter
+//	                          {"channelCurrent": [20, 20, 20, 20],
+//	                           "idleCurrent": 1, "budget": 4000,
+//	                           "segments": [{"module": 0,
+//	                            "numModules": 4, "budget": 2000}, ...]}
+//	PUT    /api/power/config  same as GET; sets a new configuration
+//	GET    /api/calibration   the color calibration as in the files of
+//	                          package conf, e.g.
+//	                          {"Modules": [{"Col": 1, "Row": 0,
+//	                            "Gain": [1.0, 0.9, 0.8]}], "Leds": [...]}
+//	PUT    /api/calibration   same as GET; sets a new calibration
+//	GET    /api/encodings     {"encodings": ["raw", "rle", "delta"]}
+//	GET    /api/sources       the list of all frame sources (see arbiter.go)
+//	                          [{"name": "tcp:10.0.0.2:50123",
+//	                            "priority": 100, "active": true,
+//	                            "shown": true}, ...]
+const (
+	apiPrefix = "/api"
+)
+
+type NumLedsArg struct {
+	NumLeds int `json:"numLeds"`
+}
+
+type GammaArg struct {
+	RedVal   float64 `json:"red"`
+	GreenVal float64 `json:"green"`
+	BlueVal  float64 `json:"blue"`
+}
+
+type MaxValueArg struct {
+	RedVal   uint8 `json:"red"`
+	GreenVal uint8 `json:"green"`
+	BlueVal  uint8 `json:"blue"`
+}
+
+type PixelStatusArg struct {
+	Status LedStatusType `json:"status"`
+}
+
+type TestPatternArg struct {
+	TestPattern bool `json:"testPattern"`
+}
+
+type StopwatchArg struct {
+	Num   int           `json:"num"`
+	Total time.Duration `json:"total"`
+	Avg   time.Duration `json:"avg"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+}
+
+type StatsArg struct {
+	RecvBytes        ByteCount    `json:"recvBytes"`
+	SentBytes        ByteCount    `json:"sentBytes"`
+	CompressionRatio float64      `json:"compressionRatio"`
+	Stopwatch        StopwatchArg `json:"stopwatch"`
+	Power            PowerStats   `json:"power"`
+}
+
+type FrameEncodingsArg struct {
+	Encodings []FrameEncoding `json:"encodings"`
+}
+
+type errorArg struct {
+	Error string `json:"error"`
+}
+
+// Creates the handler with all routes of the REST API.
+func (p *GridServer) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+apiPrefix+"/numleds", p.apiNumLeds)
+	mux.HandleFunc("GET "+apiPrefix+"/config", p.apiModuleConfig)
+	mux.HandleFunc("GET "+apiPrefix+"/gamma", p.apiGamma)
+	mux.HandleFunc("PUT "+apiPrefix+"/gamma", p.apiSetGamma)
+	mux.HandleFunc("GET "+apiPrefix+"/maxvalue", p.apiMaxValue)
+	mux.HandleFunc("PUT "+apiPrefix+"/maxvalue", p.apiSetMaxValue)
+	mux.HandleFunc("GET "+apiPrefix+"/pixels", p.apiLedStatus)
+	mux.HandleFunc("PUT "+apiPrefix+"/pixels", p.apiSetLedStatus)
+	mux.HandleFunc("PUT "+apiPrefix+"/pixels/{idx}", p.apiSetPixelStatus)
+	mux.HandleFunc("POST "+apiPrefix+"/testpattern", p.apiToggleTestPattern)
+	mux.HandleFunc("GET "+apiPrefix+"/stats", p.apiStats)
+	mux.HandleFunc("DELETE "+apiPrefix+"/stats", p.apiResetStats)
+	mux.HandleFunc("GET "+apiPrefix+"/power", p.apiPower)
+	mux.HandleFunc("GET "+apiPrefix+"/power/config", p.apiPowerConfig)
+	mux.HandleFunc("PUT "+apiPrefix+"/power/config", p.apiSetPowerConfig)
+	mux.HandleFunc("GET "+apiPrefix+"/calibration", p.apiCalibration)
+	mux.HandleFunc("PUT "+apiPrefix+"/calibration", p.apiSetCalibration)
+	mux.HandleFunc("GET "+apiPrefix+"/encodings", p.apiFrameEncodings)
+	mux.HandleFunc("GET "+apiPrefix+"/sources", p.apiSources)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorArg{Error: err.Error()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func (p *GridServer) apiNumLeds(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, NumLedsArg{p.Disp.NumLeds()})
+}
+
+func (p *GridServer) apiModuleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.ModuleConfig())
+}
+
+func (p *GridServer) apiGamma(w http.ResponseWriter, r *http.Request) {
+	var arg GammaArg
+
+	arg.RedVal, arg.GreenVal, arg.BlueVal = p.Gamma()
+	writeJSON(w, http.StatusOK, arg)
+}
+
+func (p *GridServer) apiSetGamma(w http.ResponseWriter, r *http.Request) {
+	var arg GammaArg
+
+	if !readJSON(w, r, &arg) {
+		return
+	}
+	if arg.RedVal <= 0.0 || arg.GreenVal <= 0.0 || arg.BlueVal <= 0.0 {
+		writeError(w, http.StatusBadRequest, errors.New("gamma values must be positive"))
+		return
+	}
+	p.SetGamma(arg.RedVal, arg.GreenVal, arg.BlueVal)
+	p.apiGamma(w, r)
+}
+
+func (p *GridServer) apiMaxValue(w http.ResponseWriter, r *http.Request) {
+	var arg MaxValueArg
+
+	arg.RedVal, arg.GreenVal, arg.BlueVal = p.MaxValue()
+	writeJSON(w, http.StatusOK, arg)
+}
+
+func (p *GridServer) apiSetMaxValue(w http.ResponseWriter, r *http.Request) {
+	var arg MaxValueArg
+
+	if !readJSON(w, r, &arg) {
+		return
+	}
+	p.SetMaxValue(arg.RedVal, arg.GreenVal, arg.BlueVal)
+	p.apiMaxValue(w, r)
+}
+
+func (p *GridServer) apiSetPixelStatus(w http.ResponseWriter, r *http.Request) {
+	var arg PixelStatusArg
+
+	idx, err := strconv.Atoi(r.PathValue("idx"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if idx < 0 || idx >= p.Disp.NumLeds() {
+		writeError(w, http.StatusNotFound,
+			fmt.Errorf("no LED with index %d", idx))
+		return
+	}
+	if !readJSON(w, r, &arg) {
+		return
+	}
+	if err := p.SetPixelStatus(idx, arg.Status); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, arg)
+}
+
+func (p *GridServer) apiLedStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.LedStatus())
+}
+
+func (p *GridServer) apiSetLedStatus(w http.ResponseWriter, r *http.Request) {
+	var db conf.LedStatusDB
+
+	if !readJSON(w, r, &db) {
+		return
+	}
+	if err := p.SetLedStatus(db); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, conf.ErrBadConfig) {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, err)
+		return
+	}
+	p.apiLedStatus(w, r)
+}
+
+func (p *GridServer) apiToggleTestPattern(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TestPatternArg{p.ToggleTestPattern()})
+}
+
+func (p *GridServer) apiStats(w http.ResponseWriter, r *http.Request) {
+	var stats StatsArg
+
+	// Counters and stopwatch are updated by every source, so they are read
+	// together with the mutex of the arbiter held.
+	p.withDisplay(func() {
+		s := p.stopwatch
+		stats = StatsArg{
+			RecvBytes:        p.recvBytes,
+			SentBytes:        p.sentBytes,
+			CompressionRatio: p.compressionRatio(),
+			Stopwatch: StopwatchArg{Num: s.Num, Total: s.Total, Avg: s.Avg(),
+				Min: s.Min, Max: s.Max},
+		}
+	})
+	stats.Power = p.PowerStats()
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (p *GridServer) apiResetStats(w http.ResponseWriter, r *http.Request) {
+	p.ResetStatistics()
+	p.apiStats(w, r)
+}
+
+func (p *GridServer) apiPower(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.PowerStats())
+}
+
+func (p *GridServer) apiPowerConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.PowerConfig())
+}
+
+func (p *GridServer) apiSetPowerConfig(w http.ResponseWriter, r *http.Request) {
+	var arg PowerConfig
+
+	if !readJSON(w, r, &arg) {
+		return
+	}
+	if err := p.SetPowerConfig(arg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.apiPowerConfig(w, r)
+}
+
+func (p *GridServer) apiCalibration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.Calibration())
+}
+
+func (p *GridServer) apiSetCalibration(w http.ResponseWriter, r *http.Request) {
+	var arg conf.Calibration
+
+	if !readJSON(w, r, &arg) {
+		return
+	}
+	if err := p.SetCalibration(arg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.apiCalibration(w, r)
+}
+
+func (p *GridServer) apiFrameEncodings(w http.ResponseWriter, r *http.Request) {
+	var arg FrameEncodingsArg
+
+	for enc := range NumEncodings {
+		arg.Encodings = append(arg.Encodings, enc)
+	}
+	writeJSON(w, http.StatusOK, arg)
+}
+
+func (p *GridServer) apiSources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.Sources())
+}
@@ -0,0 +1,163 @@
+package ledgrid
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+func newTestAPIClient(t *testing.T) (*GridServer, *NetGridClient) {
+	disp := newTestDisplayer(testModConf(image.Point{40, 20}))
+	server := newTestServer(disp)
+	ts := httptest.NewServer(server.apiHandler())
+	t.Cleanup(ts.Close)
+	client := &NetGridClient{apiURL: ts.URL + apiPrefix,
+		httpClient: ts.Client(), version: ProtoVersion}
+	if err := client.queryServer(); err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestAPIClient(t *testing.T) {
+	server, client := newTestAPIClient(t)
+
+	if n := client.NumLeds(); n != 800 {
+		t.Errorf("NumLeds: expected 800, got %d", n)
+	}
+	client.SetGamma(2.0, 2.2, 2.4)
+	if r, g, b := client.Gamma(); r != 2.0 || g != 2.2 || b != 2.4 {
+		t.Errorf("Gamma: got %v, %v, %v", r, g, b)
+	}
+	modConf := client.ModuleConfig()
+	if !slices.Equal(modConf, server.ModuleConfig()) {
+		t.Errorf("ModuleConfig differs:\n%v\n%v", modConf, server.ModuleConfig())
+	}
+	if err := client.SetEncoding(EncDelta, 10); err != nil {
+		t.Errorf("SetEncoding: %v", err)
+	}
+	cfg := DefaultPowerConfig()
+	cfg.Budget = 2000
+	if err := client.apiCall(http.MethodPut, "/power/config", cfg, nil); err != nil ||
+		server.PowerConfig().Budget != 2000 {
+		t.Errorf("SetPowerConfig: %v", err)
+	}
+	if stats, err := client.PowerStats(); err != nil || stats.Budget != 2000 {
+		t.Errorf("PowerStats: %+v, %v", stats, err)
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	server, client := newTestAPIClient(t)
+
+	var maxVal MaxValueArg
+	if err := client.apiCall(http.MethodPut, "/maxvalue",
+		MaxValueArg{200, 150, 100}, &maxVal); err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b := server.MaxValue(); r != 200 || g != 150 || b != 100 ||
+		maxVal != (MaxValueArg{200, 150, 100}) {
+		t.Errorf("MaxValue: got %d, %d, %d (%v)", r, g, b, maxVal)
+	}
+
+	if err := client.apiCall(http.MethodPut, "/pixels/17",
+		PixelStatusArg{LedDefect}, nil); err != nil {
+		t.Error(err)
+	}
+	disp := server.Disp.(*testDisplayer)
+	if disp.statusList[17] != LedDefect {
+		t.Errorf("pixel 17: expected %v, got %v", LedDefect, disp.statusList[17])
+	}
+	if err := client.apiCall(http.MethodPut, "/pixels/800",
+		PixelStatusArg{LedOK}, nil); err == nil {
+		t.Error("expected error for pixel 800")
+	}
+	if err := client.apiCall(http.MethodPut, "/gamma",
+		GammaArg{0.0, 1.0, 1.0}, nil); err == nil {
+		t.Error("expected error for gamma value 0")
+	}
+
+	var stats StatsArg
+	server.recvBytes, server.sentBytes = 100, 200
+	if err := client.apiCall(http.MethodDelete, "/stats", nil, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.RecvBytes != 0 || stats.SentBytes != 0 {
+		t.Errorf("stats not reset: %+v", stats)
+	}
+}
+
+// Der Status wird in der Datenbank unter Modul und Index im Modul
+// gespeichert.
+func TestAPILedStatus(t *testing.T) {
+	server, client := newTestAPIClient(t)
+	fileName := filepath.Join(t.TempDir(), "status.json")
+	if err := server.LoadLedStatus(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	modSize := conf.ModuleDim.X * conf.ModuleDim.Y
+	mod := server.ModuleConfig()[1]
+	if err := client.SetPixelStatus(modSize+17, LedDefect); err != nil {
+		t.Fatal(err)
+	}
+	db, err := conf.LoadLedStatus(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := db.Get(mod, 17); stat != LedDefect || len(db.Leds) != 1 {
+		t.Errorf("unexpected database: %+v", db)
+	}
+	disp := server.Disp.(*testDisplayer)
+	if disp.statusList[modSize+17] != LedDefect {
+		t.Errorf("status not applied")
+	}
+
+	db.Set(mod, 17, LedOK)
+	db.Set(mod, 3, LedMissing)
+	if err := client.SetLedStatus(db); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.LedStatus(); err != nil || len(reply.Leds) != 1 ||
+		reply.Get(mod, 3) != LedMissing {
+		t.Errorf("LedStatus: got %+v, %v", reply, err)
+	}
+	if disp.statusList[modSize+17] != LedOK || disp.statusList[modSize+3] != LedMissing {
+		t.Errorf("status not applied")
+	}
+	db.Leds = append(db.Leds, db.Leds[0])
+	if err := client.SetLedStatus(db); err == nil {
+		t.Error("expected error for duplicate entry")
+	}
+}
+
+func TestAPIJSON(t *testing.T) {
+	_, client := newTestAPIClient(t)
+
+	resp, err := client.httpClient.Get(client.apiURL + "/encodings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if got := buf.String(); got != `{"encodings":["raw","rle","delta"]}`+"\n" {
+		t.Errorf("unexpected response: %s", got)
+	}
+
+	var arg PixelStatusArg
+	if err := json.Unmarshal([]byte(`{"status": "missing"}`), &arg); err != nil ||
+		arg.Status != LedMissing {
+		t.Errorf("PixelStatusArg: got %v, %v", arg, err)
+	}
+	if err := json.Unmarshal([]byte(`{"status": "broken"}`), &arg); err == nil {
+		t.Error("expected error for unknown status")
+	}
+}
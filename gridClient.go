@@ -2,7 +2,7 @@
 // GridClient als Interface definiert. Aktuell stehen zwei Implementationen
 // am Start:
 //
-//	  NetGridClient  - Verbindet sich via TCP und RPC mit einem externen
+//	  NetGridClient  - Verbindet sich via TCP/UDP und REST mit einem externen
 //			              gridController.
 //		 FileSaveClient - Schreibt die Bilddaten in ein File, welches dann auf das
 //		                  System mit dem Grid-Controller kopiert und dort direkt
@@ -10,18 +10,27 @@
 package ledgrid
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"image"
+	"io"
 	"log"
 	"net"
-	"net/rpc"
+	"net/http"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/stefan-muehlebach/ledgrid/conf"
 )
 
+// Send returns an error (wrapping ErrSend) if the data couldn't be
+// delivered. The NetGridClient reconnects automatically after a lost
+// connection and returns an error only if this is no longer possible.
 type GridClient interface {
-	Send(buffer []byte)
+	Send(buffer []byte) error
 	NumLeds() int
 	Gamma() (r, g, b float64)
 	SetGamma(r, g, b float64)
@@ -31,117 +40,615 @@ type GridClient interface {
 }
 
 // Mit diesem Typ wird die klassische Verwendung auf zwei Nodes realisiert.
+// Die Bilddaten werden entweder via TCP oder via UDP uebertragen. Bei UDP
+// werden verlorene oder verspaetete Bilder vom Server verworfen, was auf
+// unzuverlaessigen Verbindungen (WLAN) zu einer geringeren Latenz fuehrt.
+//
+// Bricht die Verbindung ab (z.B. weil der gridController neu gestartet
+// wird), versucht der Client im Hintergrund, die Verbindung wieder
+// aufzubauen. Die Wartezeit zwischen den Versuchen wird dabei jeweils
+// verdoppelt (siehe SetBackoff). Waehrend der Client offline ist, kehrt Send
+// sofort zurueck und je nach OfflineMode wird das letzte Bild aufbewahrt
+// und nach dem Wiederaufbau der Verbindung gesendet oder alle Bilder
+// werden verworfen.
 type NetGridClient struct {
 	conn        net.Conn
-	rpcDisabled bool
-	rpcClient   *rpc.Client
+	addr        string
+	network     string
+	version     uint8
+	seq         uint32
+	encoder     *FrameEncoder
+	apiURL      string
+	httpClient  *http.Client
+	numLeds     int
+	modConf     conf.ModuleConfig
+	gamma       GammaArg
 	stopwatch   *Stopwatch
+	mutex       sync.Mutex
+	state       ConnState
+	stateErr    error
+	stateFuncs  []func(state ConnState, err error)
+	offlineMode OfflineMode
+	pending     []byte
+	prio        Priority
+	prioSet     bool
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	done        chan struct{}
 }
 
-func NewNetGridClient(host string, port, rpcPort uint) GridClient {
-	var hostPortData, hostPortRPC string
+// Beschreibt den Zustand der Verbindung eines NetGridClient.
+type ConnState int
+
+const (
+	// Die Verbindung zum Server steht.
+	ConnOnline ConnState = iota
+	// Die Verbindung ist unterbrochen, es wird versucht, sie wieder
+	// aufzubauen.
+	ConnOffline
+	// Die Verbindung konnte zwar wieder aufgebaut werden, der Server hat
+	// jedoch eine andere Modul-Konfiguration. Der Client ist damit nicht
+	// mehr verwendbar.
+	ConnFailed
+	// Der Client wurde mit Close geschlossen.
+	ConnClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnOnline:
+		return "online"
+	case ConnOffline:
+		return "offline"
+	case ConnFailed:
+		return "failed"
+	case ConnClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// Legt fest, was mit den Bildern geschieht, welche waehrend einer
+// unterbrochenen Verbindung gesendet werden.
+type OfflineMode int
+
+const (
+	// Das jeweils letzte Bild wird aufbewahrt und sofort nach dem
+	// Wiederaufbau der Verbindung gesendet.
+	OfflineKeepLatest OfflineMode = iota
+	// Alle Bilder werden verworfen.
+	OfflineDrop
+)
+
+// Defaultwerte fuer die Wartezeiten zwischen den Verbindungsversuchen.
+const (
+	DefMinBackoff = 100 * time.Millisecond
+	DefMaxBackoff = 10 * time.Second
+)
+
+// Maximale Dauer eines Aufrufs der REST-API. Antwortet der Server nicht
+// innerhalb dieser Zeit, wird ein Fehler mit ErrAPI retourniert.
+const DefAPITimeout = 5 * time.Second
+
+// Erstellt einen neuen Client. Mit network wird das Protokoll fuer die
+// Bilddaten gewaehlt ("tcp" oder "udp"). Kann keine Verbindung zum Server
+// aufgebaut werden, wird ein Fehler retourniert, welcher ErrConnect (resp.
+// ErrNetwork oder ErrAPI) enthaelt.
+func NewNetGridClient(host, network string, port, rpcPort uint) (GridClient, error) {
+	var hostPortData string
 	var err error
 
 	p := &NetGridClient{}
 
-	hostPortData = fmt.Sprintf("%s:%d", host, port)
-	p.conn, err = net.Dial("tcp", hostPortData)
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrNetwork, network)
+	}
+	hostPortData = net.JoinHostPort(host, fmt.Sprint(port))
+	p.addr = hostPortData
+	p.network = network
+	p.conn, err = p.dial()
 	if err != nil {
-		log.Fatalf("Error in Dial(dataPort): %v", err)
+		return nil, err
 	}
-
-	hostPortRPC = fmt.Sprintf("%s:%d", host, rpcPort)
+	p.encoder = NewFrameEncoder(EncRaw, 1)
+	p.minBackoff, p.maxBackoff = DefMinBackoff, DefMaxBackoff
+	p.done = make(chan struct{})
+
+	// Ist rpcPort gleich 0, wird auf die REST-API verzichtet und fuer
+	// Anzahl LEDs, Gamma-Werte, etc. werden Defaultwerte verwendet. Die
+	// Anzahl LEDs und die Modul-Konfiguration aendern sich waehrend der
+	// Laufzeit des Servers nicht und werden darum nur einmal abgefragt.
+	p.numLeds = 400
+	p.modConf, _ = conf.DefaultModuleConfig(image.Point{40, 10})
+	p.gamma = GammaArg{2.5, 2.5, 2.5}
 	if rpcPort != 0 {
-		p.rpcClient, err = rpc.DialHTTP("tcp", hostPortRPC)
-		if err != nil {
-			log.Fatalf("Error in Dial(rpcPort): %v", err)
+		p.apiURL = fmt.Sprintf("http://%s%s",
+			net.JoinHostPort(host, fmt.Sprint(rpcPort)), apiPrefix)
+		p.httpClient = &http.Client{Timeout: DefAPITimeout}
+		if err = p.queryServer(); err != nil {
+			p.conn.Close()
+			return nil, err
 		}
 	}
 
 	p.stopwatch = NewStopwatch()
+	p.watch(p.conn)
 
-	return p
+	return p, nil
 }
 
-// Sendet die Bilddaten in der LedGrid-Struktur zum Controller.
-func (p *NetGridClient) Send(buffer []byte) {
+// Oeffnet eine neue Verbindung zum Server und handelt bei TCP die
+// Protokoll-Version aus.
+func (p *NetGridClient) dial() (net.Conn, error) {
+	conn, err := net.Dial(p.network, p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	if p.network[:3] == "tcp" {
+		if err = p.handshake(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: handshake with %s: %w", ErrConnect,
+				p.addr, err)
+		}
+	} else {
+		p.version = ProtoVersion
+	}
+	return conn, nil
+}
+
+// Fragt die unveraenderlichen Eigenschaften des Servers ab.
+func (p *NetGridClient) queryServer() error {
+	var numLeds NumLedsArg
+
+	if err := p.apiCall(http.MethodGet, "/numleds", nil, &numLeds); err != nil {
+		return err
+	}
+	if err := p.apiCall(http.MethodGet, "/config", nil, &p.modConf); err != nil {
+		return err
+	}
+	p.numLeds = numLeds.NumLeds
+	return p.apiCall(http.MethodGet, "/gamma", nil, &p.gamma)
+}
+
+// Zu Beginn wird mit dem Server die Protokoll-Version ausgehandelt. Der
+// Client schlaegt die hoechste, ihm bekannte Version vor, der Server
+// antwortet mit der effektiv zu verwendenden.
+func (p *NetGridClient) handshake(conn net.Conn) error {
+	var hdr ProtoHeader
 	var err error
 
-	p.stopwatch.Start()
-	_, err = p.conn.Write(buffer)
+	err = WriteMessage(conn, ProtoHeader{Version: ProtoVersion,
+		Command: CmdHello, Seq: p.seq}, nil)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	p.stopwatch.Stop()
+	hdr, _, err = ReadMessage(conn, nil)
+	if err != nil {
+		return err
+	}
+	if hdr.Command != CmdHello {
+		return fmt.Errorf("expected %v, got %v", CmdHello, hdr.Command)
+	}
+	if hdr.Version == 0 || hdr.Version > ProtoVersion {
+		return ErrBadVersion
+	}
+	p.version = hdr.Version
+	return nil
 }
 
-// Die folgenden Methoden verpacken die entsprechenden RPC-Calls zum
-// Grid-Server.
-func (p *NetGridClient) NumLeds() int {
-	var reply NumLedsArg
+// Waehlt die Kodierung der Bilddaten (siehe encoding.go). Mit keyInterval
+// wird bei EncDelta festgelegt, nach wie vielen Bildern wieder ein
+// vollstaendiges Bild gesendet wird; bei UDP sollte dieser Wert klein sein,
+// da nach einem verlorenen Bild bis zum naechsten vollstaendigen Bild nichts
+// mehr angezeigt wird. Ob der Server die Kodierung unterstuetzt, wird ueber
+// die REST-API abgefragt.
+func (p *NetGridClient) SetEncoding(enc FrameEncoding, keyInterval int) error {
+	var reply FrameEncodingsArg
 	var err error
 
-	if p.rpcClient == nil {
-		return 400
+	if enc != EncRaw {
+		if p.version < 2 {
+			return fmt.Errorf("%w: server speaks protocol version %d only",
+				ErrBadEncoding, p.version)
+		}
+		if p.httpClient == nil {
+			return fmt.Errorf("%w: can't negotiate without REST API", ErrBadEncoding)
+		}
+		err = p.apiCall(http.MethodGet, "/encodings", nil, &reply)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(reply.Encodings, enc) {
+			return fmt.Errorf("%w: '%v' not supported by server", ErrBadEncoding, enc)
+		}
 	}
-	err = p.rpcClient.Call("GridServer.RPCNumLeds", 0, &reply)
-	if err != nil {
-		log.Fatal("NumLeds error:", err)
+	p.mutex.Lock()
+	p.encoder = NewFrameEncoder(enc, keyInterval)
+	p.mutex.Unlock()
+	return nil
+}
+
+// Teilt dem Server die Prioritaet dieses Clients mit (siehe arbiter.go).
+// Sind mehrere Clients mit dem Server verbunden, werden nur die Bilder
+// desjenigen mit der hoechsten Prioritaet angezeigt. Ohne Aufruf dieser
+// Methode gilt DefPriority. Die Prioritaet wird auch nach einem Wiederaufbau
+// der Verbindung wieder gesetzt.
+func (p *NetGridClient) SetPriority(prio Priority) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prio, p.prioSet = prio, true
+	if p.state != ConnOnline {
+		return nil
 	}
-	return int(reply)
+	return p.sendPriority()
 }
 
-func (p *NetGridClient) Gamma() (r, g, b float64) {
-	var reply GammaArg
+func (p *NetGridClient) sendPriority() error {
+	return WriteMessage(p.conn, ProtoHeader{Version: p.version,
+		Command: CmdHello, Seq: p.seq}, []byte{byte(p.prio)})
+}
+
+// Sendet die Bilddaten in der LedGrid-Struktur zum Controller. Ist die
+// Verbindung unterbrochen, wird das Bild gemaess OfflineMode aufbewahrt
+// oder verworfen; ein Fehler wird nur dann retourniert, wenn der Client
+// geschlossen wurde oder die Verbindung nicht mehr aufgebaut werden kann.
+func (p *NetGridClient) Send(buffer []byte) error {
 	var err error
 
-	if p.rpcClient == nil {
-		return 2.5, 2.5, 2.5
+	p.mutex.Lock()
+	switch p.state {
+	case ConnOnline:
+		p.stopwatch.Start()
+		err = p.sendFrame(buffer)
+		p.stopwatch.Stop()
+		if err == nil {
+			p.mutex.Unlock()
+			return nil
+		}
+	case ConnOffline:
+	default:
+		err = p.stateErr
+		p.mutex.Unlock()
+		return fmt.Errorf("%w: %w", ErrSend, err)
 	}
-	err = p.rpcClient.Call("GridServer.RPCGamma", 0, &reply)
+	if p.offlineMode == OfflineKeepLatest {
+		p.pending = append(p.pending[:0], buffer...)
+	}
+	conn := p.conn
+	p.mutex.Unlock()
 	if err != nil {
-		log.Fatal("Gamma error:", err)
+		p.lost(conn, err)
 	}
-	return reply.RedVal, reply.GreenVal, reply.BlueVal
+	return nil
 }
 
-func (p *NetGridClient) SetGamma(r, g, b float64) {
-	var reply int
-	var err error
+// Sendet ein einzelnes Bild, muss mit gesperrtem Mutex aufgerufen werden.
+func (p *NetGridClient) sendFrame(buffer []byte) error {
+	var payload []byte
+
+	p.seq++
+	payload = buffer
+	if p.version >= 2 {
+		payload = p.encoder.Encode(buffer)
+	}
+	if p.network[:3] == "udp" {
+		return WriteFragments(payload, p.version, p.seq, func(b []byte) error {
+			_, err := p.conn.Write(b)
+			return err
+		})
+	}
+	return WriteMessage(p.conn, ProtoHeader{Version: p.version,
+		Command: CmdFrame, Seq: p.seq}, payload)
+}
+
+// Registriert eine Funktion, welche bei jeder Aenderung des
+// Verbindungszustandes aufgerufen wird. Bei ConnOffline und ConnFailed
+// enthaelt err den Grund. Die Funktionen werden aus einer separaten
+// Goroutine heraus aufgerufen und sollten nicht blockieren.
+func (p *NetGridClient) OnStateChange(fn func(state ConnState, err error)) {
+	p.mutex.Lock()
+	p.stateFuncs = append(p.stateFuncs, fn)
+	p.mutex.Unlock()
+}
 
-	if p.rpcClient == nil {
+// Liefert den aktuellen Verbindungszustand.
+func (p *NetGridClient) State() ConnState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state
+}
+
+// Legt fest, was mit Bildern waehrend einer unterbrochenen Verbindung
+// geschieht (Default: OfflineKeepLatest).
+func (p *NetGridClient) SetOfflineMode(mode OfflineMode) {
+	p.mutex.Lock()
+	p.offlineMode = mode
+	if mode == OfflineDrop {
+		p.pending = nil
+	}
+	p.mutex.Unlock()
+}
+
+// Setzt die minimale und maximale Wartezeit zwischen zwei
+// Verbindungsversuchen (Default: DefMinBackoff, DefMaxBackoff).
+func (p *NetGridClient) SetBackoff(minWait, maxWait time.Duration) {
+	p.mutex.Lock()
+	p.minBackoff, p.maxBackoff = minWait, max(minWait, maxWait)
+	p.mutex.Unlock()
+}
+
+// Setzt den Zustand und ruft die registrierten Funktionen auf. Darf nicht
+// mit gesperrtem Mutex aufgerufen werden.
+func (p *NetGridClient) setState(state ConnState, err error) {
+	p.mutex.Lock()
+	p.state, p.stateErr = state, err
+	funcs := slices.Clone(p.stateFuncs)
+	p.mutex.Unlock()
+	for _, fn := range funcs {
+		fn(state, err)
+	}
+}
+
+// Bei TCP sendet der Server nach dem Handshake keine Daten mehr. Ein
+// Lesefehler bedeutet darum immer, dass die Verbindung abgebrochen ist.
+// Dadurch wird ein Neustart des Servers auch dann erkannt, wenn gerade
+// keine Bilder gesendet werden.
+func (p *NetGridClient) watch(conn net.Conn) {
+	if p.network[:3] != "tcp" {
 		return
 	}
-	err = p.rpcClient.Call("GridServer.RPCSetGamma", GammaArg{r, g, b}, &reply)
-	if err != nil {
-		log.Fatal("SetGamma error:", err)
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		if err == nil {
+			err = io.EOF
+		}
+		p.lost(conn, err)
+	}()
+}
+
+// Wird aufgerufen, sobald ein Fehler auf der Verbindung conn festgestellt
+// wurde. Ist conn noch die aktuelle Verbindung, wird sie geschlossen und
+// der Wiederaufbau gestartet.
+func (p *NetGridClient) lost(conn net.Conn, err error) {
+	p.mutex.Lock()
+	if p.conn != conn || p.state != ConnOnline {
+		p.mutex.Unlock()
+		return
 	}
+	p.state = ConnOffline
+	p.mutex.Unlock()
+	conn.Close()
+	p.setState(ConnOffline, err)
+	go p.reconnect()
 }
 
-func (p *NetGridClient) ModuleConfig() conf.ModuleConfig {
-	var reply ModuleConfigArg
-	var err error
+// Versucht, die Verbindung mit exponentiell wachsenden Wartezeiten wieder
+// aufzubauen. Ist die REST-API verfuegbar, wird die Modul-Konfiguration
+// erneut abgefragt; hat sie sich geaendert, wird abgebrochen.
+func (p *NetGridClient) reconnect() {
+	var modConf conf.ModuleConfig
+
+	p.mutex.Lock()
+	backoff, maxBackoff := p.minBackoff, p.maxBackoff
+	p.mutex.Unlock()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+
+		conn, err := p.dial()
+		if err != nil {
+			continue
+		}
+		if p.httpClient != nil {
+			if err = p.apiCall(http.MethodGet, "/config", nil, &modConf); err != nil {
+				conn.Close()
+				continue
+			}
+			if !slices.Equal(modConf, p.modConf) {
+				conn.Close()
+				p.setState(ConnFailed, ErrGeometry)
+				return
+			}
+		}
+
+		p.mutex.Lock()
+		if p.state != ConnOffline {
+			p.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		// Der Server kennt das vorangehende Bild nicht mehr, es muss also
+		// mit einem vollstaendigen Bild begonnen werden.
+		p.conn = conn
+		p.encoder = NewFrameEncoder(p.encoder.Encoding, p.encoder.KeyInterval)
+		if p.prioSet {
+			err = p.sendPriority()
+		}
+		if err == nil && p.pending != nil {
+			err = p.sendFrame(p.pending)
+			p.pending = nil
+		}
+		p.mutex.Unlock()
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		p.watch(conn)
+		p.setState(ConnOnline, nil)
+		return
+	}
+}
 
-	if p.rpcClient == nil {
-		return conf.DefaultModuleConfig(image.Point{40, 10})
+// Fuehrt einen Aufruf der REST-API aus. Ist in nicht nil, wird der Wert als
+// JSON-Objekt mitgeschickt, die Antwort wird nach out dekodiert. Alle Fehler
+// enthalten ErrAPI, Fehler des Servers werden mit der Meldung aus der
+// Antwort retourniert.
+func (p *NetGridClient) apiCall(method, path string, in, out any) error {
+	if err := p.doAPICall(method, path, in, out); err != nil {
+		return fmt.Errorf("%w: %w", ErrAPI, err)
 	}
-	err = p.rpcClient.Call("GridServer.RPCModuleConfig", 0, &reply)
+	return nil
+}
+
+func (p *NetGridClient) doAPICall(method, path string, in, out any) error {
+	var body bytes.Buffer
+
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, p.apiURL+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		log.Fatal("ModuleConfig error:", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errArg errorArg
+		if json.NewDecoder(resp.Body).Decode(&errArg) != nil || errArg.Error == "" {
+			errArg.Error = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, errArg.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Die folgenden Methoden verpacken die entsprechenden Aufrufe der
+// REST-API des Grid-Servers. Da die Gamma-Werte auch von anderen Clients
+// veraendert werden koennen, werden sie bei jedem Aufruf abgefragt. Schlaegt
+// die Abfrage fehl, werden die zuletzt bekannten Werte retourniert.
+func (p *NetGridClient) NumLeds() int {
+	return p.numLeds
+}
+
+func (p *NetGridClient) Gamma() (r, g, b float64) {
+	var reply GammaArg
+
+	if p.httpClient != nil {
+		if err := p.apiCall(http.MethodGet, "/gamma", nil, &reply); err != nil {
+			log.Printf("Gamma: %v", err)
+		} else {
+			p.gamma = reply
+		}
+	}
+	return p.gamma.RedVal, p.gamma.GreenVal, p.gamma.BlueVal
+}
+
+func (p *NetGridClient) SetGamma(r, g, b float64) {
+	if p.httpClient == nil {
+		return
+	}
+	if err := p.apiCall(http.MethodPut, "/gamma", GammaArg{r, g, b}, &p.gamma); err != nil {
+		log.Printf("SetGamma: %v", err)
+	}
+}
+
+// Fragt den geschaetzten Strom des zuletzt angezeigten Bildes ab (siehe
+// PowerStats). Fehler enthalten ErrAPI.
+func (p *NetGridClient) PowerStats() (PowerStats, error) {
+	var reply PowerStats
+
+	if p.httpClient == nil {
+		return reply, fmt.Errorf("%w: no REST API", ErrAPI)
 	}
-	return reply.ModuleConfig
+	err := p.apiCall(http.MethodGet, "/power", nil, &reply)
+	return reply, err
+}
+
+// Fragt die Farbkalibrierung des Servers ab, resp. setzt eine neue (siehe
+// conf.Calibration). Fehler enthalten ErrAPI.
+func (p *NetGridClient) Calibration() (conf.Calibration, error) {
+	var reply conf.Calibration
+
+	if p.httpClient == nil {
+		return reply, fmt.Errorf("%w: no REST API", ErrAPI)
+	}
+	err := p.apiCall(http.MethodGet, "/calibration", nil, &reply)
+	return reply, err
+}
+
+func (p *NetGridClient) SetCalibration(cal conf.Calibration) error {
+	if p.httpClient == nil {
+		return fmt.Errorf("%w: no REST API", ErrAPI)
+	}
+	return p.apiCall(http.MethodPut, "/calibration", cal, nil)
+}
+
+// Fragt die Status-Datenbank des Servers ab, resp. ersetzt sie (siehe
+// conf.LedStatusDB). Fehler enthalten ErrAPI.
+func (p *NetGridClient) LedStatus() (conf.LedStatusDB, error) {
+	var reply conf.LedStatusDB
+
+	if p.httpClient == nil {
+		return reply, fmt.Errorf("%w: no REST API", ErrAPI)
+	}
+	err := p.apiCall(http.MethodGet, "/pixels", nil, &reply)
+	return reply, err
+}
+
+func (p *NetGridClient) SetLedStatus(db conf.LedStatusDB) error {
+	if p.httpClient == nil {
+		return fmt.Errorf("%w: no REST API", ErrAPI)
+	}
+	return p.apiCall(http.MethodPut, "/pixels", db, nil)
+}
+
+// Setzt den Status der LED mit Index idx auf der Kette.
+func (p *NetGridClient) SetPixelStatus(idx int, stat LedStatusType) error {
+	if p.httpClient == nil {
+		return fmt.Errorf("%w: no REST API", ErrAPI)
+	}
+	return p.apiCall(http.MethodPut, fmt.Sprintf("/pixels/%d", idx),
+		PixelStatusArg{Status: stat}, nil)
+}
+
+func (p *NetGridClient) ModuleConfig() conf.ModuleConfig {
+	return p.modConf
 }
 
 func (p *NetGridClient) Stopwatch() *Stopwatch {
 	return p.stopwatch
 }
 
-// Schliesst die Verbindung zum Controller.
+// Schliesst die Verbindung zum Controller und beendet einen allfaelligen
+// Wiederaufbau der Verbindung.
 func (p *NetGridClient) Close() {
+	p.mutex.Lock()
+	if p.state == ConnClosed {
+		p.mutex.Unlock()
+		return
+	}
+	if p.state == ConnOnline {
+		p.seq++
+		WriteMessage(p.conn, ProtoHeader{Version: p.version, Command: CmdBye,
+			Seq: p.seq}, nil)
+	}
+	p.state = ConnClosed
+	if p.done != nil {
+		close(p.done)
+	}
 	p.conn.Close()
+	p.mutex.Unlock()
+	p.setState(ConnClosed, net.ErrClosed)
 }
 
 func (p *NetGridClient) Address() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.conn.RemoteAddr().String()
 }
 
@@ -163,8 +670,9 @@ func NewDirectGridClient(Disp Displayer) GridClient {
 	return c
 }
 
-func (c *DirectGridClient) Send(buffer []byte) {
+func (c *DirectGridClient) Send(buffer []byte) error {
 	c.Disp.Display(buffer)
+	return nil
 }
 
 func (c *DirectGridClient) NumLeds() int {
@@ -200,29 +708,28 @@ type FileSaveClient struct {
 	stopwatch *Stopwatch
 }
 
-func NewFileSaveClient(fileName string, modConf conf.ModuleConfig) GridClient {
+func NewFileSaveClient(fileName string, modConf conf.ModuleConfig) (GridClient, error) {
 	var err error
 
 	p := &FileSaveClient{}
 
 	p.fh, err = os.Create(fileName)
 	if err != nil {
-		log.Fatalf("Couldn't create file: %v", err)
+		return nil, err
 	}
 	p.modConf = modConf
 	p.stopwatch = NewStopwatch()
 
-	return p
+	return p, nil
 }
 
-func (p *FileSaveClient) Send(buffer []byte) {
-	if _, err := p.fh.Write(buffer); err != nil {
-		log.Fatalf("Couldnt' write data to file: %v", err)
-	}
+func (p *FileSaveClient) Send(buffer []byte) error {
+	_, err := p.fh.Write(buffer)
+	return err
 }
 
 func (p *FileSaveClient) NumLeds() int {
-	return len(p.modConf) * (conf.ModuleDim.X * conf.ModuleDim.Y)
+	return p.modConf.NumLeds()
 }
 
 func (p *FileSaveClient) Gamma() (r, g, b float64) {
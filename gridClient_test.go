@@ -0,0 +1,96 @@
+package ledgrid
+
+import (
+	"errors"
+	"image"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Nimmt Verbindungen auf lsnr an und liefert die serverseitigen Enden ueber
+// den Channel, damit der Test einen Abbruch der Verbindung simulieren kann.
+func acceptConns(server *GridServer, lsnr net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := lsnr.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go server.HandleMessage(conn)
+		}
+	}()
+	return conns
+}
+
+func expectState(t *testing.T, states <-chan ConnState, state ConnState) {
+	t.Helper()
+	select {
+	case got := <-states:
+		if got != state {
+			t.Fatalf("expected state %v, got %v", state, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("state %v not reached", state)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	lsnr, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsnr.Close()
+	conns := acceptConns(server, lsnr)
+	ts := httptest.NewServer(server.apiHandler())
+	defer ts.Close()
+
+	port := uint(lsnr.Addr().(*net.TCPAddr).Port)
+	rpcPort := uint(ts.Listener.Addr().(*net.TCPAddr).Port)
+	gridClient, err := NewNetGridClient("127.0.0.1", "tcp", port, rpcPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := gridClient.(*NetGridClient)
+	client.SetBackoff(10*time.Millisecond, 50*time.Millisecond)
+	states := make(chan ConnState, 4)
+	client.OnStateChange(func(state ConnState, err error) {
+		states <- state
+	})
+	size := 3 * disp.NumLeds()
+	client.Send(testFrame(size, 1))
+	expectFrame(t, disp, testFrame(size, 1))
+
+	// Der Server bricht die Verbindung ab. Die Bilder waehrend der
+	// Unterbrechung gehen verloren, nur das letzte wird nach dem
+	// Wiederaufbau angezeigt.
+	(<-conns).Close()
+	expectState(t, states, ConnOffline)
+	expectFrame(t, disp, make([]byte, size))
+	for i := range 5 {
+		if err = client.Send(testFrame(size, byte(10+i))); err != nil {
+			t.Fatalf("Send while offline: %v", err)
+		}
+	}
+	expectState(t, states, ConnOnline)
+	expectFrame(t, disp, testFrame(size, 14))
+	client.Send(testFrame(size, 20))
+	expectFrame(t, disp, testFrame(size, 20))
+
+	// Nach einer Aenderung der Modul-Konfiguration kann der Client nicht
+	// mehr verwendet werden.
+	client.modConf = testModConf(image.Point{10, 20})
+	(<-conns).Close()
+	expectState(t, states, ConnOffline)
+	expectState(t, states, ConnFailed)
+	if err = client.Send(testFrame(size, 1)); !errors.Is(err, ErrGeometry) {
+		t.Errorf("expected ErrGeometry, got %v", err)
+	}
+	client.Close()
+	expectState(t, states, ConnClosed)
+}
@@ -1,6 +1,7 @@
 package ledgrid
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -8,15 +9,18 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
-	"net/rpc"
 	"time"
 
 	"github.com/stefan-muehlebach/ledgrid/conf"
 )
 
+// Mit DefDataPort wird der Port fuer das gerahmte Protokoll (TCP und UDP,
+// siehe protocol.go) bezeichnet, mit DefTCPPort der Port fuer alte Clients,
+// welche die Bilddaten ohne Header senden.
 const (
-	DefTCPPort = 5333
-	DefRPCPort = 5332
+	DefTCPPort  = 5333
+	DefDataPort = 5334
+	DefRPCPort  = 5332
 )
 
 // Der Datentyp ByteCount kann zum Zaehlen von Bytes verwendet werden (bspw.
@@ -39,25 +43,43 @@ func (b ByteCount) String() string {
 }
 
 // Der GridServer wird auf jenem Geraet gestartet, an dem das LedGrid via
-// SPI angeschlossen ist oder allenfalls der Emulator laeuft.
+// SPI angeschlossen ist oder allenfalls der Emulator laeuft. Die Zaehler
+// (recvBytes, sentBytes, encodedBytes und decodedBytes) sowie die Stoppuhr
+// werden von allen Quellen nachgefuehrt und sind durch den Mutex des
+// Arbiters geschuetzt.
 type GridServer struct {
 	Disp                 Displayer
-	RecvBytes, SentBytes ByteCount
+	recvBytes, sentBytes ByteCount
+	encodedBytes         ByteCount
+	decodedBytes         ByteCount
 	tcpAddr              *net.TCPAddr
 	tcpListener          *net.TCPListener
+	dataListener         *net.TCPListener
+	udpConn              *net.UDPConn
 	rpcAddr              *net.TCPAddr
 	rpcListener          *net.TCPListener
+	opcListener          *net.TCPListener
+	opcChannels          [256]*opcChannel
+	e131Recv, artNetRecv *DMXReceiver
+	mdns                 *mdnsResponder
+	arbiter              *arbiter
 	bufferSize           int
 	maxValue             [3]uint8
-	drawTestPattern      bool
+	testPattern          chan struct{}
 	stopwatch            *Stopwatch
+	ledStatus            conf.LedStatusDB
+	ledStatusFile        string
 }
 
-// Damit wird eine neue Instanz eines GridServers erzeugt. Mit dataPort wird
-// der Port sowohl fuer die UDP-, als auch fuer die TCP-Verbindung angegeben
-// mit mit rpcPort der Port fuer die RPC-Calls. Mit disp wird dem Server
-// ein konkretes, anzeigefaehiges Geraet (sog. Displayer) mitgegeben.
-func NewGridServer(tcpPort, rpcPort uint, disp Displayer) *GridServer {
+// Damit wird eine neue Instanz eines GridServers erzeugt. Mit tcpPort wird
+// der TCP-Port fuer alte Clients (Bilddaten ohne Header) angegeben, mit
+// dataPort der Port fuer das gerahmte Protokoll, sowohl fuer TCP als auch
+// fuer UDP, und mit rpcPort der Port fuer die REST-API. Mit disp wird dem
+// Server ein konkretes, anzeigefaehiges Geraet (sog. Displayer) mitgegeben.
+// Kann einer der Ports nicht geoeffnet werden, wird ein Fehler mit
+// ErrListen retourniert; bereits geoeffnete Ports werden wieder
+// geschlossen.
+func NewGridServer(tcpPort, dataPort, rpcPort uint, disp Displayer) (*GridServer, error) {
 	var err error
 	var addrPort netip.AddrPort
 
@@ -66,84 +88,230 @@ func NewGridServer(tcpPort, rpcPort uint, disp Displayer) *GridServer {
 	p.maxValue = [3]uint8{255, 255, 255}
 
 	p.stopwatch = NewStopwatch()
+	p.arbiter = newArbiter(p)
 
 	// Jetzt wird der TCP-Port geoeffnet, resp. eine lesende Verbindung
 	// dafuer erstellt und der entsprechende Handler dafuer gestartet.
 	addrPort = netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(tcpPort))
 	if !addrPort.IsValid() {
-		log.Fatalf("Invalid address or port: %v", addrPort)
+		return nil, fmt.Errorf("%w: invalid address or port: %v", ErrListen, addrPort)
 	}
 	p.tcpAddr = net.TCPAddrFromAddrPort(addrPort)
 	p.tcpListener, err = net.ListenTCP("tcp4", p.tcpAddr)
 	if err != nil {
-		log.Fatal("TCP listen error:", err)
+		return nil, fmt.Errorf("%w: %w", ErrListen, err)
 	}
 
-	// Anschliessend wird die RPC-Verbindung initiiert.
-	rpc.Register(p)
-	rpc.HandleHTTP()
+	// Das gerahmte Protokoll erhaelt einen eigenen Port: die Bilddaten der
+	// alten Clients koennen beliebige Bytes enthalten, weshalb sich die
+	// beiden Protokolle auf dem gleichen Port nicht sicher unterscheiden
+	// lassen.
+	addrPort = netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(dataPort))
+	p.dataListener, err = net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(addrPort))
+	if err != nil {
+		p.tcpListener.Close()
+		return nil, fmt.Errorf("%w: %w", ErrListen, err)
+	}
+	if err = p.listenUDP(); err != nil {
+		p.tcpListener.Close()
+		p.dataListener.Close()
+		return nil, fmt.Errorf("%w: %w", ErrListen, err)
+	}
+
+	// Anschliessend wird der Port fuer die REST-API (siehe gridApi.go)
+	// geoeffnet.
 	addrPort = netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(rpcPort))
 	p.rpcAddr = net.TCPAddrFromAddrPort(addrPort)
 	p.rpcListener, err = net.ListenTCP("tcp4", p.rpcAddr)
 	if err != nil {
-		log.Fatal("RPC listen error:", err)
+		p.tcpListener.Close()
+		p.dataListener.Close()
+		p.udpConn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrListen, err)
 	}
 
-	return p
+	return p, nil
 }
 
 func (p *GridServer) HandleEvents() {
-	go p.HandleTCP(p.tcpListener)
-	go http.Serve(p.rpcListener, nil)
+	go p.HandleTCP(p.tcpListener, p.HandleLegacy)
+	go p.HandleTCP(p.dataListener, p.HandleMessage)
+	go p.HandleUDP(p.udpConn)
+	go http.Serve(p.rpcListener, p.apiHandler())
+	if p.opcListener != nil {
+		go p.HandleOPC(p.opcListener)
+	}
+	if p.e131Recv != nil {
+		p.e131Recv.HandleEvents()
+	}
+	if p.artNetRecv != nil {
+		p.artNetRecv.HandleEvents()
+	}
+	if p.mdns != nil {
+		go p.mdns.handle()
+		p.mdns.announce(mdnsTTL)
+	}
 }
 
-// Schliesst die diversen Verbindungen.
+// Schliesst die diversen Verbindungen und beendet ein laufendes Testmuster.
 func (p *GridServer) Close() {
+	p.withDisplay(func() {
+		if p.testPattern != nil {
+			close(p.testPattern)
+			p.testPattern = nil
+		}
+	})
 	p.tcpListener.Close()
+	p.dataListener.Close()
+	p.udpConn.Close()
 	p.rpcListener.Close()
+	if p.opcListener != nil {
+		p.opcListener.Close()
+	}
+	if p.e131Recv != nil {
+		p.e131Recv.Close()
+	}
+	if p.artNetRecv != nil {
+		p.artNetRecv.Close()
+	}
+	if p.mdns != nil {
+		p.mdns.Close()
+	}
 	p.Disp.Close()
 }
 
 // Dies ist die zentrale Verarbeitungs-Funktion des GridServers. In ihr
-// wird laufend ein Datenpaket via UDP empfangen und die empfangenen Werte auf
+// werden laufend Datenpakete via TCP empfangen und die empfangenen Werte auf
 // ein Ausgabegeraet uebertragen (SPI-Bus, Emulation, etc.) Die genaue
 // Konfiguration des LED-Grids (Anordnung der Lichterketten) ist dem
-// GridServer nicht bekannt.
+// GridServer nicht bekannt. Der Client muss das gerahmte Protokoll (siehe
+// protocol.go) sprechen; alte Clients werden mit HandleLegacy bedient.
+//
+// Jede Verbindung wird als eigene Quelle beim Arbiter (siehe arbiter.go)
+// angemeldet; angezeigt werden nur die Bilder der Quelle mit der hoechsten
+// Prioritaet. Wird die Verbindung beendet, uebernimmt die naechste Quelle
+// oder das Panel wird dunkel geschaltet.
 func (p *GridServer) HandleMessage(conn net.Conn) {
-	var bufferSize int
 	var err error
 	var buffer []byte
+	var rd *bufio.Reader
+	var src *FrameSource
+
+	defer conn.Close()
+	src = p.OpenSource("tcp:"+conn.RemoteAddr().String(), DefPriority)
+	defer src.Close()
+	buffer = make([]byte, p.bufferSize+1)
+	rd = bufio.NewReaderSize(conn, p.bufferSize+1+ProtoHeaderSize)
+	err = p.handleFramed(conn, rd, buffer, src)
+	p.logClosed(conn, err)
+}
+
+// Bedient einen alten Client, welcher die Bilddaten ohne Header sendet.
+// Damit auch bei fragmentierten TCP-Segmenten immer ganze Bilder angezeigt
+// werden, wird mit io.ReadFull gelesen.
+func (p *GridServer) HandleLegacy(conn net.Conn) {
+	var err error
+	var buffer []byte
+	var src *FrameSource
 
+	defer conn.Close()
+	src = p.OpenSource("tcp:"+conn.RemoteAddr().String(), DefPriority)
+	defer src.Close()
 	buffer = make([]byte, p.bufferSize)
 	for {
-		bufferSize, err = conn.Read(buffer)
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-				break
-			}
-			log.Fatalf("Failed Read(): %v", err)
+		if _, err = io.ReadFull(conn, buffer); err != nil {
+			break
 		}
-		if bufferSize != p.bufferSize {
-			log.Fatalf("Expected %d bytes, got only %d", p.bufferSize, bufferSize)
-		}
-		p.RecvBytes += ByteCount(bufferSize)
-		p.stopwatch.Start()
-		p.Disp.Display(buffer)
-		p.SentBytes += ByteCount(bufferSize)
-		p.stopwatch.Stop()
+		p.countRecv(len(buffer))
+		src.Display(buffer)
 	}
+	p.logClosed(conn, err)
+}
 
-	// Vor dem Beenden des Programms werden alle LEDs Schwarz geschaltet
-	// damit das Panel dunkel wird.
-	for i := range buffer {
-		buffer[i] = 0x00
+func (p *GridServer) logClosed(conn net.Conn, err error) {
+	if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+		log.Printf("Connection from %v closed: %v", conn.RemoteAddr(), err)
 	}
-	p.Disp.Send(buffer)
-	p.SentBytes += ByteCount(len(buffer))
 }
 
-// Damit werden Meldungen via TCP empfangen und verarbeitet.
-func (p *GridServer) HandleTCP(lsnr *net.TCPListener) {
+// Verarbeitet die Meldungen eines Clients, welcher das gerahmte Protokoll
+// verwendet. Als erstes muss ein CmdHello gesendet werden, mit welchem die
+// Protokoll-Version ausgehandelt wird. Die Prioritaet der Quelle kann im
+// CmdHello mitgeschickt und spaeter mit weiteren CmdHello angepasst werden.
+func (p *GridServer) handleFramed(conn net.Conn, rd *bufio.Reader, buffer []byte, src *FrameSource) error {
+	var hdr ProtoHeader
+	var payload []byte
+	var version uint8
+	var seq uint32
+	var decoder *FrameDecoder
+	var err error
+
+	hdr, payload, err = ReadMessage(rd, nil)
+	if err != nil {
+		return err
+	}
+	if hdr.Command != CmdHello {
+		return fmt.Errorf("expected %v, got %v", CmdHello, hdr.Command)
+	}
+	if hdr.Version == 0 {
+		return ErrBadVersion
+	}
+	version = min(hdr.Version, ProtoVersion)
+	err = WriteMessage(conn, ProtoHeader{Version: version, Command: CmdHello,
+		Seq: hdr.Seq}, nil)
+	if err != nil {
+		return err
+	}
+	seq = hdr.Seq
+	if len(payload) > 0 {
+		src.SetPriority(Priority(payload[0]))
+	}
+
+	decoder = NewFrameDecoder(p.bufferSize)
+	for {
+		hdr, payload, err = ReadMessage(rd, buffer)
+		if err != nil {
+			return err
+		}
+		if hdr.Version != version {
+			return ErrBadVersion
+		}
+		switch hdr.Command {
+		case CmdFrame:
+			if int32(hdr.Seq-seq) <= 0 {
+				// Veraltete oder doppelte Pakete werden verworfen.
+				continue
+			}
+			seq = hdr.Seq
+			p.countRecv(ProtoHeaderSize + len(payload))
+			encSize := len(payload)
+			if version >= 2 {
+				if payload, err = decoder.Decode(payload); err != nil {
+					return err
+				}
+			}
+			if len(payload) != p.bufferSize {
+				return fmt.Errorf("%w: expected %d bytes, got %d",
+					ErrBadLength, p.bufferSize, len(payload))
+			}
+			p.countDecoded(encSize, len(payload))
+			src.Display(payload)
+		case CmdHello:
+			if len(payload) > 0 {
+				src.SetPriority(Priority(payload[0]))
+			}
+		case CmdBye:
+			return nil
+		default:
+			log.Printf("Ignoring unknown command %v", hdr.Command)
+		}
+	}
+}
+
+// Damit werden Verbindungen via TCP angenommen und jeweils in einer
+// eigenen Goroutine mit handle verarbeitet (HandleMessage oder
+// HandleLegacy).
+func (p *GridServer) HandleTCP(lsnr *net.TCPListener, handle func(net.Conn)) {
 	var conn net.Conn
 	var err error
 
@@ -153,32 +321,226 @@ func (p *GridServer) HandleTCP(lsnr *net.TCPListener) {
 			if errors.Is(err, net.ErrClosed) {
 				break
 			}
-			log.Fatalf("Failed TCP Accept(): %v", err)
+			log.Printf("Failed TCP Accept(): %v", err)
+			continue
 		}
-		go p.HandleMessage(conn)
+		go handle(conn)
+	}
+}
+
+// Liefert das Verhaeltnis zwischen den dekodierten und den kodierten Bytes
+// der Bilder, welche mit dem gerahmten Protokoll (TCP und UDP) empfangen
+// wurden. Header, alte Clients, OPC und DMX werden nicht beruecksichtigt.
+// Werte groesser als 1 zeigen, wie viel Bandbreite durch die Kodierung der
+// Bilder eingespart wird.
+func (p *GridServer) CompressionRatio() (ratio float64) {
+	p.withDisplay(func() { ratio = p.compressionRatio() })
+	return ratio
+}
+
+// Muss via withDisplay aufgerufen werden.
+func (p *GridServer) compressionRatio() float64 {
+	if p.encodedBytes == 0 {
+		return 0.0
 	}
+	return float64(p.decodedBytes) / float64(p.encodedBytes)
 }
 
+// Zaehlt ein Bild des gerahmten Protokolls mit enc kodierten und dec
+// dekodierten Bytes. Kann von allen Goroutinen aufgerufen werden.
+func (p *GridServer) countDecoded(enc, dec int) {
+	p.withDisplay(func() {
+		p.encodedBytes += ByteCount(enc)
+		p.decodedBytes += ByteCount(dec)
+	})
+}
+
+// Zaehlt n via Netzwerk empfangene Bytes. Kann von allen Goroutinen
+// aufgerufen werden.
+func (p *GridServer) countRecv(n int) {
+	p.withDisplay(func() { p.recvBytes += ByteCount(n) })
+}
+
+// Retourniert die Anzahl der via Netzwerk empfangenen Bytes.
+func (p *GridServer) RecvBytes() (n ByteCount) {
+	p.withDisplay(func() { n = p.recvBytes })
+	return n
+}
+
+// Retourniert die Anzahl der an das Ausgabegeraet gesendeten Bytes.
+func (p *GridServer) SentBytes() (n ByteCount) {
+	p.withDisplay(func() { n = p.sentBytes })
+	return n
+}
+
+// Retourniert eine Kopie der Stoppuhr, mit welcher die Ausgabe der Bilder
+// gemessen wird. Zurueckgesetzt wird sie mit ResetStatistics.
 func (p *GridServer) Stopwatch() *Stopwatch {
-	return p.stopwatch
+	var s Stopwatch
+	p.withDisplay(func() { s = *p.stopwatch })
+	return &s
+}
+
+// Setzt die Zaehler und die Stoppuhr zurueck.
+func (p *GridServer) ResetStatistics() {
+	p.withDisplay(func() {
+		p.recvBytes = 0
+		p.sentBytes = 0
+		p.encodedBytes = 0
+		p.decodedBytes = 0
+		p.stopwatch.Reset()
+	})
 }
 
 // Retourniert die Gamma-Werte fuer die drei Farben.
 func (p *GridServer) Gamma() (r, g, b float64) {
-	return p.Disp.Gamma()
+	p.withDisplay(func() { r, g, b = p.Disp.Gamma() })
+	return r, g, b
 }
 
 // Setzt die Gamma-Werte fuer die Farben und aktualisiert die Mapping-Tabelle.
 func (p *GridServer) SetGamma(r, g, b float64) {
-	p.Disp.SetGamma(r, g, b)
+	p.withDisplay(func() { p.Disp.SetGamma(r, g, b) })
+}
+
+// Retourniert die maximalen Helligkeitswerte fuer die drei Farben.
+func (p *GridServer) MaxValue() (r, g, b uint8) {
+	p.withDisplay(func() { r, g, b = p.maxValue[0], p.maxValue[1], p.maxValue[2] })
+	return r, g, b
+}
+
+// Setzt die maximalen Helligkeitswerte fuer die drei Farben. Die Werte
+// werden dem Displayer weitergegeben (siehe BrightnessLimiter), welcher sie
+// nach der Gamma-Korrektur anwendet.
+func (p *GridServer) SetMaxValue(r, g, b uint8) {
+	p.withDisplay(func() {
+		p.maxValue = [3]uint8{r, g, b}
+		if disp, ok := p.Disp.(BrightnessLimiter); ok {
+			disp.SetMaxValue(r, g, b)
+		}
+	})
+}
+
+// Retourniert die Konfiguration der Strombegrenzung (siehe PowerConfig).
+// Displayer ohne Strombegrenzung liefern die Default-Konfiguration.
+func (p *GridServer) PowerConfig() PowerConfig {
+	cfg := DefaultPowerConfig()
+	if disp, ok := p.Disp.(PowerLimiter); ok {
+		p.withDisplay(func() { cfg = disp.PowerConfig() })
+	}
+	return cfg
+}
+
+// Setzt eine neue Konfiguration fuer die Strombegrenzung.
+func (p *GridServer) SetPowerConfig(cfg PowerConfig) error {
+	var err error
+
+	disp, ok := p.Disp.(PowerLimiter)
+	if !ok {
+		return fmt.Errorf("%w: displayer has no power limiter", ErrBadPowerConfig)
+	}
+	p.withDisplay(func() { err = disp.SetPowerConfig(cfg) })
+	return err
+}
+
+// Retourniert den geschaetzten Strom des zuletzt angezeigten Bildes.
+func (p *GridServer) PowerStats() (stats PowerStats) {
+	if disp, ok := p.Disp.(PowerLimiter); ok {
+		p.withDisplay(func() { stats = disp.PowerStats() })
+	}
+	return stats
+}
+
+// Retourniert die aktuelle Farbkalibrierung (siehe conf.Calibration).
+func (p *GridServer) Calibration() (cal conf.Calibration) {
+	if disp, ok := p.Disp.(Calibrator); ok {
+		p.withDisplay(func() { cal = disp.Calibration() })
+	}
+	return cal
+}
+
+// Setzt eine neue Farbkalibrierung. Passt sie nicht zur
+// Modul-Konfiguration, wird ein Fehler mit conf.ErrBadConfig retourniert.
+func (p *GridServer) SetCalibration(cal conf.Calibration) error {
+	var err error
+
+	disp, ok := p.Disp.(Calibrator)
+	if !ok {
+		return fmt.Errorf("%w: displayer has no calibration", conf.ErrBadConfig)
+	}
+	p.withDisplay(func() { err = disp.SetCalibration(cal) })
+	return err
 }
 
 func (p *GridServer) ModuleConfig() conf.ModuleConfig {
 	return p.Disp.ModuleConfig()
 }
 
-func (p *GridServer) SetPixelStatus(idx int, stat LedStatusType) {
-	p.Disp.SetPixelStatus(idx, stat)
+// Setzt den Status der LED mit Index idx auf der Kette. Der Status wird
+// zusaetzlich in der Status-Datenbank vermerkt und diese gespeichert, falls
+// sie mit LoadLedStatus geladen wurde.
+func (p *GridServer) SetPixelStatus(idx int, stat LedStatusType) (err error) {
+	modPos := p.ModuleConfig().Module(idx)
+	if modPos == nil {
+		return fmt.Errorf("%w: no LED with index %d", conf.ErrBadConfig, idx)
+	}
+	p.withDisplay(func() {
+		p.Disp.SetPixelStatus(idx, stat)
+		p.ledStatus.Set(*modPos, idx-modPos.Idx, stat)
+		err = p.saveLedStatus()
+	})
+	return err
+}
+
+// Laedt die Status-Datenbank aus der Datei fileName (siehe
+// conf.LedStatusDB) und setzt den Status aller LEDs entsprechend. Alle
+// spaeteren Aenderungen werden in dieser Datei gespeichert.
+func (p *GridServer) LoadLedStatus(fileName string) error {
+	db, err := conf.LoadLedStatus(fileName)
+	if err != nil {
+		return err
+	}
+	p.withDisplay(func() {
+		p.ledStatusFile = fileName
+		p.applyLedStatus(db)
+	})
+	return nil
+}
+
+// Retourniert die Status-Datenbank.
+func (p *GridServer) LedStatus() (db conf.LedStatusDB) {
+	p.withDisplay(func() { db = p.ledStatus })
+	return db
+}
+
+// Ersetzt die Status-Datenbank. Eintraege fuer Module, welche nicht Teil
+// der Modul-Konfiguration sind, werden uebernommen, aber nicht angewendet.
+func (p *GridServer) SetLedStatus(db conf.LedStatusDB) (err error) {
+	if err = db.Verify(); err != nil {
+		return fmt.Errorf("%w: %w", conf.ErrBadConfig, err)
+	}
+	p.withDisplay(func() {
+		p.applyLedStatus(db)
+		err = p.saveLedStatus()
+	})
+	return err
+}
+
+// Muss via withDisplay aufgerufen werden.
+func (p *GridServer) applyLedStatus(db conf.LedStatusDB) {
+	p.ledStatus = db
+	for idx, stat := range db.StatusList(p.ModuleConfig()) {
+		if idx < p.Disp.NumLeds() {
+			p.Disp.SetPixelStatus(idx, stat)
+		}
+	}
+}
+
+func (p *GridServer) saveLedStatus() error {
+	if p.ledStatusFile == "" {
+		return nil
+	}
+	return p.ledStatus.Save(p.ledStatusFile)
 }
 
 const (
@@ -192,99 +554,67 @@ const (
 	NumColorModes
 )
 
-func (p *GridServer) ToggleTestPattern() bool {
-	var colorMode int
-	var numTestLeds = p.Disp.NumLeds()
+// Schaltet das Testmuster ein oder aus und retourniert den neuen Zustand.
+// Das Testmuster laeuft als Quelle mit der hoechsten Prioritaet (siehe
+// arbiter.go) und uebersteuert damit alle anderen Quellen. Wird es
+// ausgeschaltet, uebernimmt wieder die naechste aktive Quelle.
+func (p *GridServer) ToggleTestPattern() (on bool) {
+	p.withDisplay(func() {
+		if p.testPattern != nil {
+			close(p.testPattern)
+			p.testPattern = nil
+			return
+		}
+		p.testPattern = make(chan struct{})
+		go p.drawTestPattern(p.testPattern)
+		on = true
+	})
+	return on
+}
+
+// Zeichnet das Testmuster, bis der Kanal stop geschlossen wird: die LEDs
+// werden der Kette entlang nacheinander in den Farben von RedChain bis
+// WhiteChain eingeschaltet.
+func (p *GridServer) drawTestPattern(stop <-chan struct{}) {
+	var colorMode = RedChain
+	var numTestLeds = p.bufferSize / 3
 	var ledIdx = 0
-	var testBufferSize = 3 * numTestLeds
 	var buffer []byte
 
+	src := p.OpenSource("testpattern", MaxPriority)
+	defer src.Close()
 	buffer = make([]byte, p.bufferSize)
-	if p.drawTestPattern {
-		p.drawTestPattern = false
-		return false
-	} else {
-		p.drawTestPattern = true
-		colorMode = RedChain
-	}
-
-	go func() {
-		for p.drawTestPattern {
-			switch colorMode {
-			case RedChain, GreenChain, BlueChain,
-				YellowChain, CyanChain, MagentaChain,
-				WhiteChain:
-				r, g, b := byte(0x00), byte(0x00), byte(0x00)
-				switch colorMode {
-				case RedChain, YellowChain, MagentaChain, WhiteChain:
-					r = 0xff
-				}
-				switch colorMode {
-				case GreenChain, YellowChain, CyanChain, WhiteChain:
-					g = 0xff
-				}
-				switch colorMode {
-				case BlueChain, CyanChain, MagentaChain, WhiteChain:
-					b = 0xff
-				}
-				buffer[3*ledIdx+0] = r
-				buffer[3*ledIdx+1] = g
-				buffer[3*ledIdx+2] = b
-				ledIdx++
-				if ledIdx >= numTestLeds {
-					for i := range testBufferSize {
-						buffer[i] = 0x00
-					}
-					ledIdx = 0
-					colorMode = (colorMode + 1) % NumColorModes
-				}
-			}
-
-			p.stopwatch.Start()
-			p.Disp.Send(buffer)
-			p.stopwatch.Stop()
-
-			time.Sleep(10 * time.Millisecond)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r, g, b := byte(0x00), byte(0x00), byte(0x00)
+		switch colorMode {
+		case RedChain, YellowChain, MagentaChain, WhiteChain:
+			r = 0xff
 		}
-		time.Sleep(2 * time.Second)
-		for i := range testBufferSize {
-			buffer[i] = 0x00
+		switch colorMode {
+		case GreenChain, YellowChain, CyanChain, WhiteChain:
+			g = 0xff
+		}
+		switch colorMode {
+		case BlueChain, CyanChain, MagentaChain, WhiteChain:
+			b = 0xff
+		}
+		buffer[3*ledIdx+0] = r
+		buffer[3*ledIdx+1] = g
+		buffer[3*ledIdx+2] = b
+		src.Display(buffer)
+		ledIdx++
+		if ledIdx >= numTestLeds {
+			clear(buffer)
+			ledIdx = 0
+			colorMode = (colorMode + 1) % NumColorModes
 		}
-		p.stopwatch.Start()
-		p.Disp.Send(buffer)
-		p.stopwatch.Stop()
-	}()
-
-	return true
-}
-
-// Die folgenden Methoden koennen via RPC vom Client aufgerufen werden.
-type NumLedsArg int
-
-func (p *GridServer) RPCNumLeds(arg int, reply *NumLedsArg) error {
-	*reply = NumLedsArg(p.Disp.NumLeds())
-	return nil
-}
-
-type GammaArg struct {
-	RedVal, GreenVal, BlueVal float64
-}
-
-func (p *GridServer) RPCGamma(arg int, reply *GammaArg) error {
-	reply.RedVal, reply.GreenVal, reply.BlueVal = p.Gamma()
-	return nil
-}
-
-func (p *GridServer) RPCSetGamma(arg GammaArg, reply *int) error {
-	p.SetGamma(arg.RedVal, arg.GreenVal, arg.BlueVal)
-	return nil
-}
-
-type ModuleConfigArg struct {
-	ModuleConfig conf.ModuleConfig
-}
 
-func (p *GridServer) RPCModuleConfig(arg int, reply *ModuleConfigArg) error {
-	reply.ModuleConfig = p.Disp.ModuleConfig()
-	return nil
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -1,9 +1,13 @@
 package ledgrid
 
 import (
+	"bytes"
+	"image"
 	"math"
 	"math/rand"
 	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
 )
 
 var (
@@ -71,3 +75,86 @@ func BenchmarkGammaByMod(b *testing.B) {
 		}
 	}
 }
+
+// Liefert die Default-Konfiguration fuer die Tests; size muss ein Vielfaches
+// der Modulgroesse sein.
+func testModConf(size image.Point) conf.ModuleConfig {
+	modConf, err := conf.DefaultModuleConfig(size)
+	if err != nil {
+		panic(err)
+	}
+	return modConf
+}
+
+// Displayer fuer die Tests: die gesendeten Bilddaten werden in einem Kanal
+// abgelegt, statt auf eine Hardware uebertragen zu werden.
+type testDisplayer struct {
+	DisplayEmbed
+	frames chan []byte
+}
+
+func newTestDisplayer(modConf conf.ModuleConfig) *testDisplayer {
+	d := &testDisplayer{frames: make(chan []byte, 16)}
+	d.DisplayEmbed.Init(d, len(modConf)*conf.ModuleDim.X*conf.ModuleDim.Y)
+	d.SetModuleConfig(modConf)
+	return d
+}
+
+func (d *testDisplayer) DefaultGamma() (r, g, b float64) {
+	return 1.0, 1.0, 1.0
+}
+
+func (d *testDisplayer) Close() {}
+
+func (d *testDisplayer) Send(buffer []byte) {
+	frame := make([]byte, len(buffer))
+	copy(frame, buffer)
+	d.frames <- frame
+}
+
+// Erstellt einen GridServer ohne offene Ports, damit HandleMessage direkt
+// mit einer Seite von net.Pipe getestet werden kann.
+func newTestServer(disp Displayer) *GridServer {
+	p := &GridServer{Disp: disp}
+	p.bufferSize = 3 * disp.NumLeds()
+	p.maxValue = [3]uint8{255, 255, 255}
+	p.stopwatch = NewStopwatch()
+	p.arbiter = newArbiter(p)
+	return p
+}
+
+func testFrame(size int, val byte) []byte {
+	frame := make([]byte, size)
+	for i := range frame {
+		frame[i] = val + byte(i)
+	}
+	return frame
+}
+
+// Die Reihenfolge der Farben wird pro Displayer gesetzt und kann pro Modul
+// uebersteuert werden.
+func TestColorOrder(t *testing.T) {
+	modConf := testModConf(image.Point{20, 10})
+	modConf[1].Order = conf.RGB
+	disp := newTestDisplayer(modConf)
+	modSize := conf.ModuleDim.X * conf.ModuleDim.Y
+
+	frame := make([]byte, 3*disp.NumLeds())
+	for i := 0; i < len(frame); i += 3 {
+		frame[i], frame[i+1], frame[i+2] = 0x10, 0x20, 0x30
+	}
+	disp.Display(frame)
+	if b := <-disp.frames; !bytes.Equal(b[:3], []byte{0x10, 0x20, 0x30}) {
+		t.Errorf("without order: expected RGB, got %x", b[:3])
+	}
+
+	disp.SetColorOrder(conf.BGR)
+	disp.Display(frame)
+	b := <-disp.frames
+	if !bytes.Equal(b[:3], []byte{0x30, 0x20, 0x10}) {
+		t.Errorf("first module: expected BGR, got %x", b[:3])
+	}
+	if !bytes.Equal(b[3*modSize:3*modSize+3], []byte{0x10, 0x20, 0x30}) {
+		t.Errorf("second module: expected RGB, got %x", b[3*modSize:3*modSize+3])
+	}
+}
@@ -2,9 +2,11 @@ package ledgrid
 
 import (
 	"container/list"
+	"fmt"
 	"image"
 	"image/color"
 	"log"
+	"math"
 	"sync"
 
 	"github.com/stefan-muehlebach/gg/colors"
@@ -36,27 +38,66 @@ type LedGrid struct {
 	CanvasList *list.List
 	canvMutex  *sync.RWMutex
 
-	// Mit dieser Struktur (slice of slices) werden Pixel-Koordinaten in
-	// Indizes uebersetzt.
-	idxMap   conf.IndexMap
+	// Mit dieser flachen Tabelle werden Pixel-Koordinaten in Indizes
+	// uebersetzt (siehe conf.PixelMap).
+	pixMap   conf.PixelMap
 	syncChan chan bool
+
+	// Die Canvas'es werden zuerst in img gezeichnet, welches anschliessend
+	// via pixMap in die Kette kopiert wird. Bei einem freien Layout (siehe
+	// NewLedGridLayout) wird img an den Positionen der LEDs abgetastet und
+	// ist auch das Ziel von Set und SetLedColor.
+	layout   conf.Layout
+	sampling Sampling
+	img      *image.RGBA
 }
 
+// Legt fest, wie die Farbe einer LED bei einem freien Layout bestimmt wird,
+// wenn ihre Position nicht genau auf einem Pixel liegt.
+type Sampling int
+
+const (
+	// Die LED erhaelt die Farbe des naechstgelegenen Pixels.
+	SampleNearest Sampling = iota
+	// Die Farbe wird aus den vier umliegenden Pixeln interpoliert.
+	SampleBilinear
+)
+
 // Erstellt ein neues LedGrid-Objekt, welches die Groesse size in Anzahl LEDs
 // horizontal, resp. vertikal hat. Die Verkabelung wird vollflaechig und
 // gem. Methode DefaultModuleConfig vorgenommen.
-func NewLedGridBySize(client GridClient, size image.Point) *LedGrid {
-	modConf := conf.DefaultModuleConfig(size)
-	return NewLedGrid(client, modConf)
+func NewLedGridBySize(client GridClient, size image.Point) (*LedGrid, error) {
+	modConf, err := conf.DefaultModuleConfig(size)
+	if err != nil {
+		return nil, err
+	}
+	return NewLedGrid(client, modConf), nil
 }
 
 // Erstellt ein neues LedGrid-Objekt, welches als Verkabelung modConf hat.
 func NewLedGrid(client GridClient, modConf conf.ModuleConfig) *LedGrid {
+	g := newLedGrid(client, modConf.Size(), modConf.NumLeds())
+	g.pixMap = modConf.PixelMap()
+	return g
+}
+
+// Erstellt ein neues LedGrid-Objekt fuer eine Installation, deren LEDs
+// nicht in Modulen angeordnet sind (Ringe, Spiralen, Streifen, etc.). Die
+// Groesse der Canvas'es ergibt sich aus dem Layout; die Farben der LEDs
+// werden gemaess sampling an ihren Positionen abgetastet.
+func NewLedGridLayout(client GridClient, layout conf.Layout, sampling Sampling) *LedGrid {
+	g := newLedGrid(client, layout.Size(), layout.NumLeds())
+	g.layout = layout
+	g.sampling = sampling
+	return g
+}
+
+func newLedGrid(client GridClient, size image.Point, numLeds int) *LedGrid {
 	g := &LedGrid{}
 	g.Client = client
-	g.Rect = image.Rectangle{Max: modConf.Size()}
-	g.Pix = make([]uint8, 3*len(modConf)*conf.ModuleDim.X*conf.ModuleDim.Y)
-	g.idxMap = modConf.IndexMap()
+	g.Rect = image.Rectangle{Max: size}
+	g.Pix = make([]uint8, 3*numLeds)
+	g.img = image.NewRGBA(g.Rect)
 	g.syncChan = make(chan bool)
 	g.AnimCtrl = NewAnimationController(g.syncChan)
 	g.CanvasList = list.New()
@@ -99,6 +140,10 @@ func (g *LedGrid) LedColorAt(x, y int) colors.RGBA {
 	if !(image.Point{x, y}.In(g.Rect)) {
 		return colors.RGBA{}
 	}
+	if g.layout != nil {
+		src := g.img.Pix[g.img.PixOffset(x, y):]
+		return colors.RGBA{src[0], src[1], src[2], 0xff}
+	}
 	idx := g.PixOffset(x, y)
 	if idx < 0 {
 		return colors.Black
@@ -112,6 +157,11 @@ func (g *LedGrid) SetLedColor(x, y int, c colors.RGBA) {
 	if !(image.Point{x, y}.In(g.Rect)) {
 		return
 	}
+	if g.layout != nil {
+		dst := g.img.Pix[g.img.PixOffset(x, y):]
+		dst[0], dst[1], dst[2], dst[3] = c.R, c.G, c.B, 0xff
+		return
+	}
 	idx := g.PixOffset(x, y)
 	if idx < 0 {
 		return
@@ -125,9 +175,13 @@ func (g *LedGrid) SetLedColor(x, y int, c colors.RGBA) {
 // Damit wird der Offset eines bestimmten Farbwerts innerhalb des Slices
 // Pix berechnet. Dabei wird beruecksichtigt, dass das die LED's im LedGrid
 // schlangenfoermig angeordnet sind, und der Beginn der LED-Kette frei
-// waehlbar in einer Ecke des Panels liegen kann.
+// waehlbar in einer Ecke des Panels liegen kann. Bei einem freien Layout
+// gibt es keine feste Zuordnung, der Offset ist immer -1.
 func (g *LedGrid) PixOffset(x, y int) int {
-	return 3 * g.idxMap[x][y]
+	if g.layout != nil {
+		return -1
+	}
+	return 3 * g.pixMap.Lookup(x, y)
 }
 
 // Mit Clear kann das ganze Grid geloescht, resp. alle LEDs auf die gleiche
@@ -150,8 +204,69 @@ func (g *LedGrid) Reset() {
 
 // Zeigt den aktuellen Inhalt des Grid auf der beim Erstellen spezifizierten
 // Hardware dar.
-func (g *LedGrid) Show() {
-	g.Client.Send(g.Pix)
+func (g *LedGrid) Show() error {
+	if g.layout != nil {
+		g.sample()
+	}
+	return g.Client.Send(g.Pix)
+}
+
+// Zeichnet alle Canvas'es von hinten nach vorne in img. Anders als beim
+// Zeichnen via Set (siehe draw.Image) werden dabei die schnellen Varianten
+// von draw.DrawMask fuer image.RGBA verwendet.
+func (g *LedGrid) compose() {
+	for i := 0; i < len(g.img.Pix); i += 4 {
+		g.img.Pix[i], g.img.Pix[i+1], g.img.Pix[i+2], g.img.Pix[i+3] = 0, 0, 0, 0xff
+	}
+	for ele := g.CanvasList.Back(); ele != nil; ele = ele.Prev() {
+		canv, ok := ele.Value.(*Canvas)
+		if !ok {
+			continue
+		}
+		canv.Refresh()
+		mask := canv.Mask
+		if u, ok := mask.(*image.Uniform); ok {
+			if _, _, _, a := u.RGBA(); a == 0xffff {
+				mask = nil
+			}
+		}
+		draw.DrawMask(g.img, g.Rect, canv.Img, image.Point{}, mask,
+			image.Point{}, draw.Over)
+	}
+}
+
+// Kopiert die Farben aus img direkt in die Kette (siehe conf.PixelMap).
+func (g *LedGrid) gather() {
+	for idx, offset := range g.pixMap.Gather {
+		copy(g.Pix[3*idx:3*idx+3], g.img.Pix[4*offset:4*offset+3])
+	}
+}
+
+// Bestimmt bei einem freien Layout die Farben der LEDs aus dem Bild img.
+// Die Position (x, y) entspricht dabei der Mitte des Pixels (x, y).
+func (g *LedGrid) sample() {
+	maxX, maxY := g.Rect.Dx()-1, g.Rect.Dy()-1
+	for i, pos := range g.layout {
+		dst := g.Pix[3*i : 3*i+3 : 3*i+3]
+		if g.sampling != SampleBilinear {
+			x := min(int(math.Round(pos.X)), maxX)
+			y := min(int(math.Round(pos.Y)), maxY)
+			copy(dst, g.img.Pix[g.img.PixOffset(x, y):])
+			continue
+		}
+		x0, y0 := int(pos.X), int(pos.Y)
+		x1, y1 := min(x0+1, maxX), min(y0+1, maxY)
+		fx, fy := pos.X-float64(x0), pos.Y-float64(y0)
+		p00 := g.img.Pix[g.img.PixOffset(x0, y0):]
+		p10 := g.img.Pix[g.img.PixOffset(x1, y0):]
+		p01 := g.img.Pix[g.img.PixOffset(x0, y1):]
+		p11 := g.img.Pix[g.img.PixOffset(x1, y1):]
+		for j := range dst {
+			top := (1-fx)*float64(p00[j]) + fx*float64(p10[j])
+			bottom := (1-fx)*float64(p01[j]) + fx*float64(p11[j])
+			dst[j] = uint8(math.Round((1-fy)*top + fy*bottom))
+		}
+	}
 }
 
 // Erzeugt ein neues Canvas-Objekt und hanegt es an den Schluss der Liste.
@@ -176,22 +291,24 @@ func (g *LedGrid) DelCanvas(canv *Canvas) {
 }
 
 // Liefert das Canvas-Objekt zurueck, welches fuer den Layer layer definiert
-// ist. Per Default ist nur Layer 0 vorhanden, die Methode bricht ab, wenn
-// es kein Canvas-Objekt zum gewuenschten Layer gibt.
-func (g *LedGrid) Canvas(layer int) *Canvas {
+// ist. Per Default ist nur Layer 0 vorhanden, gibt es kein Canvas-Objekt zum
+// gewuenschten Layer, wird ErrNoCanvas retourniert.
+func (g *LedGrid) Canvas(layer int) (*Canvas, error) {
 	var elem *list.Element
 	var id int
 
-	if layer >= g.CanvasList.Len() {
-		log.Fatalf("Cannot access canvas[%d]; only %d in list", layer, g.CanvasList.Len())
-		return nil
+	g.canvMutex.RLock()
+	defer g.canvMutex.RUnlock()
+	if layer < 0 || layer >= g.CanvasList.Len() {
+		return nil, fmt.Errorf("%w: canvas[%d]; only %d in list", ErrNoCanvas,
+			layer, g.CanvasList.Len())
 	}
 	for elem, id = g.CanvasList.Front(), 0; elem != nil; elem, id = elem.Next(), id+1 {
 		if id == layer {
 			break
 		}
 	}
-	return elem.Value.(*Canvas)
+	return elem.Value.(*Canvas), nil
 }
 
 func (g *LedGrid) StartRefresh() {
@@ -199,23 +316,17 @@ func (g *LedGrid) StartRefresh() {
 }
 
 func (g *LedGrid) refreshThread() {
-	var canv *Canvas
-	var ok bool
-
 	for {
 		<-g.syncChan
 		g.canvMutex.RLock()
-		g.Clear(colors.Black)
-		for ele := g.CanvasList.Back(); ele != nil; ele = ele.Prev() {
-			if canv, ok = ele.Value.(*Canvas); !ok {
-				log.Fatalf("Wrong data in canvas-list")
-			}
-			canv.Refresh()
-			draw.DrawMask(g, g.Bounds(), canv.Img, image.Point{},
-				canv.Mask, image.Point{}, draw.Over)
+		g.compose()
+		if g.layout == nil {
+			g.gather()
 		}
 		g.canvMutex.RUnlock()
 		g.syncChan <- true
-		g.Show()
+		if err := g.Show(); err != nil {
+			log.Printf("Couldn't show grid: %v", err)
+		}
 	}
 }
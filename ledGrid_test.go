@@ -0,0 +1,141 @@
+package ledgrid
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+
+	"github.com/stefan-muehlebach/gg/colors"
+	"github.com/stefan-muehlebach/gg/geom"
+	"github.com/stefan-muehlebach/ledgrid/conf"
+	"golang.org/x/image/draw"
+)
+
+func TestLedGridLayout(t *testing.T) {
+	// Mit dem Layout einer Modul-Konfiguration muss das Abtasten die
+	// gleichen Daten liefern wie die Zuordnung via IndexMap.
+	modConf := testModConf(image.Point{20, 10})
+	img := image.NewRGBA(image.Rectangle{Max: modConf.Size()})
+	for i := range img.Pix {
+		img.Pix[i] = uint8(7 * i)
+	}
+	grid := NewLedGrid(nil, modConf)
+	draw.Draw(grid, grid.Bounds(), img, image.Point{}, draw.Src)
+	for _, sampling := range []Sampling{SampleNearest, SampleBilinear} {
+		layoutGrid := NewLedGridLayout(nil, modConf.Layout(), sampling)
+		if layoutGrid.Bounds() != grid.Bounds() {
+			t.Fatalf("bounds: got %v", layoutGrid.Bounds())
+		}
+		draw.Draw(layoutGrid, layoutGrid.Bounds(), img, image.Point{}, draw.Src)
+		layoutGrid.sample()
+		if !slices.Equal(layoutGrid.Pix, grid.Pix) {
+			t.Errorf("sampling %d: data differs from module configuration", sampling)
+		}
+	}
+
+	// Zwischen zwei Pixeln wird je nach Verfahren interpoliert.
+	layout := conf.Layout{{0.25, 0}, {1, 0}}
+	for _, tc := range []struct {
+		sampling Sampling
+		want     uint8
+	}{{SampleNearest, 200}, {SampleBilinear, 175}} {
+		grid := NewLedGridLayout(nil, layout, tc.sampling)
+		grid.SetLedColor(0, 0, colors.RGBA{200, 0, 0, 0xff})
+		grid.SetLedColor(1, 0, colors.RGBA{100, 0, 0, 0xff})
+		grid.sample()
+		if grid.Pix[0] != tc.want || grid.Pix[3] != 100 {
+			t.Errorf("sampling %d: got %v", tc.sampling, grid.Pix)
+		}
+	}
+}
+
+// Erstellt ein LedGrid mit zwei Canvas'es fuer die Tests des Compositors:
+// der vordere ist halbtransparent und hat eine Maske.
+func testComposeGrid(modConf conf.ModuleConfig) *LedGrid {
+	grid := NewLedGrid(nil, modConf)
+	size := geom.Point{float64(grid.Rect.Dx()), float64(grid.Rect.Dy())}
+	back, _ := grid.Canvas(0)
+	back.BackColor = colors.RGBA{0x10, 0x20, 0x30, 0xff}
+	back.Add(NewEllipse(size.Mul(0.5), size.Mul(0.8), colors.RGBA{0xff, 0x80, 0x00, 0xff}))
+	front, _ := grid.NewCanvas()
+	front.BackColor = colors.RGBA{0x00, 0x40, 0x00, 0x40}
+	front.Mask = image.NewUniform(color.Alpha{0xc0})
+	front.Add(NewRectangle(size.Mul(0.3), size.Mul(0.4), colors.RGBA{0x00, 0x00, 0xff, 0xff}))
+	return grid
+}
+
+// Bisheriges Verfahren: die Canvas'es werden via Set direkt in die Kette
+// gezeichnet.
+func composeBySet(g *LedGrid) {
+	g.Clear(colors.Black)
+	for ele := g.CanvasList.Back(); ele != nil; ele = ele.Prev() {
+		canv := ele.Value.(*Canvas)
+		canv.Refresh()
+		draw.DrawMask(g, g.Bounds(), canv.Img, image.Point{},
+			canv.Mask, image.Point{}, draw.Over)
+	}
+}
+
+func TestLedGridCompose(t *testing.T) {
+	// Der Compositor muss (bis auf Rundungsfehler) die gleichen Daten
+	// liefern wie das Zeichnen via Set, auch bei Konfigurationen mit Loechern.
+	for _, fileName := range []string{"data/squareWithHole.json", "data/mixed.json"} {
+		modConf, err := conf.Load(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grid := testComposeGrid(modConf)
+		composeBySet(grid)
+		want := slices.Clone(grid.Pix)
+		clear(grid.Pix)
+		grid.compose()
+		grid.gather()
+		for i := range want {
+			if d := int(grid.Pix[i]) - int(want[i]); d < -1 || d > 1 {
+				t.Fatalf("%s: Pix[%d]: got %d, want %d", fileName, i, grid.Pix[i], want[i])
+			}
+		}
+		for y := range grid.Rect.Dy() {
+			for x := range grid.Rect.Dx() {
+				if off, idx := grid.PixOffset(x, y), modConf.Index(image.Point{x, y}); off != 3*idx {
+					t.Fatalf("%s: PixOffset(%d,%d): got %d, want %d", fileName, x, y, off, 3*idx)
+				}
+			}
+		}
+	}
+}
+
+// Vergleich der beiden Verfahren auf einem Panel mit 80x60 Pixeln (siehe
+// conf/doc/default80x60.png): zuerst das Zeichnen via Set...
+func BenchmarkComposeBySet(b *testing.B) {
+	grid := testComposeGrid(testModConf(image.Point{80, 60}))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		composeBySet(grid)
+	}
+}
+
+// ... dann der Compositor mit anschliessendem Kopieren in die Kette...
+func BenchmarkComposeGather(b *testing.B) {
+	grid := testComposeGrid(testModConf(image.Point{80, 60}))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		grid.compose()
+		grid.gather()
+	}
+}
+
+// ... und schliesslich das Setzen der einzelnen LEDs via SetLedColor.
+func BenchmarkSetLedColor(b *testing.B) {
+	grid := testComposeGrid(testModConf(image.Point{80, 60}))
+	col := colors.RGBA{0x12, 0x34, 0x56, 0xff}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := range 60 {
+			for x := range 80 {
+				grid.SetLedColor(x, y, col)
+			}
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package ledgrid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// With several panels on the same network, typing the right host name
+// gets tedious. The GridServer can therefore advertise itself via
+// multicast DNS (RFC 6762) and DNS service discovery (RFC 6763) as service
+// MDNSService. The TXT record contains the size of the panel, the number of
+// modules and the ports, so a client can connect without any further
+// configuration. Only the small subset of DNS needed for this is
+// implemented here: PTR, SRV, TXT and A records, no name compression when
+// writing and no probing for conflicting instance names.
+const (
+	MDNSService = "_ledgrid._tcp"
+	mdnsDomain  = "local."
+	mdnsPort    = 5353
+	mdnsTTL     = 120
+
+	// Time to wait for answers in the '-host auto' mode of the commands.
+	DefDiscoverTimeout = time.Second
+)
+
+var (
+	mdnsGroup       = netip.AddrPortFrom(netip.AddrFrom4([4]byte{224, 0, 0, 251}), mdnsPort)
+	mdnsServiceName = MDNSService + "." + mdnsDomain
+)
+
+// DNS record types and flags used by mDNS.
+const (
+	dnsTypeA     = 1
+	dnsTypePTR   = 12
+	dnsTypeTXT   = 16
+	dnsTypeSRV   = 33
+	dnsTypeANY   = 255
+	dnsClassIN   = 1
+	dnsCacheFlag = 0x8000
+	dnsUnicast   = 0x8000
+	dnsResponse  = 0x8400
+	dnsHdrSize   = 12
+)
+
+var errBadDNS = errors.New("malformed DNS message")
+
+type dnsQuestion struct {
+	Name    string
+	Type    uint16
+	Unicast bool
+}
+
+// A resource record. Depending on Type, only some of the data fields are
+// used: Target for PTR and SRV, Port for SRV, Text for TXT and Addr for A.
+type dnsRecord struct {
+	Name   string
+	Type   uint16
+	TTL    uint32
+	Target string
+	Port   uint16
+	Text   []string
+	Addr   netip.Addr
+}
+
+// A DNS message. When parsing, the records of the answer, authority and
+// additional sections are all stored in Answers.
+type dnsMessage struct {
+	ID        uint16
+	Response  bool
+	Questions []dnsQuestion
+	Answers   []dnsRecord
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// Reads the (possibly compressed) name at offset off of msg and returns
+// it together with the offset of the first byte after the name.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errBadDNS
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errBadDNS
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errBadDNS
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func (m *dnsMessage) pack() []byte {
+	var flags uint16
+
+	if m.Response {
+		flags = dnsResponse
+	}
+	b := make([]byte, dnsHdrSize, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	for _, q := range m.Questions {
+		class := uint16(dnsClassIN)
+		if q.Unicast {
+			class |= dnsUnicast
+		}
+		b = appendDNSName(b, q.Name)
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+	}
+	for _, rr := range m.Answers {
+		class := uint16(dnsClassIN)
+		if rr.Type != dnsTypePTR {
+			class |= dnsCacheFlag
+		}
+		b = appendDNSName(b, rr.Name)
+		b = binary.BigEndian.AppendUint16(b, rr.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+		b = binary.BigEndian.AppendUint32(b, rr.TTL)
+		lenOff := len(b)
+		b = append(b, 0, 0)
+		switch rr.Type {
+		case dnsTypePTR:
+			b = appendDNSName(b, rr.Target)
+		case dnsTypeSRV:
+			b = append(b, 0, 0, 0, 0)
+			b = binary.BigEndian.AppendUint16(b, rr.Port)
+			b = appendDNSName(b, rr.Target)
+		case dnsTypeTXT:
+			for _, txt := range rr.Text {
+				b = append(b, byte(len(txt)))
+				b = append(b, txt...)
+			}
+		case dnsTypeA:
+			b = append(b, rr.Addr.AsSlice()...)
+		}
+		binary.BigEndian.PutUint16(b[lenOff:], uint16(len(b)-lenOff-2))
+	}
+	return b
+}
+
+func parseDNSMessage(b []byte) (dnsMessage, error) {
+	var m dnsMessage
+	var err error
+
+	if len(b) < dnsHdrSize {
+		return m, errBadDNS
+	}
+	m.ID = binary.BigEndian.Uint16(b[0:])
+	m.Response = b[2]&0x80 != 0
+	numQuestions := int(binary.BigEndian.Uint16(b[4:]))
+	numRecords := 0
+	for i := 6; i < dnsHdrSize; i += 2 {
+		numRecords += int(binary.BigEndian.Uint16(b[i:]))
+	}
+	off := dnsHdrSize
+	for range numQuestions {
+		var q dnsQuestion
+		if q.Name, off, err = readDNSName(b, off); err != nil {
+			return m, err
+		}
+		if off+4 > len(b) {
+			return m, errBadDNS
+		}
+		q.Type = binary.BigEndian.Uint16(b[off:])
+		q.Unicast = binary.BigEndian.Uint16(b[off+2:])&dnsUnicast != 0
+		m.Questions = append(m.Questions, q)
+		off += 4
+	}
+	for range numRecords {
+		var rr dnsRecord
+		if rr.Name, off, err = readDNSName(b, off); err != nil {
+			return m, err
+		}
+		if off+10 > len(b) {
+			return m, errBadDNS
+		}
+		rr.Type = binary.BigEndian.Uint16(b[off:])
+		rr.TTL = binary.BigEndian.Uint32(b[off+4:])
+		size := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+size > len(b) {
+			return m, errBadDNS
+		}
+		data := b[off : off+size]
+		switch rr.Type {
+		case dnsTypePTR:
+			rr.Target, _, err = readDNSName(b, off)
+		case dnsTypeSRV:
+			if size < 7 {
+				return m, errBadDNS
+			}
+			rr.Port = binary.BigEndian.Uint16(data[4:])
+			rr.Target, _, err = readDNSName(b, off+6)
+		case dnsTypeTXT:
+			for i := 0; i < len(data); i += 1 + int(data[i]) {
+				if i+1+int(data[i]) > len(data) {
+					return m, errBadDNS
+				}
+				rr.Text = append(rr.Text, string(data[i+1:i+1+int(data[i])]))
+			}
+		case dnsTypeA:
+			if size == 4 {
+				rr.Addr = netip.AddrFrom4([4]byte(data))
+			}
+		}
+		if err != nil {
+			return m, err
+		}
+		m.Answers = append(m.Answers, rr)
+		off += size
+	}
+	return m, nil
+}
+
+// GridService describes a GridServer found by Discover.
+type GridService struct {
+	// Name of the instance, by default the host name of the controller.
+	Instance string
+	// Host name and address of the controller. Addr is the source address
+	// of the response and can be used to connect to the server directly.
+	Host string
+	Addr netip.Addr
+	// Ports for the frame data (framed protocol over TCP and UDP, see
+	// DefDataPort), for legacy clients sending frames without header (see
+	// DefTCPPort) and for the REST API.
+	DataPort, TCPPort, RPCPort uint
+	// Size of the panel in pixels and number of modules.
+	Size    image.Point
+	Modules int
+}
+
+func (s GridService) String() string {
+	return fmt.Sprintf("%s (%s, %dx%d, %d modules)", s.Instance, s.Addr,
+		s.Size.X, s.Size.Y, s.Modules)
+}
+
+// mdnsResponder answers the queries for a single instance of MDNSService.
+type mdnsResponder struct {
+	conn     *net.UDPConn
+	instance string
+	host     string
+	port     uint16
+	text     []string
+}
+
+func newMDNSResponder(conn *net.UDPConn, instance string, modConf conf.ModuleConfig,
+	tcpPort, dataPort, rpcPort uint) *mdnsResponder {
+	r := &mdnsResponder{conn: conn}
+
+	// Dots in the instance name would have to be escaped, they are
+	// simply replaced.
+	instance = strings.ReplaceAll(instance, ".", "-")
+	r.instance = instance + "." + mdnsServiceName
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = instance
+	}
+	r.host = strings.ReplaceAll(hostName, ".", "-") + "." + mdnsDomain
+	r.port = uint16(dataPort)
+	size := modConf.Size()
+	r.text = []string{
+		fmt.Sprintf("size=%dx%d", size.X, size.Y),
+		fmt.Sprintf("modules=%d", len(modConf)),
+		fmt.Sprintf("data=%d", dataPort),
+		fmt.Sprintf("tcp=%d", tcpPort),
+		fmt.Sprintf("rpc=%d", rpcPort),
+		fmt.Sprintf("proto=%d", ProtoVersion),
+	}
+	return r
+}
+
+// Enables the advertisement of this server via mDNS/DNS-SD under the name
+// instance. The responder is started by HandleEvents and sends a goodbye
+// message when the server is closed.
+func (p *GridServer) EnableMDNS(instance string) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, net.UDPAddrFromAddrPort(mdnsGroup))
+	if err != nil {
+		return err
+	}
+	p.mdns = newMDNSResponder(conn, instance, p.ModuleConfig(),
+		uint(p.tcpListener.Addr().(*net.TCPAddr).Port),
+		uint(p.dataListener.Addr().(*net.TCPAddr).Port),
+		uint(p.rpcListener.Addr().(*net.TCPAddr).Port))
+	return nil
+}
+
+// Returns all records of the instance. A TTL of 0 announces that the
+// service is no longer available.
+func (r *mdnsResponder) records(ttl uint32) []dnsRecord {
+	recs := []dnsRecord{
+		{Name: mdnsServiceName, Type: dnsTypePTR, TTL: ttl, Target: r.instance},
+		{Name: r.instance, Type: dnsTypeSRV, TTL: ttl, Target: r.host, Port: r.port},
+		{Name: r.instance, Type: dnsTypeTXT, TTL: ttl, Text: r.text},
+	}
+	addrList, _ := net.InterfaceAddrs()
+	for _, addr := range addrList {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		ip, _ := netip.AddrFromSlice(ipNet.IP.To4())
+		recs = append(recs, dnsRecord{Name: r.host, Type: dnsTypeA, TTL: ttl, Addr: ip})
+	}
+	return recs
+}
+
+// Checks whether one of the questions concerns this instance.
+func (r *mdnsResponder) matches(questions []dnsQuestion) bool {
+	for _, q := range questions {
+		switch {
+		case strings.EqualFold(q.Name, mdnsServiceName):
+			if q.Type == dnsTypePTR || q.Type == dnsTypeANY {
+				return true
+			}
+		case strings.EqualFold(q.Name, r.instance):
+			if q.Type == dnsTypeSRV || q.Type == dnsTypeTXT || q.Type == dnsTypeANY {
+				return true
+			}
+		case strings.EqualFold(q.Name, r.host):
+			if q.Type == dnsTypeA || q.Type == dnsTypeANY {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sends all records unsolicited to the multicast group.
+func (r *mdnsResponder) announce(ttl uint32) {
+	msg := dnsMessage{Response: true, Answers: r.records(ttl)}
+	r.conn.WriteToUDPAddrPort(msg.pack(), mdnsGroup)
+}
+
+// Answers the queries received on the socket. Queries from a port other
+// than 5353 (so called legacy unicast queries, RFC 6762, 6.7) and queries
+// with the unicast bit set are answered directly to the sender, all others
+// to the multicast group.
+func (r *mdnsResponder) handle() {
+	buffer := make([]byte, 9000)
+	for {
+		n, src, err := r.conn.ReadFromUDPAddrPort(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Failed mDNS Read(): %v", err)
+			}
+			return
+		}
+		query, err := parseDNSMessage(buffer[:n])
+		if err != nil || query.Response || !r.matches(query.Questions) {
+			continue
+		}
+		reply := dnsMessage{Response: true, Answers: r.records(mdnsTTL)}
+		dst := mdnsGroup
+		if src.Port() != mdnsPort || slices.ContainsFunc(query.Questions,
+			func(q dnsQuestion) bool { return q.Unicast }) {
+			reply.ID = query.ID
+			reply.Questions = query.Questions
+			dst = src
+		}
+		r.conn.WriteToUDPAddrPort(reply.pack(), dst)
+	}
+}
+
+// Withdraws the advertisement and closes the socket.
+func (r *mdnsResponder) Close() {
+	r.announce(0)
+	r.conn.Close()
+}
+
+// Searches the local network for GridServers advertising themselves via
+// mDNS and returns all servers answering within timeout.
+func Discover(timeout time.Duration) ([]GridService, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return discover(conn, mdnsGroup, timeout)
+}
+
+// Returns the one GridServer on the local network. If no or more than one
+// server is found, an error wrapping ErrNoGrid, resp. ErrManyGrids is
+// returned; the latter lists the instance names found.
+func DiscoverGrid(timeout time.Duration) (GridService, error) {
+	svcList, err := Discover(timeout)
+	if err != nil {
+		return GridService{}, err
+	}
+	switch len(svcList) {
+	case 0:
+		return GridService{}, ErrNoGrid
+	case 1:
+		return svcList[0], nil
+	}
+	names := make([]string, len(svcList))
+	for i, svc := range svcList {
+		names[i] = svc.String()
+	}
+	return GridService{}, fmt.Errorf("%w: %s", ErrManyGrids, strings.Join(names, ", "))
+}
+
+// Sends a query for MDNSService to dst and collects the answers until
+// timeout expires.
+func discover(conn *net.UDPConn, dst netip.AddrPort, timeout time.Duration) ([]GridService, error) {
+	var svcList []*GridService
+
+	service := func(name string) *GridService {
+		instance, ok := strings.CutSuffix(strings.ToLower(name), "."+mdnsServiceName)
+		if !ok {
+			return nil
+		}
+		for _, svc := range svcList {
+			if strings.EqualFold(svc.Instance, instance) {
+				return svc
+			}
+		}
+		svc := &GridService{Instance: name[:len(instance)]}
+		svcList = append(svcList, svc)
+		return svc
+	}
+
+	query := dnsMessage{ID: uint16(time.Now().UnixNano()), Questions: []dnsQuestion{
+		{Name: mdnsServiceName, Type: dnsTypePTR},
+	}}
+	if _, err := conn.WriteToUDPAddrPort(query.pack(), dst); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDPAddrPort(buffer)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+		msg, err := parseDNSMessage(buffer[:n])
+		if err != nil || !msg.Response {
+			continue
+		}
+		for _, rr := range msg.Answers {
+			switch rr.Type {
+			case dnsTypePTR:
+				if strings.EqualFold(rr.Name, mdnsServiceName) {
+					service(rr.Target)
+				}
+			case dnsTypeSRV:
+				if svc := service(rr.Name); svc != nil {
+					svc.Host = rr.Target
+					svc.Addr = src.Addr().Unmap()
+					svc.DataPort = uint(rr.Port)
+				}
+			case dnsTypeTXT:
+				if svc := service(rr.Name); svc != nil {
+					svc.parseText(rr.Text)
+				}
+			}
+		}
+	}
+
+	// Only instances with a SRV record can be used.
+	result := make([]GridService, 0, len(svcList))
+	for _, svc := range svcList {
+		if svc.DataPort != 0 {
+			result = append(result, *svc)
+		}
+	}
+	return result, nil
+}
+
+func (s *GridService) parseText(text []string) {
+	for _, txt := range text {
+		key, val, _ := strings.Cut(txt, "=")
+		switch key {
+		case "size":
+			fmt.Sscanf(val, "%dx%d", &s.Size.X, &s.Size.Y)
+		case "modules":
+			s.Modules, _ = strconv.Atoi(val)
+		case "tcp":
+			port, _ := strconv.ParseUint(val, 10, 16)
+			s.TCPPort = uint(port)
+		case "rpc":
+			port, _ := strconv.ParseUint(val, 10, 16)
+			s.RPCPort = uint(port)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ledgrid
+
+import (
+	"image"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDNSMessage(t *testing.T) {
+	in := dnsMessage{ID: 42, Response: true, Answers: []dnsRecord{
+		{Name: mdnsServiceName, Type: dnsTypePTR, TTL: 120, Target: "grid." + mdnsServiceName},
+		{Name: "grid." + mdnsServiceName, Type: dnsTypeSRV, TTL: 120, Target: "raspi.local.", Port: 5333},
+		{Name: "grid." + mdnsServiceName, Type: dnsTypeTXT, TTL: 120, Text: []string{"size=40x20", "rpc=5332"}},
+		{Name: "raspi.local.", Type: dnsTypeA, TTL: 120, Addr: netip.MustParseAddr("10.0.0.7")},
+	}}
+	out, err := parseDNSMessage(in.pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != 42 || !out.Response || len(out.Answers) != 4 {
+		t.Fatalf("unexpected message: %+v", out)
+	}
+	if rr := out.Answers[1]; rr.Port != 5333 || rr.Target != "raspi.local." {
+		t.Errorf("SRV record: %+v", rr)
+	}
+	if rr := out.Answers[3]; rr.Addr != in.Answers[3].Addr {
+		t.Errorf("A record: %+v", rr)
+	}
+
+	// Komprimierte Namen verweisen auf einen frueheren Teil der Meldung.
+	msg := (&dnsMessage{Questions: []dnsQuestion{{Name: mdnsServiceName, Type: dnsTypePTR}}}).pack()
+	msg[7] = 1
+	msg = append(msg, 0xc0, dnsHdrSize, 0, dnsTypePTR, 0, 1, 0, 0, 0, 60, 0, 7,
+		4, 'g', 'r', 'i', 'd', 0xc0, dnsHdrSize)
+	out, err = parseDNSMessage(msg)
+	if err != nil || out.Answers[0].Target != "grid."+mdnsServiceName {
+		t.Errorf("compressed name: %+v, %v", out, err)
+	}
+	if _, err = parseDNSMessage(msg[:len(msg)-3]); err == nil {
+		t.Error("expected error for truncated message")
+	}
+}
+
+// Der Responder wird hier ohne Multicast direkt angefragt (legacy unicast).
+func TestDiscoverUnicast(t *testing.T) {
+	srvConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := newMDNSResponder(srvConn, "grid.test", testModConf(image.Point{40, 20}), 5333, 5334, 5332)
+	go resp.handle()
+	defer srvConn.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	svcList, err := discover(conn, srvConn.LocalAddr().(*net.UDPAddr).AddrPort(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GridService{Instance: "grid-test", Host: resp.host,
+		Addr: netip.MustParseAddr("127.0.0.1"), DataPort: 5334, TCPPort: 5333, RPCPort: 5332,
+		Size: image.Point{40, 20}, Modules: 8}
+	if len(svcList) != 1 || svcList[0] != want {
+		t.Errorf("expected %+v, got %+v", want, svcList)
+	}
+}
+
+func TestDiscoverMulticast(t *testing.T) {
+	server, err := NewGridServer(0, 0, 0, newTestDisplayer(testModConf(image.Point{20, 10})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err = server.EnableMDNS("ledgrid-test"); err != nil {
+		t.Skipf("multicast not available: %v", err)
+	}
+	server.HandleEvents()
+
+	svcList, err := Discover(500 * time.Millisecond)
+	if err != nil {
+		t.Skipf("multicast not available: %v", err)
+	}
+	for _, svc := range svcList {
+		if svc.Instance == "ledgrid-test" {
+			if port := server.dataListener.Addr().(*net.TCPAddr).Port; svc.DataPort != uint(port) {
+				t.Errorf("expected port %d, got %d", port, svc.DataPort)
+			}
+			return
+		}
+	}
+	t.Skipf("no multicast loopback, found %v", svcList)
+}
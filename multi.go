@@ -0,0 +1,364 @@
+package ledgrid
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// Bei grossen Waenden begrenzt die Laenge einer einzelnen Kette die
+// Bildrate. Der MultiDisplayer verteilt die Kette einer Modul-Konfiguration
+// daher auf mehrere Displayer (bspw. je einen WS2801 an SPI0 und SPI1 oder
+// einen entfernten GridServer, siehe ClientDisplayer). Jeder Displayer
+// erhaelt eine zusammenhaengende Folge von Modulen; die Bilddaten werden
+// von je einer eigenen Goroutine parallel gesendet.
+//
+// Gamma-Werte, maximale Helligkeiten und der Status der einzelnen LEDs
+// werden beim MultiDisplayer gesetzt und an die Displayer weitergegeben,
+// Indizes werden dabei in die Kette des jeweiligen Displayers umgerechnet.
+// Die Farbreihenfolge und allfaellige Weisspunkte bleiben Sache der
+// einzelnen Displayer.
+type MultiDisplayer struct {
+	DisplayEmbed
+	outputs  []*multiOutput
+	powerCfg PowerConfig
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	closed   bool
+}
+
+type multiOutput struct {
+	disp     Displayer
+	start    int
+	firstMod int
+	numMods  int
+	frames   chan []byte
+}
+
+// Erstellt einen neuen MultiDisplayer fuer die Modul-Konfiguration modConf.
+// Die Displayer in outputs muessen zusammen genau die Module von modConf
+// abdecken, und zwar in der Reihenfolge der Kette: die Modul-Konfiguration
+// jedes Displayers muss dem entsprechenden Teil von SplitModuleConfig
+// entsprechen.
+func NewMultiDisplayer(modConf conf.ModuleConfig, outputs ...Displayer) (*MultiDisplayer, error) {
+	var numLeds, numMods int
+
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("%w: no outputs", conf.ErrBadConfig)
+	}
+	p := &MultiDisplayer{}
+	modCounts := make([]int, len(outputs))
+	for i, disp := range outputs {
+		out := &multiOutput{
+			disp:     disp,
+			start:    numLeds,
+			firstMod: numMods,
+			numMods:  len(disp.ModuleConfig()),
+			frames:   make(chan []byte),
+		}
+		p.outputs = append(p.outputs, out)
+		modCounts[i] = out.numMods
+		numLeds += disp.NumLeds()
+		numMods += out.numMods
+	}
+	if numLeds != modConf.NumLeds() || numMods != len(modConf) {
+		return nil, fmt.Errorf("%w: outputs have %d LEDs, configuration has %d",
+			conf.ErrBadConfig, numLeds, modConf.NumLeds())
+	}
+	for i, part := range SplitModuleConfig(modConf, modCounts...) {
+		if !slices.Equal(outputs[i].ModuleConfig(), part) {
+			return nil, fmt.Errorf("%w: output %d doesn't match modules %d to %d",
+				conf.ErrBadConfig, i, p.outputs[i].firstMod,
+				p.outputs[i].firstMod+len(part)-1)
+		}
+	}
+	p.DisplayEmbed.Init(p, numLeds)
+	p.DisplayEmbed.SetModuleConfig(modConf)
+	p.SetGamma(p.DefaultGamma())
+	p.powerCfg = DefaultPowerConfig()
+	for _, out := range p.outputs {
+		go p.sendLoop(out)
+	}
+	return p, nil
+}
+
+// Teilt die Modul-Konfiguration modConf in Teile mit je numMods[i] Modulen
+// auf. Die Indizes der Teile beginnen wieder bei 0, womit jeder Teil direkt
+// fuer die Erstellung eines Displayers verwendet werden kann. Die restlichen
+// Module kommen in den letzten Teil.
+func SplitModuleConfig(modConf conf.ModuleConfig, numMods ...int) []conf.ModuleConfig {
+	var parts []conf.ModuleConfig
+	var start int
+
+	for i, n := range numMods {
+		end := min(start+n, len(modConf))
+		if i == len(numMods)-1 {
+			end = len(modConf)
+		}
+		part := make(conf.ModuleConfig, end-start)
+		copy(part, modConf[start:end])
+		idx := 0
+		for j := range part {
+			part[j].Idx = idx
+			idx += part[j].NumLeds()
+		}
+		parts = append(parts, part)
+		start = end
+	}
+	return parts
+}
+
+// Sendet die Bilddaten eines Displayers.
+func (p *MultiDisplayer) sendLoop(out *multiOutput) {
+	for buffer := range out.frames {
+		out.disp.Display(buffer)
+		p.wg.Done()
+	}
+}
+
+// Die Gamma-Werte des ersten Displayers werden fuer alle uebernommen.
+func (p *MultiDisplayer) DefaultGamma() (r, g, b float64) {
+	return p.outputs[0].disp.DefaultGamma()
+}
+
+func (p *MultiDisplayer) SetGamma(r, g, b float64) {
+	p.DisplayEmbed.SetGamma(r, g, b)
+	for _, out := range p.outputs {
+		out.disp.SetGamma(r, g, b)
+	}
+}
+
+// Gibt die maximalen Helligkeiten an alle Displayer weiter (siehe
+// BrightnessLimiter).
+func (p *MultiDisplayer) SetMaxValue(r, g, b uint8) {
+	for _, out := range p.outputs {
+		if lim, ok := out.disp.(BrightnessLimiter); ok {
+			lim.SetMaxValue(r, g, b)
+		}
+	}
+}
+
+func (p *MultiDisplayer) SetDithering(on bool) {
+	p.DisplayEmbed.SetDithering(on)
+	for _, out := range p.outputs {
+		if disp, ok := out.disp.(interface{ SetDithering(on bool) }); ok {
+			disp.SetDithering(on)
+		}
+	}
+}
+
+func (p *MultiDisplayer) SetPixelStatus(idx int, stat LedStatusType) {
+	p.DisplayEmbed.SetPixelStatus(idx, stat)
+	for _, out := range p.outputs {
+		if idx >= out.start && idx < out.start+out.disp.NumLeds() {
+			out.disp.SetPixelStatus(idx-out.start, stat)
+			return
+		}
+	}
+}
+
+func (p *MultiDisplayer) PowerConfig() PowerConfig {
+	return p.powerCfg
+}
+
+// Die Strombegrenzung wird von den einzelnen Displayern vorgenommen. Das
+// Budget der ganzen Kette wird dazu proportional zur Anzahl LEDs
+// aufgeteilt, ebenso dasjenige von Segmenten, welche sich ueber mehrere
+// Displayer erstrecken.
+func (p *MultiDisplayer) SetPowerConfig(cfg PowerConfig) error {
+	if err := cfg.verify(len(p.ModConf)); err != nil {
+		return err
+	}
+	for _, out := range p.outputs {
+		lim, ok := out.disp.(PowerLimiter)
+		if !ok {
+			continue
+		}
+		first := out.firstMod
+		outCfg := cfg
+		outCfg.Budget = cfg.Budget * float64(out.disp.NumLeds()) /
+			float64(p.ModConf.NumLeds())
+		outCfg.Segments = nil
+		for _, seg := range cfg.Segments {
+			start := max(seg.Module, first)
+			end := min(seg.Module+seg.NumModules, first+out.numMods)
+			if start >= end {
+				continue
+			}
+			outCfg.Segments = append(outCfg.Segments, PowerSegment{
+				Module:     start - first,
+				NumModules: end - start,
+				Budget: seg.Budget * float64(p.ModConf[start:end].NumLeds()) /
+					float64(p.ModConf[seg.Module:seg.Module+seg.NumModules].NumLeds()),
+			})
+		}
+		if err := lim.SetPowerConfig(outCfg); err != nil {
+			return err
+		}
+	}
+	p.powerCfg = cfg
+	return nil
+}
+
+// Liefert die Summe der Werte aller Displayer; die Werte der Segmente
+// werden nicht zusammengefasst.
+func (p *MultiDisplayer) PowerStats() PowerStats {
+	var stats PowerStats
+
+	for _, out := range p.outputs {
+		if lim, ok := out.disp.(PowerLimiter); ok {
+			outStats := lim.PowerStats()
+			stats.Requested += outStats.Requested
+			stats.Drawn += outStats.Drawn
+		}
+	}
+	stats.Budget = p.powerCfg.Budget
+	return stats
+}
+
+// Die Eintraege der Kalibrierung werden den Displayern zugeteilt, welche
+// die entsprechenden Module ansteuern.
+func (p *MultiDisplayer) SetCalibration(cal conf.Calibration) error {
+	if err := cal.Verify(p.ModConf); err != nil {
+		return err
+	}
+	for _, out := range p.outputs {
+		disp, ok := out.disp.(Calibrator)
+		if !ok {
+			continue
+		}
+		var outCal conf.Calibration
+		modConf := out.disp.ModuleConfig()
+		has := func(place conf.ModulePosition) bool {
+			return slices.ContainsFunc(modConf, place.SamePlace)
+		}
+		for _, mc := range cal.Modules {
+			if has(mc.Place()) {
+				outCal.Modules = append(outCal.Modules, mc)
+			}
+		}
+		for _, lc := range cal.Leds {
+			if has(lc.Place()) {
+				outCal.Leds = append(outCal.Leds, lc)
+			}
+		}
+		if err := disp.SetCalibration(outCal); err != nil {
+			return err
+		}
+	}
+	p.calib = cal
+	return nil
+}
+
+// Eine neue Modul-Konfiguration wird mit der bisherigen Anzahl Module pro
+// Displayer aufgeteilt.
+func (p *MultiDisplayer) SetModuleConfig(cnf conf.ModuleConfig) {
+	numMods := make([]int, len(p.outputs))
+	for i, out := range p.outputs {
+		numMods[i] = out.numMods
+	}
+	p.DisplayEmbed.SetModuleConfig(cnf)
+	for i, part := range SplitModuleConfig(cnf, numMods...) {
+		p.outputs[i].disp.SetModuleConfig(part)
+	}
+}
+
+// Teilt die Bilddaten auf die Displayer auf und wartet, bis alle ihren
+// Teil gesendet haben. Gamma-Korrektur und Pixel-Status werden von den
+// einzelnen Displayern angewendet. Nach Close werden die Bilddaten
+// ignoriert.
+func (p *MultiDisplayer) Display(buffer []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.wg.Add(len(p.outputs))
+	for _, out := range p.outputs {
+		start := min(3*out.start, len(buffer))
+		end := min(3*(out.start+out.disp.NumLeds()), len(buffer))
+		out.frames <- buffer[start:end:end]
+	}
+	p.wg.Wait()
+}
+
+// Wird nur aufgerufen, wenn bereits aufbereitete Daten (3 Bytes pro LED)
+// direkt gesendet werden sollen.
+func (p *MultiDisplayer) Send(buffer []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	for _, out := range p.outputs {
+		start := min(3*out.start, len(buffer))
+		end := min(3*(out.start+out.disp.NumLeds()), len(buffer))
+		out.disp.Send(buffer[start:end:end])
+	}
+}
+
+// Beendet die Goroutinen und schliesst alle Displayer. Ein laufendes
+// Display wird vorher noch abgeschlossen.
+func (p *MultiDisplayer) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, out := range p.outputs {
+		close(out.frames)
+		out.disp.Close()
+	}
+}
+
+// Mit dem ClientDisplayer kann ein entfernter GridServer als Teil eines
+// MultiDisplayers verwendet werden. Die Gamma-Korrektur wird vom Server
+// vorgenommen, defekte LEDs werden bereits hier ausgeblendet. Fehlende LEDs
+// muessen beim Server selber konfiguriert sein.
+type ClientDisplayer struct {
+	DisplayEmbed
+	client GridClient
+	gamma  [3]float64
+}
+
+// Erstellt einen Displayer, welcher die Bilddaten via client sendet.
+func NewClientDisplayer(client GridClient) *ClientDisplayer {
+	p := &ClientDisplayer{client: client}
+	p.gamma[0], p.gamma[1], p.gamma[2] = client.Gamma()
+	p.DisplayEmbed.Init(p, client.NumLeds())
+	p.DisplayEmbed.SetModuleConfig(client.ModuleConfig())
+	return p
+}
+
+func (p *ClientDisplayer) DefaultGamma() (r, g, b float64) {
+	return p.gamma[0], p.gamma[1], p.gamma[2]
+}
+
+func (p *ClientDisplayer) SetGamma(r, g, b float64) {
+	p.DisplayEmbed.SetGamma(r, g, b)
+	p.client.SetGamma(r, g, b)
+}
+
+func (p *ClientDisplayer) Display(buffer []byte) {
+	copy(p.buffer, buffer)
+	for idx, stat := range p.statusList {
+		if stat == LedDefect && 3*idx+3 <= len(p.buffer) {
+			clear(p.buffer[3*idx : 3*idx+3])
+		}
+	}
+	p.Send(p.buffer)
+}
+
+func (p *ClientDisplayer) Send(buffer []byte) {
+	if err := p.client.Send(buffer); err != nil {
+		log.Printf("Couldn't send data: %v", err)
+	}
+}
+
+func (p *ClientDisplayer) Close() {
+	p.client.Close()
+}
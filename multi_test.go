@@ -0,0 +1,54 @@
+package ledgrid
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+func TestMultiDisplayer(t *testing.T) {
+	modConf := testModConf(image.Point{30, 10})
+	parts := SplitModuleConfig(modConf, 1, 2)
+	if len(parts[0]) != 1 || len(parts[1]) != 2 || parts[1][1].Idx != 100 {
+		t.Fatalf("bad split: %v", parts)
+	}
+	disp1, disp2 := newTestDisplayer(parts[0]), newTestDisplayer(parts[1])
+	disp, err := NewMultiDisplayer(modConf, disp1, disp2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMultiDisplayer(modConf, disp1); err == nil {
+		t.Error("expected error for too few LEDs")
+	}
+	// Gleich viele LEDs, aber nicht die Module des zweiten Teils.
+	other := newTestDisplayer(testModConf(image.Point{20, 10}))
+	if _, err := NewMultiDisplayer(modConf, disp1, other); !errors.Is(err, conf.ErrBadConfig) {
+		t.Errorf("expected ErrBadConfig for mismatching modules, got %v", err)
+	}
+
+	// Gamma und Status werden an die Displayer weitergegeben.
+	disp.SetGamma(2.0, 2.0, 2.0)
+	if r, _, _ := disp2.Gamma(); r != 2.0 {
+		t.Errorf("gamma not forwarded: %v", r)
+	}
+	disp.SetGamma(1.0, 1.0, 1.0)
+	disp.SetPixelStatus(101, LedDefect)
+
+	frame := testFrame(3*disp.NumLeds(), 1)
+	disp.Display(frame)
+	b1, b2 := <-disp1.frames, <-disp2.frames
+	if !bytes.Equal(b1, frame[:300]) {
+		t.Errorf("first output got wrong data")
+	}
+	if !bytes.Equal(b2[:3], frame[300:303]) || !bytes.Equal(b2[3:6], []byte{0, 0, 0}) {
+		t.Errorf("second output got %x", b2[:6])
+	}
+	disp.Close()
+
+	// Verspaetete Bilder nach Close werden ignoriert.
+	disp.Display(frame)
+	disp.Close()
+}
@@ -1,78 +1,242 @@
-//go:build ignore
-
 package ledgrid
 
 import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/netip"
+	"sync"
+	"time"
 )
 
-var (
-	DispList []Displayer
-)
+// Besides the native protocol, the GridServer can act as an Open Pixel
+// Control (OPC) server (see http://openpixelcontrol.org). Every message
+// consists of a 4 byte header (channel, command, length) followed by the
+// data:
+//
+//	+---------+---------+---------+---------+--------------------+
+//	| Channel | Command | Length (2, big e.)| Data (Length bytes)|
+//	+---------+---------+---------+---------+--------------------+
+//
+// Channel 0 is a broadcast to all channels, channels 1..255 are routed to
+// the displayers registered with RegisterOPCChannel.
+const (
+	DefOPCPort = 7890
 
-func init() {
-	DispList = make([]Displayer, 64)
-}
+	OPCBroadcast       = 0
+	OPCSetPixelColors  = 0x00
+	OPCSystemExclusive = 0xff
 
-func RegisterDisplayer(ch int, disp Displayer) {
-	DispList[ch] = disp
-}
+	// System IDs for the system exclusive command. Only the global color
+	// correction of the Fadecandy is supported.
+	OPCSysIDFadecandy           = 0x0001
+	OPCFadecandyColorCorrection = 0x0001
+
+	opcHeaderSize = 4
+)
 
+// Message is a single, decoded OPC message.
 type Message struct {
 	Channel, Command byte
 	Length           uint16
 	Data             []byte
 }
 
-func NewMessage(b []byte) *Message {
+// Reads one OPC message from r. The data is read into buf which must have
+// room for at least 65535 bytes.
+func ReadOPCMessage(r io.Reader, buf []byte) (*Message, error) {
+	var hdr [opcHeaderSize]byte
+
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
 	m := &Message{}
-	m.Channel = b[0]
-	m.Command = b[1]
-	m.Length = (uint16(b[2]) << 8) | uint16(b[3])
-	m.Data = make([]byte, m.Length)
-	copy(m.Data, b[4:])
-	return m
+	m.Channel = hdr[0]
+	m.Command = hdr[1]
+	m.Length = binary.BigEndian.Uint16(hdr[2:4])
+	m.Data = buf[:m.Length]
+	if _, err := io.ReadFull(r, m.Data); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func HandleOPC(port uint) {
-	hostPort := fmt.Sprintf(":%d", port)
-	l, err := net.Listen("tcp", hostPort)
+// Writes the OPC message m to w.
+func WriteOPCMessage(w io.Writer, m *Message) error {
+	b := make([]byte, opcHeaderSize+len(m.Data))
+	b[0] = m.Channel
+	b[1] = m.Command
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(m.Data)))
+	copy(b[opcHeaderSize:], m.Data)
+	_, err := w.Write(b)
+	return err
+}
+
+// Each OPC channel has its own displayer and frame buffer. The frame buffer
+// is needed because OPC clients may send fewer pixels than the displayer
+// has - the remaining pixels keep their previous color. As the buffer is
+// shared by all connections, it is protected by mutex.
+type opcChannel struct {
+	disp   Displayer
+	mutex  sync.Mutex
+	buffer []byte
+}
+
+// Opens the TCP port for OPC clients. Must be called before HandleEvents.
+// Channel 1 is routed to the displayer of the server by default.
+func (p *GridServer) EnableOPC(opcPort uint) error {
+	var err error
+
+	addrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(opcPort))
+	p.opcListener, err = net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(addrPort))
 	if err != nil {
-		log.Fatalf("Failed Listen(): %v", err)
+		return err
+	}
+	if p.opcChannels[1] == nil {
+		p.RegisterOPCChannel(1, p.Disp)
+	}
+	return nil
+}
+
+// Routes the OPC channel ch to the displayer disp. Channel 0 is reserved
+// for broadcasts and can't be registered. Use a nil displayer to remove a
+// registration.
+func (p *GridServer) RegisterOPCChannel(ch uint8, disp Displayer) {
+	if ch == OPCBroadcast {
+		log.Printf("OPC channel %d is reserved for broadcasts", OPCBroadcast)
+		return
 	}
-	defer l.Close()
+	if disp == nil {
+		p.opcChannels[ch] = nil
+		return
+	}
+	p.opcChannels[ch] = &opcChannel{disp: disp,
+		buffer: make([]byte, 3*disp.NumLeds())}
+}
+
+// Accepts connections from OPC clients.
+func (p *GridServer) HandleOPC(lsnr *net.TCPListener) {
 	for {
-		conn, err := l.Accept()
+		conn, err := lsnr.Accept()
 		if err != nil {
-			log.Fatalf("Failed Accept(): %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			log.Printf("Failed OPC Accept(): %v", err)
+			continue
 		}
-		go HandleMessage(conn)
+		go p.HandleOPCMessage(conn)
 	}
 }
 
-func HandleMessage(conn net.Conn) {
+// Reads and processes OPC messages from conn until the client closes the
+// connection. Frames for the displayer of the server pass the arbiter (see
+// arbiter.go) as a source with the default priority; frames for other
+// displayers are shown directly.
+func (p *GridServer) HandleOPCMessage(conn net.Conn) {
 	var buffer []byte
 
 	defer conn.Close()
-	buffer = make([]byte, 65539)
+	src := p.OpenSource("opc:"+conn.RemoteAddr().String(), DefPriority)
+	defer src.Close()
+	rd := bufio.NewReader(conn)
+	buffer = make([]byte, 1<<16)
 	for {
-		_, err := conn.Read(buffer)
+		m, err := ReadOPCMessage(rd, buffer)
 		if err != nil {
-			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-				return
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				log.Printf("Failed to read OPC message: %v", err)
 			}
-			log.Fatalf("Failed Read(): %v", err)
+			return
+		}
+		p.countRecv(opcHeaderSize + len(m.Data))
+		switch m.Command {
+		case OPCSetPixelColors:
+			p.opcSetPixelColors(src, m)
+		case OPCSystemExclusive:
+			p.opcSystemExclusive(m)
+		default:
+			log.Printf("Unknown OPC command %d on channel %d", m.Command, m.Channel)
+		}
+	}
+}
+
+func (p *GridServer) opcSetPixelColors(src *FrameSource, m *Message) {
+	if m.Channel != OPCBroadcast {
+		if ch := p.opcChannels[m.Channel]; ch != nil {
+			p.opcShow(src, ch, m.Data)
+		}
+		return
+	}
+	for _, ch := range p.opcChannels {
+		if ch != nil {
+			p.opcShow(src, ch, m.Data)
+		}
+	}
+}
+
+func (p *GridServer) opcShow(src *FrameSource, ch *opcChannel, data []byte) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	n := copy(ch.buffer, data[:len(data)-len(data)%3])
+	if ch.disp == p.Disp {
+		src.Display(ch.buffer)
+		return
+	}
+	// The statistics of the server are protected by the mutex of the
+	// arbiter, which must not be held while another displayer is busy.
+	t := time.Now()
+	ch.disp.Display(ch.buffer)
+	d := time.Since(t)
+	p.withDisplay(func() {
+		p.stopwatch.Add(d)
+		p.sentBytes += ByteCount(n)
+	})
+}
+
+// The color correction of the Fadecandy is a JSON object. Only the gamma
+// value is used, the other fields are ignored. The gamma of the displayer of
+// the server is set with GridServer.SetGamma, the one of other displayers
+// with the mutex of their channel held, as they may be busy with a frame.
+type fadecandyColorCorrection struct {
+	Gamma      float64   `json:"gamma"`
+	Whitepoint []float64 `json:"whitepoint"`
+}
+
+func (p *GridServer) opcSystemExclusive(m *Message) {
+	var cc fadecandyColorCorrection
+
+	if len(m.Data) < 4 {
+		log.Printf("OPC system exclusive message too short")
+		return
+	}
+	sysID := binary.BigEndian.Uint16(m.Data[0:2])
+	cmdID := binary.BigEndian.Uint16(m.Data[2:4])
+	if sysID != OPCSysIDFadecandy || cmdID != OPCFadecandyColorCorrection {
+		log.Printf("Unsupported OPC system exclusive message (system %#04x, command %#04x)", sysID, cmdID)
+		return
+	}
+	if err := json.Unmarshal(m.Data[4:], &cc); err != nil {
+		log.Printf("Couldn't decode color correction: %v", err)
+		return
+	}
+	if cc.Gamma <= 0.0 {
+		return
+	}
+	for i, ch := range p.opcChannels {
+		if ch == nil || (m.Channel != OPCBroadcast && i != int(m.Channel)) {
+			continue
 		}
-		m := NewMessage(buffer)
-		disp := DispList[m.Channel]
-		if disp == nil {
-			log.Printf("No displayer on channel %d\n", m.Channel)
+		if ch.disp == p.Disp {
+			p.SetGamma(cc.Gamma, cc.Gamma, cc.Gamma)
 			continue
 		}
-		disp.Display(m.Data)
+		ch.mutex.Lock()
+		ch.disp.SetGamma(cc.Gamma, cc.Gamma, cc.Gamma)
+		ch.mutex.Unlock()
 	}
 }
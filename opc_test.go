@@ -1,12 +1,141 @@
-//go:build ignore
-
-// opc_test.go
 package ledgrid
 
 import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"net"
+	"sync"
 	"testing"
 )
 
 func TestOPC(t *testing.T) {
-	HandleOPC()
+	disp1 := newTestDisplayer(testModConf(image.Point{20, 10}))
+	disp2 := newTestDisplayer(testModConf(image.Point{10, 10}))
+	server := newTestServer(disp1)
+	server.RegisterOPCChannel(1, disp1)
+	server.RegisterOPCChannel(2, disp2)
+	srvConn, cltConn := net.Pipe()
+	go server.HandleOPCMessage(srvConn)
+
+	// Volles Bild auf Kanal 1.
+	frame := testFrame(3*disp1.NumLeds(), 3)
+	WriteOPCMessage(cltConn, &Message{Channel: 1, Command: OPCSetPixelColors,
+		Data: frame})
+	expectFrame(t, disp1, frame)
+
+	// Nur die ersten 10 Pixel auf Kanal 2, der Rest bleibt schwarz.
+	part := testFrame(30, 9)
+	WriteOPCMessage(cltConn, &Message{Channel: 2, Command: OPCSetPixelColors,
+		Data: part})
+	frame = make([]byte, 3*disp2.NumLeds())
+	copy(frame, part)
+	expectFrame(t, disp2, frame)
+
+	// Broadcast an alle Kanaele.
+	part = testFrame(60, 1)
+	WriteOPCMessage(cltConn, &Message{Channel: OPCBroadcast,
+		Command: OPCSetPixelColors, Data: part})
+	for _, disp := range []*testDisplayer{disp1, disp2} {
+		got := <-disp.frames
+		if !bytes.Equal(got[:len(part)], part) {
+			t.Errorf("broadcast not received by all channels")
+		}
+	}
+
+	// Farbkorrektur im Stil des Fadecandy.
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:2], OPCSysIDFadecandy)
+	binary.BigEndian.PutUint16(data[2:4], OPCFadecandyColorCorrection)
+	data = append(data, []byte(`{"gamma": 2.2, "whitepoint": [1.0, 1.0, 1.0]}`)...)
+	WriteOPCMessage(cltConn, &Message{Channel: 2, Command: OPCSystemExclusive,
+		Data: data})
+	// Das naechste Bild dient der Synchronisation mit dem Server.
+	WriteOPCMessage(cltConn, &Message{Channel: 2, Command: OPCSetPixelColors,
+		Data: part})
+	<-disp2.frames
+	cltConn.Close()
+	if r, _, _ := disp2.Gamma(); r != 2.2 {
+		t.Errorf("expected gamma 2.2 on channel 2, got %.1f", r)
+	}
+	if r, _, _ := disp1.Gamma(); r != 1.0 {
+		t.Errorf("gamma on channel 1 must not change, got %.1f", r)
+	}
+}
+
+// Zwei Clients senden gleichzeitig auf den gleichen Kanal; jedes angezeigte
+// Bild muss vollstaendig von einem der beiden stammen. Parallel dazu zeigt
+// eine weitere Quelle Bilder auf dem Displayer des Servers an, die Statistik
+// muss alle Bilder beider Displayer enthalten.
+func TestOPCConcurrent(t *testing.T) {
+	disp1 := newTestDisplayer(testModConf(image.Point{20, 10}))
+	disp2 := newTestDisplayer(testModConf(image.Point{10, 10}))
+	server := newTestServer(disp1)
+	server.RegisterOPCChannel(2, disp2)
+
+	const numFrames = 50
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		src := server.OpenSource("tcp:test", DefPriority)
+		for range numFrames {
+			src.Display(testFrame(3*disp1.NumLeds(), 1))
+			<-disp1.frames
+		}
+	}()
+	for id := range 2 {
+		go func() {
+			defer wg.Done()
+			src := server.OpenSource("opc:test", DefPriority)
+			defer src.Close()
+			frame := bytes.Repeat([]byte{byte(id + 1)}, 3*disp2.NumLeds())
+			for range numFrames {
+				server.opcSetPixelColors(src, &Message{Channel: 2,
+					Command: OPCSetPixelColors, Data: frame})
+			}
+		}()
+	}
+	for range 2 * numFrames {
+		frame := <-disp2.frames
+		if bytes.Count(frame, frame[:1]) != len(frame) {
+			t.Fatalf("frame mixes the data of both clients")
+		}
+	}
+	wg.Wait()
+	sent := ByteCount(numFrames * 3 * (disp1.NumLeds() + 2*disp2.NumLeds()))
+	if n := server.SentBytes(); n != sent {
+		t.Errorf("expected %d bytes sent, got %d", sent, n)
+	}
+}
+
+// Die Farbkorrektur fuer den Displayer des Servers darf nicht mit einem
+// angezeigten Bild einer anderen Quelle kollidieren (mit -race ausfuehren).
+func TestOPCGammaConcurrent(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	server.RegisterOPCChannel(1, disp)
+
+	const numFrames = 20
+	done := make(chan struct{})
+	go func() {
+		src := server.OpenSource("tcp:test", DefPriority)
+		for range numFrames {
+			src.Display(testFrame(3*disp.NumLeds(), 1))
+			<-disp.frames
+		}
+		close(done)
+	}()
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:2], OPCSysIDFadecandy)
+	binary.BigEndian.PutUint16(data[2:4], OPCFadecandyColorCorrection)
+	data = append(data, []byte(`{"gamma": 2.2}`)...)
+	for range numFrames {
+		server.opcSystemExclusive(&Message{Channel: 1,
+			Command: OPCSystemExclusive, Data: data})
+	}
+	<-done
+	if r, _, _ := server.Gamma(); r != 2.2 {
+		t.Errorf("expected gamma 2.2, got %.1f", r)
+	}
 }
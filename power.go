@@ -0,0 +1,262 @@
+package ledgrid
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// A panel with many NeoPixels easily exceeds the capacity of its power
+// supply when showing bright frames. The DisplayEmbed therefore estimates
+// the current of each frame after the gamma correction and scales the
+// frame down if the estimate exceeds the configured budget. The budget can
+// be set for the whole chain and additionally for segments of modules
+// which are powered by separate injection points.
+//
+// If a frame exceeds the budget, the scale factor is lowered immediately;
+// afterwards it rises slowly (see powerRelease) so that flashing content
+// doesn't result in flicker.
+
+const (
+	// Typical current of one color channel of a NeoPixel at full
+	// brightness (in mA).
+	DefChannelCurrent = 20.0
+	// Typical quiescent current of a NeoPixel (in mA).
+	DefIdleCurrent = 1.0
+	// Maximum increase of the scale factor per frame.
+	powerRelease = 0.02
+)
+
+// A segment of consecutive modules on the chain with a budget of its own.
+// Module is the position of the first module in the module configuration.
+type PowerSegment struct {
+	Module     int     `json:"module"`
+	NumModules int     `json:"numModules"`
+	Budget     float64 `json:"budget"`
+}
+
+// Configuration of the power limiter. ChannelCurrent is the current of the
+// red, green, blue and (on RGBW displayers) white channel at full
+// brightness, IdleCurrent the current of a dark NeoPixel, all in mA.
+// Budget is the maximum current of the whole chain (0: no limit).
+type PowerConfig struct {
+	ChannelCurrent [4]float64     `json:"channelCurrent"`
+	IdleCurrent    float64        `json:"idleCurrent"`
+	Budget         float64        `json:"budget"`
+	Segments       []PowerSegment `json:"segments,omitempty"`
+}
+
+// Returns a configuration with typical currents and without a budget. The
+// current is estimated but never limited.
+func DefaultPowerConfig() PowerConfig {
+	return PowerConfig{
+		ChannelCurrent: [4]float64{DefChannelCurrent, DefChannelCurrent,
+			DefChannelCurrent, DefChannelCurrent},
+		IdleCurrent: DefIdleCurrent,
+	}
+}
+
+// Checks the configuration against a chain with numMods modules.
+func (cfg PowerConfig) verify(numMods int) error {
+	if cfg.Budget < 0 || cfg.IdleCurrent < 0 {
+		return fmt.Errorf("%w: negative values", ErrBadPowerConfig)
+	}
+	for _, cur := range cfg.ChannelCurrent {
+		if cur < 0 {
+			return fmt.Errorf("%w: negative values", ErrBadPowerConfig)
+		}
+	}
+	used := make([]bool, numMods)
+	for i, seg := range cfg.Segments {
+		if seg.Budget <= 0 || seg.NumModules <= 0 || seg.Module < 0 ||
+			seg.Module+seg.NumModules > numMods {
+			return fmt.Errorf("%w: segment %d is invalid", ErrBadPowerConfig, i)
+		}
+		for mod := seg.Module; mod < seg.Module+seg.NumModules; mod++ {
+			if used[mod] {
+				return fmt.Errorf("%w: segment %d overlaps another segment",
+					ErrBadPowerConfig, i)
+			}
+			used[mod] = true
+		}
+	}
+	return nil
+}
+
+// Estimated current (in mA) of the last frame. Requested is the current
+// the frame would have drawn, Drawn the one after the scaling. Segments
+// contains the values of the configured segments.
+type PowerStats struct {
+	Requested float64      `json:"requested"`
+	Drawn     float64      `json:"drawn"`
+	Budget    float64      `json:"budget"`
+	Segments  []PowerStats `json:"segments,omitempty"`
+}
+
+// Displayers which estimate and limit their current implement this
+// interface. DisplayEmbed provides an implementation.
+type PowerLimiter interface {
+	PowerConfig() PowerConfig
+	SetPowerConfig(cfg PowerConfig) error
+	PowerStats() PowerStats
+}
+
+// State of the power limiter of a DisplayEmbed. The segment with index
+// len(cfg.Segments) contains all LEDs outside of the configured segments.
+type powerLimiter struct {
+	cfg     PowerConfig
+	segTbl  []int
+	dstSeg  []int
+	sums    [][4]int
+	counts  []int
+	scale   []float64
+	active  []float64
+	factors []int
+	global  float64
+	mutex   sync.Mutex
+	stats   PowerStats
+}
+
+func newPowerLimiter(cfg PowerConfig, modConf conf.ModuleConfig, numLeds int) *powerLimiter {
+	l := &powerLimiter{cfg: cfg, global: 1.0}
+	l.stats.Budget = cfg.Budget
+	numSegs := len(cfg.Segments) + 1
+	l.segTbl = make([]int, numLeds)
+	l.dstSeg = make([]int, numLeds)
+	l.sums = make([][4]int, numSegs)
+	l.counts = make([]int, numSegs)
+	l.scale = make([]float64, numSegs)
+	l.active = make([]float64, numSegs)
+	l.factors = make([]int, numSegs)
+	for i := range l.scale {
+		l.scale[i] = 1.0
+	}
+	for i := range l.segTbl {
+		l.segTbl[i] = len(cfg.Segments)
+	}
+	for segIdx, seg := range cfg.Segments {
+		for _, m := range modConf[seg.Module : seg.Module+seg.NumModules] {
+			for i := m.Idx; i < m.Idx+m.NumLeds() && i < numLeds; i++ {
+				l.segTbl[i] = segIdx
+			}
+		}
+	}
+	return l
+}
+
+// Resets the sums at the beginning of a frame.
+func (l *powerLimiter) reset() {
+	clear(l.sums)
+	clear(l.counts)
+}
+
+// Adds the values of the LED with index srcIdx on the chain, which is
+// sent at position dstIdx.
+func (l *powerLimiter) add(srcIdx, dstIdx int, rgb [3]byte, w byte) {
+	seg := l.segTbl[srcIdx]
+	l.dstSeg[dstIdx] = seg
+	l.counts[seg]++
+	sum := &l.sums[seg]
+	sum[0] += int(rgb[0])
+	sum[1] += int(rgb[1])
+	sum[2] += int(rgb[2])
+	sum[3] += int(w)
+}
+
+// Computes the scale factor which keeps idle+scale*active within budget.
+func powerTarget(budget, idle, active float64) float64 {
+	if budget <= 0 || idle+active <= budget || active <= 0 {
+		return 1.0
+	}
+	return min(max((budget-idle)/active, 0.0), 1.0)
+}
+
+// Estimates the current of the frame in buffer (numLeds NeoPixels with
+// bpp bytes each) and scales it down if necessary. hwBright is the
+// brightness factor of the hardware (see DisplayEmbed.setLevels).
+func (l *powerLimiter) limit(buffer []byte, bpp, numLeds int, hwBright float64) {
+	var stats PowerStats
+	var idleTotal, activeTotal, segDrawn float64
+
+	numSegs := len(l.scale)
+	active := l.active
+	clear(active)
+	for seg := range numSegs {
+		idle := l.cfg.IdleCurrent * float64(l.counts[seg])
+		for c, sum := range l.sums[seg] {
+			active[seg] += l.cfg.ChannelCurrent[c] * float64(sum) / 255.0
+		}
+		active[seg] *= hwBright
+		budget := 0.0
+		if seg < len(l.cfg.Segments) {
+			budget = l.cfg.Segments[seg].Budget
+		}
+		l.scale[seg] = min(powerTarget(budget, idle, active[seg]),
+			l.scale[seg]+powerRelease)
+		idleTotal += idle
+		activeTotal += active[seg]
+		segDrawn += l.scale[seg] * active[seg]
+		if seg < len(l.cfg.Segments) {
+			stats.Segments = append(stats.Segments, PowerStats{
+				Requested: idle + active[seg],
+				Budget:    budget,
+			})
+		}
+	}
+	l.global = min(powerTarget(l.cfg.Budget, idleTotal, segDrawn),
+		l.global+powerRelease)
+
+	limited := l.global < 1.0
+	for seg := range numSegs {
+		if seg < len(stats.Segments) {
+			stats.Segments[seg].Drawn = l.cfg.IdleCurrent*float64(l.counts[seg]) +
+				l.global*l.scale[seg]*active[seg]
+		}
+		if l.scale[seg] < 1.0 {
+			limited = true
+		}
+	}
+	stats.Requested = idleTotal + activeTotal
+	stats.Drawn = idleTotal + l.global*segDrawn
+	stats.Budget = l.cfg.Budget
+
+	if limited {
+		factors := l.factors
+		for seg := range numSegs {
+			factors[seg] = int(256.0 * l.global * l.scale[seg])
+		}
+		for dstIdx := range numLeds {
+			f := factors[l.dstSeg[dstIdx]]
+			for i := bpp * dstIdx; i < bpp*dstIdx+bpp; i++ {
+				buffer[i] = byte(int(buffer[i]) * f >> 8)
+			}
+		}
+	}
+
+	l.mutex.Lock()
+	l.stats = stats
+	l.mutex.Unlock()
+}
+
+// See PowerConfig in interface PowerLimiter.
+func (d *DisplayEmbed) PowerConfig() PowerConfig {
+	return d.power.cfg
+}
+
+// See SetPowerConfig in interface PowerLimiter. Segments refer to the
+// positions of the modules in the current module configuration.
+func (d *DisplayEmbed) SetPowerConfig(cfg PowerConfig) error {
+	if err := cfg.verify(len(d.ModConf)); err != nil {
+		return err
+	}
+	d.power = newPowerLimiter(cfg, d.ModConf, d.numLeds)
+	return nil
+}
+
+// See PowerStats in interface PowerLimiter.
+func (d *DisplayEmbed) PowerStats() PowerStats {
+	d.power.mutex.Lock()
+	defer d.power.mutex.Unlock()
+	return d.power.stats
+}
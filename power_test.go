@@ -0,0 +1,100 @@
+package ledgrid
+
+import (
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestPowerLimiter(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	numLeds := disp.NumLeds()
+	white := make([]byte, 3*numLeds)
+	for i := range white {
+		white[i] = 0xff
+	}
+
+	// Ohne Budget wird der Strom nur geschaetzt.
+	disp.Display(white)
+	<-disp.frames
+	stats := disp.PowerStats()
+	if want := float64(numLeds) * (3*DefChannelCurrent + DefIdleCurrent); stats.Requested != want ||
+		stats.Drawn != want {
+		t.Errorf("expected %v mA, got %+v", want, stats)
+	}
+
+	cfg := DefaultPowerConfig()
+	cfg.Budget = 4200
+	if err := disp.SetPowerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	disp.Display(white)
+	b := <-disp.frames
+	stats = disp.PowerStats()
+	if math.Abs(stats.Drawn-4200) > 0.01 || b[0] != 84 || b[len(b)-1] != 84 {
+		t.Errorf("global budget: got %+v, first value %d", stats, b[0])
+	}
+
+	// Segment mit eigenem Budget auf dem ersten Modul.
+	cfg.Budget = 0
+	cfg.Segments = []PowerSegment{{Module: 0, NumModules: 1, Budget: 1000}}
+	if err := disp.SetPowerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	disp.Display(white)
+	b = <-disp.frames
+	stats = disp.PowerStats()
+	if len(stats.Segments) != 1 || math.Abs(stats.Segments[0].Drawn-1000) > 0.01 ||
+		b[0] != 37 || b[len(b)-1] != 0xff {
+		t.Errorf("segment budget: got %+v, values %d, %d", stats, b[0], b[len(b)-1])
+	}
+
+	cfg.Segments = []PowerSegment{{Module: 1, NumModules: 2, Budget: 1000}}
+	if err := disp.SetPowerConfig(cfg); !errors.Is(err, ErrBadPowerConfig) {
+		t.Errorf("expected ErrBadPowerConfig, got %v", err)
+	}
+}
+
+func TestMaxValue(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{10, 10}))
+	server := newTestServer(disp)
+	server.SetMaxValue(128, 64, 255)
+	frame := make([]byte, 3*disp.NumLeds())
+	frame[0], frame[1], frame[2] = 0xff, 0xff, 0xff
+	disp.Display(frame)
+	if b := <-disp.frames; b[0] != 128 || b[1] != 64 || b[2] != 255 {
+		t.Errorf("max value not applied: %d, %d, %d", b[0], b[1], b[2])
+	}
+}
+
+// Die Konfiguration kann via Server geaendert werden, waehrend Bilder
+// angezeigt werden.
+func TestPowerConfigConcurrent(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	cfg := DefaultPowerConfig()
+	cfg.Segments = []PowerSegment{{Module: 0, NumModules: 1, Budget: 1000}}
+
+	const numFrames = 100
+	done := make(chan bool)
+	go func() {
+		for range numFrames {
+			<-disp.frames
+		}
+		done <- true
+	}()
+	go func() {
+		for i := range numFrames {
+			cfg.Budget = float64(1000 + 100*i)
+			server.SetPowerConfig(cfg)
+			server.PowerStats()
+		}
+	}()
+	src := server.OpenSource("test", DefPriority)
+	frame := testFrame(3*disp.NumLeds(), 1)
+	for range numFrames {
+		src.Display(frame)
+	}
+	<-done
+}
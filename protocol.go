@@ -0,0 +1,147 @@
+package ledgrid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The data connection between a GridClient and a GridServer uses a simple
+// framed protocol. Every message starts with a fixed size header followed
+// by Length bytes of payload:
+//
+//	+-------+-------+-----+-----+-------+-------+-------+-------+
+//	| Magic (2)     | Ver | Cmd | Length (4)                    |
+//	+-------+-------+-----+-----+-------+-------+-------+-------+
+//	| Seq (4)                       | Payload (Length bytes)... |
+//	+-------+-------+-------+-------+---------------------------+
+//
+// All multi-byte fields are in network byte order (big endian). The framed
+// protocol is served on a port of its own (see DefDataPort). Legacy
+// clients, sending raw frames of 3*NumLeds() bytes, connect to DefTCPPort:
+// as a raw frame may start with any bytes, even with the magic number, the
+// two protocols can't be told apart on the same port.
+//
+// Version history:
+//
+//	1: CmdFrame contains the raw color values of all LEDs.
+//	2: The payload of CmdFrame (and the reassembled fragments over UDP)
+//	   starts with a FrameEncoding byte (see encoding.go).
+const (
+	ProtoMagic      uint16 = 0x4c47 // "LG"
+	ProtoVersion    uint8  = 2
+	ProtoHeaderSize        = 12
+	// Upper limit for the payload of a single message. Even the largest
+	// panels need less than this.
+	ProtoMaxLength = 1 << 24
+)
+
+// Command byte of the protocol header.
+type ProtoCommand uint8
+
+const (
+	// Sent by the client as the very first message. The version field
+	// contains the highest version the client speaks. The server answers
+	// with a CmdHello as well, containing the version which will be used on
+	// this connection. The payload of the client's hello may contain a
+	// single byte with the priority of the client (see Priority), otherwise
+	// DefPriority is used. Later hellos of the client change the priority
+	// and are not answered. Over UDP, hellos are never answered.
+	CmdHello ProtoCommand = iota
+	// The payload contains the color values of all LEDs in chain order.
+	CmdFrame
+	// The client closes the connection. The payload is empty.
+	CmdBye
+	// A part of a frame, used over UDP only (see udp.go).
+	CmdFragment
+)
+
+func (c ProtoCommand) String() string {
+	switch c {
+	case CmdHello:
+		return "Hello"
+	case CmdFrame:
+		return "Frame"
+	case CmdBye:
+		return "Bye"
+	case CmdFragment:
+		return "Fragment"
+	}
+	return fmt.Sprintf("ProtoCommand(%d)", uint8(c))
+}
+
+var (
+	ErrBadMagic    = errors.New("bad magic number in header")
+	ErrBadVersion  = errors.New("unsupported protocol version")
+	ErrBadLength   = errors.New("invalid payload length")
+	ErrBadSequence = errors.New("unexpected sequence number")
+)
+
+// ProtoHeader is the decoded form of a message header.
+type ProtoHeader struct {
+	Version uint8
+	Command ProtoCommand
+	Length  uint32
+	Seq     uint32
+}
+
+// Writes the header into b, which must be at least ProtoHeaderSize bytes
+// long.
+func (h ProtoHeader) Encode(b []byte) {
+	binary.BigEndian.PutUint16(b[0:2], ProtoMagic)
+	b[2] = h.Version
+	b[3] = byte(h.Command)
+	binary.BigEndian.PutUint32(b[4:8], h.Length)
+	binary.BigEndian.PutUint32(b[8:12], h.Seq)
+}
+
+// Decodes a header from b. Returns ErrBadMagic if b doesn't start with
+// the magic number and ErrBadLength if the length field is out of range.
+func (h *ProtoHeader) Decode(b []byte) error {
+	if binary.BigEndian.Uint16(b[0:2]) != ProtoMagic {
+		return ErrBadMagic
+	}
+	h.Version = b[2]
+	h.Command = ProtoCommand(b[3])
+	h.Length = binary.BigEndian.Uint32(b[4:8])
+	h.Seq = binary.BigEndian.Uint32(b[8:12])
+	if h.Length > ProtoMaxLength {
+		return ErrBadLength
+	}
+	return nil
+}
+
+// Writes a complete message (header and payload) with a single call to
+// w.Write. The Length field of hdr is set according to len(payload).
+func WriteMessage(w io.Writer, hdr ProtoHeader, payload []byte) error {
+	msg := make([]byte, ProtoHeaderSize+len(payload))
+	hdr.Length = uint32(len(payload))
+	hdr.Encode(msg)
+	copy(msg[ProtoHeaderSize:], payload)
+	_, err := w.Write(msg)
+	return err
+}
+
+// Reads a complete message from r. The payload is read into buf, if it is
+// large enough, otherwise a new slice is allocated. The returned payload is
+// always a sub slice of the buffer actually used.
+func ReadMessage(r io.Reader, buf []byte) (ProtoHeader, []byte, error) {
+	var hdr ProtoHeader
+	var b [ProtoHeaderSize]byte
+
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return hdr, nil, err
+	}
+	if err := hdr.Decode(b[:]); err != nil {
+		return hdr, nil, err
+	}
+	if int(hdr.Length) > cap(buf) {
+		buf = make([]byte, hdr.Length)
+	}
+	buf = buf[:hdr.Length]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return hdr, nil, err
+	}
+	return hdr, buf, nil
+}
@@ -0,0 +1,174 @@
+package ledgrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	var b [ProtoHeaderSize]byte
+	var out ProtoHeader
+
+	in := ProtoHeader{Version: ProtoVersion, Command: CmdFrame,
+		Length: 1200, Seq: 0xdeadbeef}
+	in.Encode(b[:])
+	if err := out.Decode(b[:]); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if in != out {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+	b[0] = 0x00
+	if err := out.Decode(b[:]); err != ErrBadMagic {
+		t.Errorf("expected ErrBadMagic, got %v", err)
+	}
+}
+
+func expectFrame(t *testing.T, disp *testDisplayer, frame []byte) {
+	t.Helper()
+	select {
+	case got := <-disp.frames:
+		if !bytes.Equal(got, frame) {
+			t.Errorf("received frame differs from sent frame")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no frame received")
+	}
+}
+
+func TestFramedProtocol(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	srvConn, cltConn := net.Pipe()
+	go server.HandleMessage(srvConn)
+
+	err := WriteMessage(cltConn, ProtoHeader{Version: ProtoVersion + 1,
+		Command: CmdHello}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, _, err := ReadMessage(cltConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Command != CmdHello || hdr.Version != ProtoVersion {
+		t.Fatalf("unexpected answer to hello: %+v", hdr)
+	}
+
+	// Die Meldung wird absichtlich in kleinen Stuecken geschrieben, um
+	// fragmentierte TCP-Segmente zu simulieren.
+	frame := testFrame(3*disp.NumLeds(), 1)
+	var msg bytes.Buffer
+	WriteMessage(&msg, ProtoHeader{Version: ProtoVersion, Command: CmdFrame,
+		Seq: 1}, append([]byte{byte(EncRaw)}, frame...))
+	for chunk := range chunks(msg.Bytes(), 7) {
+		cltConn.Write(chunk)
+	}
+	expectFrame(t, disp, frame)
+
+	WriteMessage(cltConn, ProtoHeader{Version: ProtoVersion, Command: CmdBye,
+		Seq: 2}, nil)
+	expectFrame(t, disp, make([]byte, len(frame)))
+	cltConn.Close()
+}
+
+// Ein Client, welcher nur Version 1 kennt, sendet die Bilder ohne
+// Kodierungs-Byte.
+func TestProtocolVersion1(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	srvConn, cltConn := net.Pipe()
+	go server.HandleMessage(srvConn)
+
+	WriteMessage(cltConn, ProtoHeader{Version: 1, Command: CmdHello}, nil)
+	hdr, _, err := ReadMessage(cltConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version != 1 {
+		t.Fatalf("expected version 1, got %d", hdr.Version)
+	}
+	frame := testFrame(3*disp.NumLeds(), 1)
+	WriteMessage(cltConn, ProtoHeader{Version: 1, Command: CmdFrame, Seq: 1}, frame)
+	expectFrame(t, disp, frame)
+	cltConn.Close()
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	srvConn, cltConn := net.Pipe()
+	go server.HandleLegacy(srvConn)
+
+	frame := testFrame(3*disp.NumLeds(), 7)
+	for chunk := range chunks(frame, 100) {
+		cltConn.Write(chunk)
+	}
+	expectFrame(t, disp, frame)
+
+	// Auch ein Bild, welches mit der Magic-Number beginnt, ist fuer alte
+	// Clients nur ein Bild.
+	frame = testFrame(3*disp.NumLeds(), 9)
+	binary.BigEndian.PutUint16(frame, ProtoMagic)
+	cltConn.Write(frame)
+	expectFrame(t, disp, frame)
+	cltConn.Close()
+	expectFrame(t, disp, make([]byte, len(frame)))
+}
+
+// Mehrere Clients senden gleichzeitig; dabei duerfen die Zaehler keine
+// Bytes verlieren (mit -race ausfuehren).
+func TestStatsConcurrent(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	go func() {
+		for range disp.frames {
+		}
+	}()
+
+	const numFrames = 20
+	frame := testFrame(3*disp.NumLeds(), 1)
+	var wg sync.WaitGroup
+	for range 2 {
+		srvConn, cltConn := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			server.HandleLegacy(srvConn)
+			close(done)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range numFrames {
+				cltConn.Write(frame)
+			}
+			cltConn.Close()
+			<-done
+		}()
+	}
+	for range numFrames {
+		server.CompressionRatio()
+	}
+	wg.Wait()
+	if recv := server.RecvBytes(); recv != ByteCount(2*numFrames*len(frame)) {
+		t.Errorf("expected %d bytes received, got %d", 2*numFrames*len(frame), recv)
+	}
+}
+
+// Zerlegt b in Stuecke von hoechstens n Bytes.
+func chunks(b []byte, n int) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(b) > 0 {
+			m := min(n, len(b))
+			if !yield(b[:m]) {
+				return
+			}
+			b = b[m:]
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package ledgrid
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+)
+
+// The Recorder is a headless displayer: instead of sending the data to
+// hardware, it renders every frame into an image, using the geometry of the
+// module configuration. The LEDs are drawn as round spots with an optional
+// glow around them. The frames are passed to a FrameWriter, which stores
+// them as PNG files, as animated GIF or APNG or as raw video data for tools
+// like ffmpeg. Since the rendering is deterministic, the Recorder can also
+// be used to compare animations against reference images in tests.
+type Recorder struct {
+	DisplayEmbed
+	cfg      RecorderConfig
+	coordMap conf.CoordMap
+	margin   int
+	sprite   []uint16
+	img      *image.RGBA
+	out      FrameWriter
+	err      error
+	mutex    sync.Mutex
+}
+
+// Configuration of the rendering. PixelSize is the size of a pixel of the
+// grid in the image, LedSize the diameter of the LEDs relative to it. Glow
+// is the intensity of the halo around the LEDs (0: no halo, 1: as bright
+// as the LED itself); the halo reaches into the neighbouring pixels.
+type RecorderConfig struct {
+	PixelSize  int
+	LedSize    float64
+	Glow       float64
+	Background color.RGBA
+}
+
+// Returns the default configuration of the rendering.
+func DefaultRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		PixelSize:  20,
+		LedSize:    0.8,
+		Glow:       0.3,
+		Background: color.RGBA{0x18, 0x18, 0x18, 0xff},
+	}
+}
+
+// Creates a new Recorder for the module configuration modConf. The rendered
+// frames are passed to out, which may be nil if only the latest frame is of
+// interest (see Image and SavePNG).
+func NewRecorder(modConf conf.ModuleConfig, cfg RecorderConfig, out FrameWriter) *Recorder {
+	r := &Recorder{out: out}
+	if cfg.PixelSize <= 0 {
+		cfg.PixelSize = DefaultRecorderConfig().PixelSize
+	}
+	if cfg.LedSize <= 0 || cfg.LedSize > 1 {
+		cfg.LedSize = DefaultRecorderConfig().LedSize
+	}
+	cfg.Background.A = 0xff
+	r.cfg = cfg
+	r.initSprite()
+	r.DisplayEmbed.Init(r, modConf.NumLeds())
+	r.SetModuleConfig(modConf)
+	return r
+}
+
+// Computes the brightness of a LED and its halo as weights between 0 and
+// 256. The sprite covers the pixel of the LED plus a margin on every side.
+func (r *Recorder) initSprite() {
+	size := float64(r.cfg.PixelSize)
+	r.margin = 1
+	if r.cfg.Glow > 0 {
+		r.margin = r.cfg.PixelSize / 2
+	}
+	side := r.cfg.PixelSize + 2*r.margin
+	center := float64(r.margin) + size/2.0
+	radius := r.cfg.LedSize * size / 2.0
+	r.sprite = make([]uint16, side*side)
+	for y := range side {
+		for x := range side {
+			d := math.Hypot(float64(x)+0.5-center, float64(y)+0.5-center)
+			core := min(max(radius+0.5-d, 0.0), 1.0)
+			glow := r.cfg.Glow
+			if d > radius {
+				glow *= math.Exp(-4.0 * math.Pow((d-radius)/(size/2.0), 2))
+			}
+			w := core + (1.0-core)*min(glow, 1.0)
+			r.sprite[y*side+x] = uint16(math.Round(256.0 * w))
+		}
+	}
+}
+
+// Besides the configuration, the size of the image and the mapping of the
+// LEDs to pixels are updated.
+func (r *Recorder) SetModuleConfig(cnf conf.ModuleConfig) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.DisplayEmbed.SetModuleConfig(cnf)
+	r.coordMap = cnf.CoordMap()
+	size := cnf.Size().Mul(r.cfg.PixelSize)
+	r.img = image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(r.img, r.img.Rect, image.NewUniform(r.cfg.Background),
+		image.Point{}, draw.Src)
+}
+
+// The colors are shown on a computer screen, no gamma correction is needed.
+func (r *Recorder) DefaultGamma() (red, green, blue float64) {
+	return 1.0, 1.0, 1.0
+}
+
+// Renders the frame in buffer and passes it to the FrameWriter. Like on
+// the hardware, missing LEDs receive no data and stay dark.
+func (r *Recorder) Send(buffer []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	draw.Draw(r.img, r.img.Rect, image.NewUniform(r.cfg.Background),
+		image.Point{}, draw.Src)
+	side := r.cfg.PixelSize + 2*r.margin
+	dstIdx := 0
+	for srcIdx, pt := range r.coordMap {
+		if r.statusList[srcIdx] == LedMissing {
+			continue
+		}
+		if 3*dstIdx+3 > len(buffer) {
+			break
+		}
+		c := buffer[3*dstIdx : 3*dstIdx+3 : 3*dstIdx+3]
+		dstIdx++
+		if c[0] == 0 && c[1] == 0 && c[2] == 0 {
+			continue
+		}
+		x0 := pt.X*r.cfg.PixelSize - r.margin
+		y0 := pt.Y*r.cfg.PixelSize - r.margin
+		for y := max(0, -y0); y < side && y0+y < r.img.Rect.Max.Y; y++ {
+			for x := max(0, -x0); x < side && x0+x < r.img.Rect.Max.X; x++ {
+				w := uint32(r.sprite[y*side+x])
+				if w == 0 {
+					continue
+				}
+				pix := r.img.Pix[r.img.PixOffset(x0+x, y0+y):]
+				for i := range 3 {
+					pix[i] = uint8(min(uint32(pix[i])+(uint32(c[i])*w>>8), 255))
+				}
+			}
+		}
+	}
+
+	if r.out != nil && r.err == nil {
+		if r.err = r.out.WriteFrame(r.img); r.err != nil {
+			log.Printf("Couldn't write frame: %v", r.err)
+		}
+	}
+}
+
+// Returns a copy of the latest frame.
+func (r *Recorder) Image() *image.RGBA {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	img := image.NewRGBA(r.img.Rect)
+	copy(img.Pix, r.img.Pix)
+	return img
+}
+
+// Saves the latest frame as PNG file.
+func (r *Recorder) SavePNG(fileName string) error {
+	return savePNG(fileName, r.Image())
+}
+
+// Returns the first error of the FrameWriter. After an error, no further
+// frames are written.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Closes the FrameWriter, which finishes the output (e.g. writes the
+// animated GIF). Errors are available through Err.
+func (r *Recorder) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.out == nil {
+		return
+	}
+	if err := r.out.Close(); err != nil && r.err == nil {
+		r.err = err
+		log.Printf("Couldn't finish recording: %v", err)
+	}
+	r.out = nil
+}
+
+func savePNG(fileName string, img image.Image) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(fh, img); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// A FrameWriter receives the frames rendered by a Recorder. The image
+// passed to WriteFrame is reused for the next frame and must not be kept.
+// Close finishes the output; writers passed to the constructors are not
+// closed.
+type FrameWriter interface {
+	WriteFrame(img *image.RGBA) error
+	Close() error
+}
+
+type pngWriter struct {
+	pattern string
+	num     int
+}
+
+// Writes every frame into a PNG file of its own. The name of the file is
+// built from pattern and the number of the frame (starting with 0), e.g.
+// "frame%04d.png". A pattern without a verb is used as file name for all
+// frames, the file then always contains a snapshot of the latest frame.
+func NewPNGWriter(pattern string) FrameWriter {
+	return &pngWriter{pattern: pattern}
+}
+
+func (w *pngWriter) WriteFrame(img *image.RGBA) error {
+	fileName := w.pattern
+	if strings.Contains(w.pattern, "%") {
+		fileName = fmt.Sprintf(w.pattern, w.num)
+	}
+	w.num++
+	return savePNG(fileName, img)
+}
+
+func (w *pngWriter) Close() error {
+	return nil
+}
+
+type gifWriter struct {
+	w     io.Writer
+	delay int
+	anim  gif.GIF
+}
+
+// Collects the frames and writes them as animated GIF to w when the writer
+// is closed. The frames are shown for delay each (with a resolution of
+// 10ms); identical consecutive frames are merged. The colors are reduced
+// to the Plan9 palette with dithering.
+func NewGIFWriter(w io.Writer, delay time.Duration) FrameWriter {
+	return &gifWriter{w: w, delay: max(int(delay/(10*time.Millisecond)), 1)}
+}
+
+func (w *gifWriter) WriteFrame(img *image.RGBA) error {
+	frame := image.NewPaletted(img.Rect, palette.Plan9)
+	draw.FloydSteinberg.Draw(frame, img.Rect, img, image.Point{})
+	if n := len(w.anim.Image); n > 0 && string(w.anim.Image[n-1].Pix) == string(frame.Pix) {
+		w.anim.Delay[n-1] += w.delay
+		return nil
+	}
+	w.anim.Image = append(w.anim.Image, frame)
+	w.anim.Delay = append(w.anim.Delay, w.delay)
+	return nil
+}
+
+func (w *gifWriter) Close() error {
+	if len(w.anim.Image) == 0 {
+		return nil
+	}
+	return gif.EncodeAll(w.w, &w.anim)
+}
+
+type rawWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Writes the frames as raw video data (3 bytes per pixel, RGB) to w. The
+// output can be piped to ffmpeg, e.g.
+//
+//	ffmpeg -f rawvideo -pix_fmt rgb24 -s 800x200 -r 30 -i - anim.mp4
+//
+// where the size is the one of the images (see Image).
+func NewRawWriter(w io.Writer) FrameWriter {
+	return &rawWriter{w: w}
+}
+
+func (w *rawWriter) WriteFrame(img *image.RGBA) error {
+	size := img.Rect.Size()
+	if len(w.buf) != 3*size.X*size.Y {
+		w.buf = make([]byte, 3*size.X*size.Y)
+	}
+	for i := range size.X * size.Y {
+		copy(w.buf[3*i:3*i+3], img.Pix[4*i:4*i+3])
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+func (w *rawWriter) Close() error {
+	return nil
+}
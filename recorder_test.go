@@ -0,0 +1,102 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	var raw bytes.Buffer
+
+	modConf := testModConf(image.Point{20, 10})
+	cfg := DefaultRecorderConfig()
+	rec := NewRecorder(modConf, cfg, NewRawWriter(&raw))
+	size := rec.Image().Rect.Size()
+	if size != (image.Point{20 * cfg.PixelSize, 10 * cfg.PixelSize}) {
+		t.Fatalf("unexpected image size %v", size)
+	}
+
+	frame := make([]byte, 3*rec.NumLeds())
+	frame[0] = 0xff
+	rec.Display(frame)
+	pt := modConf.Coord(0).Mul(cfg.PixelSize)
+	center := pt.Add(image.Point{cfg.PixelSize / 2, cfg.PixelSize / 2})
+	if c := rec.Image().RGBAAt(center.X, center.Y); c != (color.RGBA{0xff, 0x18, 0x18, 0xff}) {
+		t.Errorf("center of LED: got %v", c)
+	}
+	// Der Schein reicht bis in die Nachbarpixel, aber nicht weiter.
+	if c := rec.Image().RGBAAt(center.X+cfg.PixelSize/2+1, center.Y); c.R <= 0x18 {
+		t.Errorf("no glow: got %v", c)
+	}
+	if c := rec.Image().RGBAAt(center.X+2*cfg.PixelSize, center.Y); c != cfg.Background {
+		t.Errorf("background: got %v", c)
+	}
+
+	// Fehlende LEDs erhalten keine Daten und bleiben dunkel, die folgenden
+	// LEDs zeigen trotzdem ihre eigene Farbe.
+	rec.SetPixelStatus(0, LedMissing)
+	frame[3] = 0xff
+	rec.Display(frame)
+	pt = modConf.Coord(1).Mul(cfg.PixelSize)
+	if c := rec.Image().RGBAAt(pt.X+cfg.PixelSize/2, pt.Y+cfg.PixelSize/2); c.R != 0xff {
+		t.Errorf("LED after missing LED: got %v", c)
+	}
+	if c := rec.Image().RGBAAt(center.X, center.Y); c.R == 0xff {
+		t.Errorf("missing LED is lit: %v", c)
+	}
+	rec.Close()
+	if n := raw.Len(); n != 2*3*size.X*size.Y {
+		t.Errorf("raw output has %d bytes", n)
+	}
+}
+
+func TestRecorderAnimation(t *testing.T) {
+	var gifBuf, apngBuf bytes.Buffer
+
+	modConf := testModConf(image.Point{10, 10})
+	cfg := DefaultRecorderConfig()
+	cfg.PixelSize = 8
+	recGIF := NewRecorder(modConf, cfg, NewGIFWriter(&gifBuf, 40*time.Millisecond))
+	recAPNG := NewRecorder(modConf, cfg, NewAPNGWriter(&apngBuf, 40*time.Millisecond))
+	frame := make([]byte, 3*recGIF.NumLeds())
+	for _, val := range []byte{0x00, 0x80, 0x80} {
+		for i := range frame {
+			frame[i] = val
+		}
+		recGIF.Display(frame)
+		recAPNG.Display(frame)
+	}
+	recGIF.Close()
+	recAPNG.Close()
+	if err := recGIF.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := gif.DecodeAll(&gifBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 || anim.Delay[0] != 4 || anim.Delay[1] != 8 {
+		t.Errorf("GIF: %d frames, delays %v", len(anim.Image), anim.Delay)
+	}
+
+	chunks, err := pngChunks(apngBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := make(map[string]int)
+	for _, chunk := range chunks {
+		count[chunk.typ]++
+	}
+	if count["acTL"] != 1 || count["fcTL"] != 3 || count["fdAT"] < 2 {
+		t.Errorf("APNG chunks: %v", count)
+	}
+	if _, err := png.Decode(bytes.NewReader(apngBuf.Bytes())); err != nil {
+		t.Errorf("APNG isn't a valid PNG: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package ledgrid
+
+import (
+	"math"
+)
+
+// NeoPixels like the SK6812 RGBW have a fourth, white LED. The rest of the
+// library (LedGrid, Canvas, the protocol) stays RGB; the white component is
+// extracted by the displayer right before the data is sent. The color of
+// the white LED, expressed in gamma corrected RGB values, is called the
+// white point: the largest multiple of it which fits into the color of a
+// pixel is shown by the white LED, the remainder by the RGB LEDs. A white
+// point of (255, 255, 255) results in the common min-extraction
+// (W = min(R, G, B)).
+
+// Turns the displayer into an RGBW displayer with the given white point.
+// From now on, 4 bytes per NeoPixel are passed to Send: the three colors
+// in the order of the displayer (see SetColorOrder), followed by the white
+// value. Only displayers whose chips have white LEDs call this method.
+func (d *DisplayEmbed) SetWhitePoint(r, g, b uint8) {
+	if r == 0 && g == 0 && b == 0 {
+		r, g, b = 255, 255, 255
+	}
+	d.whitePt = [3]int{int(r), int(g), int(b)}
+	if d.bytesPerLed != 4 {
+		d.bytesPerLed = 4
+		d.buffer = make([]byte, 4*d.numLeds)
+	}
+}
+
+// Returns the number of bytes per NeoPixel passed to Send (3 for RGB, 4
+// for RGBW displayers).
+func (d *DisplayEmbed) BytesPerLed() int {
+	return d.bytesPerLed
+}
+
+// Computes an approximated white point for a white LED with the given
+// color temperature in Kelvin (e.g. 3000 for warm white, 6500 for cool
+// white). The values are intended as a starting point; for exact colors,
+// the white point should be calibrated against the RGB LEDs.
+func WhitePointFromTemp(kelvin float64) (r, g, b uint8) {
+	var rf, gf, bf float64
+
+	temp := min(max(kelvin, 1000.0), 40000.0) / 100.0
+	if temp <= 66.0 {
+		rf = 255.0
+		gf = 99.4708025861*math.Log(temp) - 161.1195681661
+	} else {
+		rf = 329.698727446 * math.Pow(temp-60.0, -0.1332047592)
+		gf = 288.1221695283 * math.Pow(temp-60.0, -0.0755148492)
+	}
+	switch {
+	case temp >= 66.0:
+		bf = 255.0
+	case temp <= 19.0:
+		bf = 0.0
+	default:
+		bf = 138.5177312231*math.Log(temp-10.0) - 305.0447927307
+	}
+	clamp := func(v float64) uint8 {
+		return uint8(min(max(math.Round(v), 0.0), 255.0))
+	}
+	return clamp(rf), clamp(gf), clamp(bf)
+}
+
+// Removes the white component from the colors in rgb and returns the value
+// for the white LED.
+func (d *DisplayEmbed) extractWhite(rgb *[3]byte) byte {
+	w := 255
+	for c, val := range d.whitePt {
+		if val > 0 {
+			w = min(w, int(rgb[c])*255/val)
+		}
+	}
+	for c, val := range d.whitePt {
+		rgb[c] -= byte(min(int(rgb[c]), (w*val+127)/255))
+	}
+	return byte(w)
+}
@@ -0,0 +1,62 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+	"periph.io/x/conn/v3/spi/spitest"
+)
+
+func TestRGBW(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{10, 10}))
+	disp.SetWhitePoint(255, 255, 255)
+	disp.SetColorOrder(conf.GRB)
+	disp.SetPixelStatus(1, LedMissing)
+	disp.SetPixelStatus(2, LedDefect)
+
+	frame := make([]byte, 3*disp.NumLeds())
+	copy(frame, []byte{0x40, 0x80, 0xff, 0x11, 0x11, 0x11, 0x22, 0x22, 0x22, 0x30, 0x30, 0x30})
+	disp.Display(frame)
+	b := <-disp.frames
+	if len(b) != 4*disp.NumLeds() {
+		t.Fatalf("expected %d bytes, got %d", 4*disp.NumLeds(), len(b))
+	}
+	// Das fehlende Pixel wird uebersprungen, das defekte bleibt dunkel.
+	want := []byte{0x40, 0x00, 0xbf, 0x40, 0, 0, 0, 0, 0, 0, 0, 0x30}
+	if !bytes.Equal(b[:len(want)], want) {
+		t.Errorf("expected %x, got %x", want, b[:len(want)])
+	}
+
+	// Mit einem warmweissen Weisspunkt wird Rot vollstaendig durch die
+	// weisse LED ersetzt, Gruen und Blau bleiben teilweise erhalten.
+	r, g, bl := WhitePointFromTemp(3000)
+	if r != 255 || g <= bl || bl == 0 {
+		t.Errorf("unexpected white point for 3000K: %d, %d, %d", r, g, bl)
+	}
+	disp.SetWhitePoint(r, g, bl)
+	frame[9], frame[10], frame[11] = 0xff, 0xff, 0xff
+	disp.Display(frame)
+	b = <-disp.frames
+	if b[11] != 0xff || b[9] != 0 || b[8] == 0 || b[10] <= b[8] {
+		t.Errorf("warm white: got %x", b[8:12])
+	}
+}
+
+func TestWS2812RGBW(t *testing.T) {
+	port := &spitest.Record{}
+	disp, err := newWS2812(port, DefWS2812Baud, 2048, testModConf(image.Point{10, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disp.SetWhitePoint(255, 255, 255)
+	disp.Display(make([]byte, 3*disp.NumLeds()))
+	var size int
+	for _, op := range port.Ops {
+		size += len(op.W)
+	}
+	if want := 4*3*disp.NumLeds() + disp.resetSize; size != want {
+		t.Errorf("expected %d bytes, got %d", want, size)
+	}
+}
@@ -30,7 +30,12 @@ func (s *Stopwatch) Start() {
 // Stops the stopwatch and updates the internal variables. If the stopwatch is
 // stopped, this method has no effect.
 func (s *Stopwatch) Stop() {
-	d := time.Since(s.t)
+	s.Add(time.Since(s.t))
+}
+
+// Adds a duration which has been measured elsewhere, as if it had been
+// measured by Start() and Stop().
+func (s *Stopwatch) Add(d time.Duration) {
 	if d > s.Max {
 		s.Max = d
 	}
@@ -0,0 +1,287 @@
+package ledgrid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Over UDP, the same header as over TCP is used (see protocol.go), but a
+// frame is split into fragments of at most UDPFragmentSize bytes, each sent
+// in its own datagram with command CmdFragment. The payload of a fragment
+// starts with its index and the total number of fragments of this frame:
+//
+//	+-------+-------+-------+-------+-----------------------------+
+//	| Index (2)     | Count (2)     | Data (<= UDPFragmentSize)   |
+//	+-------+-------+-------+-------+-----------------------------+
+//
+// All fragments of a frame share the sequence number in the header. The
+// server only shows complete frames and drops frames which are older than
+// the last one shown. There's no handshake: the version in the header must
+// simply be supported by the server. A client may send a CmdHello in order
+// to announce its priority (see arbiter.go).
+//
+// As there's no connection, a client which crashes or is restarted
+// never sends a CmdBye. Clients which haven't sent anything for
+// UDPClientTimeout are therefore removed and their source is closed,
+// regardless of the idle timeout of the arbiter.
+const (
+	UDPFragmentSize   = 1400
+	udpFragHeaderSize = 4
+)
+
+// Time after which a silent UDP client is removed.
+var UDPClientTimeout = 10 * time.Second
+
+// Splits frame into datagrams (header, fragment header and data) and
+// passes each of them to send.
+func WriteFragments(frame []byte, version uint8, seq uint32, send func([]byte) error) error {
+	count := max(1, (len(frame)+UDPFragmentSize-1)/UDPFragmentSize)
+	if count > 0xffff {
+		return ErrBadLength
+	}
+	msg := make([]byte, ProtoHeaderSize+udpFragHeaderSize+UDPFragmentSize)
+	for idx := range count {
+		data := frame[idx*UDPFragmentSize : min(len(frame), (idx+1)*UDPFragmentSize)]
+		hdr := ProtoHeader{Version: version, Command: CmdFragment,
+			Length: uint32(udpFragHeaderSize + len(data)), Seq: seq}
+		hdr.Encode(msg)
+		binary.BigEndian.PutUint16(msg[ProtoHeaderSize:], uint16(idx))
+		binary.BigEndian.PutUint16(msg[ProtoHeaderSize+2:], uint16(count))
+		n := copy(msg[ProtoHeaderSize+udpFragHeaderSize:], data)
+		if err := send(msg[:ProtoHeaderSize+udpFragHeaderSize+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collects the fragments of the current frame of one UDP client.
+type udpAssembler struct {
+	seq, shown           uint32
+	assembling, hasShown bool
+	buffer               []byte
+	received             []bool
+	missing, size        int
+	decoder              *FrameDecoder
+	decodedSeq           uint32
+	source               *FrameSource
+	seen                 time.Time
+}
+
+// The complete frames of the UDP clients, waiting to be shown. Only the
+// latest frame of every source is kept, a newer frame of the same source
+// replaces it. Frames of other sources are never dropped.
+type udpFrames struct {
+	mutex   sync.Mutex
+	pending map[*FrameSource][]byte
+	ready   chan struct{}
+}
+
+// Adds a fragment to the frame. A fragment of a newer frame discards the
+// incomplete current one, fragments of older frames are ignored. Returns the
+// frame as soon as it is complete, nil otherwise.
+func (a *udpAssembler) add(seq uint32, payload []byte) ([]byte, error) {
+	if len(payload) < udpFragHeaderSize {
+		return nil, ErrBadLength
+	}
+	idx := int(binary.BigEndian.Uint16(payload[0:2]))
+	count := int(binary.BigEndian.Uint16(payload[2:4]))
+	data := payload[udpFragHeaderSize:]
+	if idx >= count || idx*UDPFragmentSize+len(data) > len(a.buffer) {
+		return nil, fmt.Errorf("%w: fragment %d/%d", ErrBadLength, idx, count)
+	}
+	if (a.hasShown && int32(seq-a.shown) <= 0) ||
+		(a.assembling && int32(seq-a.seq) < 0) {
+		return nil, nil
+	}
+	if !a.assembling || seq != a.seq {
+		a.seq = seq
+		a.assembling = true
+		a.received = slices.Grow(a.received[:0], count)[:count]
+		clear(a.received)
+		a.missing = count
+		a.size = 0
+	}
+	if len(a.received) != count || a.received[idx] {
+		return nil, nil
+	}
+	a.received[idx] = true
+	a.missing--
+	a.size += copy(a.buffer[idx*UDPFragmentSize:], data)
+	if a.missing > 0 {
+		return nil, nil
+	}
+	a.assembling = false
+	a.hasShown = true
+	a.shown = seq
+	return a.buffer[:a.size], nil
+}
+
+// Opens the UDP port for the data connection. The port number is the same
+// as for the framed protocol over TCP.
+func (p *GridServer) listenUDP() error {
+	var err error
+
+	addr := p.dataListener.Addr().(*net.TCPAddr)
+	p.udpConn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: addr.IP, Port: addr.Port})
+	return err
+}
+
+// Receives the datagrams of all UDP clients. Complete frames are passed to
+// a separate goroutine. If this goroutine is still busy with the previous
+// frames, a waiting frame of the same source is replaced by the newer one
+// (see udpFrames). This way, the latency stays low, even if the displayer
+// is slower than the client.
+func (p *GridServer) HandleUDP(conn *net.UDPConn) {
+	var hdr ProtoHeader
+	var clients map[string]*udpAssembler
+	var frames *udpFrames
+
+	buffer := make([]byte, 1<<16)
+	clients = make(map[string]*udpAssembler)
+	frames = &udpFrames{pending: make(map[*FrameSource][]byte),
+		ready: make(chan struct{}, 1)}
+	defer func() {
+		for _, asm := range clients {
+			asm.source.Close()
+		}
+	}()
+	defer close(frames.ready)
+	go p.showUDPFrames(frames)
+
+	// The read deadline makes sure, that silent clients are removed even
+	// if no datagrams arrive at all.
+	pruneTime := time.Now().Add(UDPClientTimeout / 4)
+	for {
+		conn.SetReadDeadline(pruneTime)
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if now := time.Now(); !now.Before(pruneTime) {
+			pruneUDPClients(clients, now)
+			pruneTime = now.Add(UDPClientTimeout / 4)
+		}
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			log.Printf("Failed UDP Read(): %v", err)
+			continue
+		}
+		if n < ProtoHeaderSize {
+			continue
+		}
+		if err = hdr.Decode(buffer[:n]); err != nil || hdr.Version == 0 ||
+			hdr.Version > ProtoVersion ||
+			int(hdr.Length) != n-ProtoHeaderSize {
+			continue
+		}
+		p.countRecv(n)
+		payload := buffer[ProtoHeaderSize:n]
+		key := addr.String()
+		switch hdr.Command {
+		case CmdHello:
+			if len(payload) > 0 {
+				p.udpClient(clients, key).source.SetPriority(Priority(payload[0]))
+			}
+		case CmdFragment:
+			asm := p.udpClient(clients, key)
+			frame, err := asm.add(hdr.Seq, payload)
+			if err != nil {
+				log.Printf("Bad fragment from %v: %v", addr, err)
+				continue
+			}
+			if frame == nil {
+				continue
+			}
+			encSize := len(frame)
+			if hdr.Version >= 2 {
+				// A delta frame can only be decoded if the frame right
+				// before it has been received.
+				if hdr.Seq != asm.decodedSeq+1 {
+					asm.decoder.Invalidate()
+				}
+				frame, err = asm.decoder.Decode(frame)
+				if err != nil {
+					if !errors.Is(err, ErrNoKeyframe) {
+						log.Printf("Bad frame from %v: %v", addr, err)
+					}
+					continue
+				}
+				asm.decodedSeq = hdr.Seq
+			}
+			if len(frame) != p.bufferSize {
+				continue
+			}
+			p.countDecoded(encSize, len(frame))
+			frames.offer(asm.source, frame)
+		case CmdBye:
+			if asm, ok := clients[key]; ok {
+				asm.source.Close()
+				delete(clients, key)
+			}
+		}
+	}
+}
+
+// Returns the assembler of the client with address key. New clients are
+// registered as a source with the default priority.
+func (p *GridServer) udpClient(clients map[string]*udpAssembler, key string) *udpAssembler {
+	asm, ok := clients[key]
+	if !ok {
+		asm = &udpAssembler{buffer: make([]byte, p.bufferSize+1),
+			decoder: NewFrameDecoder(p.bufferSize),
+			source:  p.OpenSource("udp:"+key, DefPriority)}
+		clients[key] = asm
+	}
+	asm.seen = time.Now()
+	return asm
+}
+
+// Removes the clients which haven't sent anything for UDPClientTimeout and
+// closes their source.
+func pruneUDPClients(clients map[string]*udpAssembler, now time.Time) {
+	for key, asm := range clients {
+		if now.Sub(asm.seen) >= UDPClientTimeout {
+			asm.source.Close()
+			delete(clients, key)
+		}
+	}
+}
+
+// Stores a copy of frame as the latest frame of src without blocking. A
+// frame of src still waiting is outdated and will be replaced.
+func (f *udpFrames) offer(src *FrameSource, frame []byte) {
+	f.mutex.Lock()
+	f.pending[src] = append([]byte(nil), frame...)
+	f.mutex.Unlock()
+	select {
+	case f.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the waiting frames and empties the list.
+func (f *udpFrames) take() map[*FrameSource][]byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	pending := f.pending
+	f.pending = make(map[*FrameSource][]byte)
+	return pending
+}
+
+func (p *GridServer) showUDPFrames(frames *udpFrames) {
+	for range frames.ready {
+		for src, frame := range frames.take() {
+			src.Display(frame)
+		}
+	}
+}
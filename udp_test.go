@@ -0,0 +1,161 @@
+package ledgrid
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPTransport(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{40, 40}))
+	server := newTestServer(disp)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.HandleUDP(conn)
+	defer conn.Close()
+
+	port := uint(conn.LocalAddr().(*net.UDPAddr).Port)
+	client, err := NewNetGridClient("127.0.0.1", "udp", port, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := testFrame(3*disp.NumLeds(), 11)
+	if err = client.Send(frame); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, disp, frame)
+	client.Close()
+	expectFrame(t, disp, make([]byte, len(frame)))
+}
+
+// Ein Client, welcher ohne CmdBye verschwindet, wird nach UDPClientTimeout
+// entfernt; die Quelle mit tieferer Prioritaet wird wieder angezeigt.
+func TestUDPClientTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { UDPClientTimeout = timeout }(UDPClientTimeout)
+	UDPClientTimeout = 100 * time.Millisecond
+
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	low := server.OpenSource("low", DefPriority-1)
+	frameLow := testFrame(3*disp.NumLeds(), 5)
+	low.Display(frameLow)
+	expectFrame(t, disp, frameLow)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.HandleUDP(conn)
+	defer conn.Close()
+	cltConn, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cltConn.Close()
+
+	frame := testFrame(3*disp.NumLeds(), 11)
+	WriteFragments(append([]byte{byte(EncRaw)}, frame...), ProtoVersion, 1,
+		func(b []byte) error {
+			_, err := cltConn.Write(b)
+			return err
+		})
+	expectFrame(t, disp, frame)
+	expectFrame(t, disp, frameLow)
+	if srcList := server.Sources(); len(srcList) != 1 {
+		t.Errorf("expected only one source, got %+v", srcList)
+	}
+}
+
+// Eintraege fuer Absender, welche nichts mehr senden (bspw. gefaelschte
+// oder kurzlebige Ports), duerfen sich nicht ansammeln.
+func TestPruneUDPClients(t *testing.T) {
+	disp := newTestDisplayer(testModConf(image.Point{20, 10}))
+	server := newTestServer(disp)
+	clients := make(map[string]*udpAssembler)
+	for port := range 100 {
+		server.udpClient(clients, fmt.Sprintf("10.0.0.1:%d", 1000+port))
+	}
+	now := time.Now()
+	pruneUDPClients(clients, now.Add(UDPClientTimeout/2))
+	if len(clients) != 100 {
+		t.Fatalf("active clients removed, %d left", len(clients))
+	}
+	pruneUDPClients(clients, now.Add(UDPClientTimeout))
+	if len(clients) != 0 || len(server.Sources()) != 0 {
+		t.Errorf("stale clients not removed: %d clients, %d sources",
+			len(clients), len(server.Sources()))
+	}
+}
+
+// Pro Quelle wird nur der neuste Frame behalten, die Frames anderer Quellen
+// bleiben erhalten.
+func TestUDPFrames(t *testing.T) {
+	srcA, srcB := &FrameSource{name: "A"}, &FrameSource{name: "B"}
+	frames := &udpFrames{pending: make(map[*FrameSource][]byte),
+		ready: make(chan struct{}, 1)}
+	frames.offer(srcA, []byte{1})
+	frames.offer(srcB, []byte{2})
+	frames.offer(srcA, []byte{3})
+	pending := frames.take()
+	if len(pending) != 2 || pending[srcA][0] != 3 || pending[srcB][0] != 2 {
+		t.Errorf("unexpected pending frames %v", pending)
+	}
+	if len(frames.take()) != 0 {
+		t.Errorf("pending frames not removed")
+	}
+}
+
+// Erstellt die Fragmente eines Frames mit der Sequenznummer seq.
+func fragments(frame []byte, seq uint32) [][]byte {
+	var fragList [][]byte
+
+	WriteFragments(frame, ProtoVersion, seq, func(b []byte) error {
+		fragList = append(fragList, append([]byte(nil), b[ProtoHeaderSize:]...))
+		return nil
+	})
+	return fragList
+}
+
+func TestUDPAssembler(t *testing.T) {
+	asm := &udpAssembler{buffer: make([]byte, 4000)}
+	frameA := testFrame(4000, 1)
+	frameB := testFrame(4000, 2)
+	fragA := fragments(frameA, 10)
+	fragB := fragments(frameB, 11)
+	if len(fragA) != 3 {
+		t.Fatalf("expected 3 fragments, got %d", len(fragA))
+	}
+	if idx := binary.BigEndian.Uint16(fragA[2][0:2]); idx != 2 {
+		t.Fatalf("expected fragment index 2, got %d", idx)
+	}
+
+	// Frame A ist unvollstaendig, wenn der erste Teil von Frame B eintrifft.
+	// Die restlichen Teile von A muessen danach verworfen werden.
+	for _, f := range []struct {
+		seq uint32
+		pkt []byte
+	}{{10, fragA[0]}, {10, fragA[1]}, {11, fragB[0]}, {10, fragA[2]}, {11, fragB[2]}} {
+		if frame, _ := asm.add(f.seq, f.pkt); frame != nil {
+			t.Fatalf("incomplete frame %d returned", f.seq)
+		}
+	}
+	frame, err := asm.add(11, fragB[1])
+	if err != nil || frame == nil {
+		t.Fatalf("frame B not complete: %v", err)
+	}
+	if string(frame) != string(frameB) {
+		t.Errorf("frame B differs")
+	}
+
+	// Ein vollstaendiger, aber aelterer Frame wird nicht mehr angezeigt.
+	for _, pkt := range fragA {
+		if frame, _ := asm.add(10, pkt); frame != nil {
+			t.Errorf("outdated frame returned")
+		}
+	}
+}
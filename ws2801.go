@@ -1,8 +1,10 @@
 package ledgrid
 
 import (
-	"github.com/stefan-muehlebach/ledgrid/conf"
+	"fmt"
 	"log"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
 	"time"
 
 	"periph.io/x/conn/v3/physic"
@@ -23,21 +25,23 @@ type WS2801 struct {
 
 // Erstellt eine neue Instanz. spiDev ist das Device-File des SPI-Buses, baud
 // die Taktrate (in Bit pro Sekunde) und numLeds die Anzahl NeoPixel auf der
-// Lichterkette - ohne die entfernten NeoPixel zu beruecksichtigen.
-func NewWS2801(spiDev string, baud int, modConf conf.ModuleConfig) *WS2801 {
+// Lichterkette - ohne die entfernten NeoPixel zu beruecksichtigen. Fehler
+// beim Oeffnen des SPI-Buses enthalten ErrDevice.
+func NewWS2801(spiDev string, baud int, modConf conf.ModuleConfig) (*WS2801, error) {
 	var err error
 	p := &WS2801{}
 
-	p.DisplayEmbed.Init(p, len(modConf)*conf.ModuleDim.X*conf.ModuleDim.Y)
+	p.DisplayEmbed.Init(p, modConf.NumLeds())
 	p.SetModuleConfig(modConf)
+	p.SetColorOrder(conf.RGB)
 	_, err = host.Init()
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %w", ErrDevice, err)
 	}
 
 	spiFs, err := sysfs.NewSPI(0, 0)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %w", ErrDevice, err)
 	}
 	p.maxTxSize = spiFs.MaxTxSize()
 	log.Printf("SPI bus has a max transport size of %d bytes", p.maxTxSize)
@@ -45,15 +49,16 @@ func NewWS2801(spiDev string, baud int, modConf conf.ModuleConfig) *WS2801 {
 
 	p.spiPort, err = spireg.Open(spiDev)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
 	}
 	p.spiConn, err = p.spiPort.Connect(physic.Frequency(baud)*physic.Hertz,
 		spi.Mode0, 8)
 	if err != nil {
-		log.Fatal(err)
+		p.spiPort.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
 	}
 
-	return p
+	return p, nil
 }
 
 // Diese Methode gehoert zum Displayer-Interface und retourniert die
@@ -71,6 +76,8 @@ func (p *WS2801) Close() {
 // Reihenfolge der Pixel muss bereits vorgaengig der effektiven Verkabelung
 // angepasst worden sein, ebenso die Farbwertkorrektur. Diese Methode wird
 // ueblicherweise vom DisplayEmbed und nicht von Benutzercode aufgerufen.
+// Fehler beim Senden werden protokolliert; das naechste Bild wird wieder
+// normal gesendet.
 func (p *WS2801) Send(buffer []byte) {
 	var err error
 	var bufferSize int
@@ -79,7 +86,8 @@ func (p *WS2801) Send(buffer []byte) {
 	for idx := 0; idx < bufferSize; idx += p.maxTxSize {
 		txSize := min(p.maxTxSize, bufferSize-idx)
 		if err = p.spiConn.Tx(buffer[idx:idx+txSize:idx+txSize], nil); err != nil {
-			log.Fatalf("Couldn't send data: %v", err)
+			log.Printf("Couldn't send data: %v", err)
+			return
 		}
 	}
 	time.Sleep(500 * time.Microsecond)
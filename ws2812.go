@@ -0,0 +1,203 @@
+package ledgrid
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/stefan-muehlebach/ledgrid/conf"
+
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/conn/v3/spi"
+	"periph.io/x/conn/v3/spi/spireg"
+	"periph.io/x/host/v3"
+)
+
+// Im Gegensatz zum WS2801 haben NeoPixel mit WS2812B oder SK6812 keine
+// separate Taktleitung. Die Bits werden ueber die Dauer des High-Pegels
+// kodiert (ca. 0.4 us fuer eine 0 und 0.8 us fuer eine 1 bei einer
+// Bitdauer von 1.25 us). Diese Signalform wird hier mit dem SPI-Bus erzeugt:
+// jedes Datenbit wird durch 3 (bei ca. 2.4 MHz) oder 4 (bei ca. 3.2 MHz)
+// SPI-Bits dargestellt, von denen das erste (bei einer 0), resp. die ersten
+// zwei oder drei (bei einer 1) gesetzt sind. Verwendet wird nur die
+// MOSI-Leitung.
+const (
+	DefWS2812Baud = 2_400_000
+	MinWS2812Baud = 2_200_000
+	MaxWS2812Baud = 3_400_000
+)
+
+// Standardgroesse eines SPI-Transfers von spidev (siehe Parameter bufsiz des
+// Kernel-Moduls).
+const defSPIMaxTxSize = 4096
+
+// Bis zu dieser Taktrate werden 3 SPI-Bits pro Datenbit verwendet, darueber
+// deren 4.
+const ws2812Baud4Bit = 2_800_000
+
+// Damit die NeoPixel die empfangenen Farbwerte uebernehmen, muss die
+// Datenleitung nach einem Bild mindestens so lange auf tiefem Pegel bleiben
+// (neuere WS2812B brauchen 280 us, SK6812 80 us).
+const ws2812ResetTime = 300
+
+// Dies ist die Implementation eines Displayers fuer NeoPixel mit WS2812B
+// oder SK6812, welche ueber den SPI-Bus eines RaspberryPi angesteuert
+// werden. Die Farbwerte werden standardmaessig in der Reihenfolge Gruen, Rot,
+// Blau gesendet (siehe SetColorOrder). Fuer SK6812 RGBW muss zusaetzlich
+// SetWhitePoint aufgerufen werden, der Weiss-Anteil folgt dann als viertes
+// Byte.
+type WS2812 struct {
+	DisplayEmbed
+	spiPort    spi.PortCloser
+	spiConn    spi.Conn
+	maxTxSize  int
+	bitWidth   int
+	encTbl     [256]uint32
+	resetSize  int
+	txBuffer   []byte
+	sizeLogged bool
+}
+
+// Erstellt eine neue Instanz. spiDev ist das Device-File des SPI-Buses, baud
+// die Taktrate (zwischen MinWS2812Baud und MaxWS2812Baud, siehe auch
+// DefWS2812Baud) und modConf die Modul-Konfiguration. Fehler beim Oeffnen
+// des SPI-Buses, ungueltige Taktraten und Bilder, welche nicht in einem
+// einzigen Transfer Platz haben (siehe Send), enthalten ErrDevice.
+func NewWS2812(spiDev string, baud int, modConf conf.ModuleConfig) (*WS2812, error) {
+	var err error
+	var maxTxSize int
+
+	_, err = host.Init()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDevice, err)
+	}
+
+	spiPort, err := spireg.Open(spiDev)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
+	}
+	// Die maximale Groesse eines Transfers wird beim tatsaechlich
+	// geoeffneten Port abgefragt; Ports ohne diese Angabe erhalten die
+	// Standardgroesse von spidev.
+	maxTxSize = defSPIMaxTxSize
+	if port, ok := spiPort.(interface{ MaxTxSize() int }); ok {
+		maxTxSize = port.MaxTxSize()
+	}
+	p, err := newWS2812(spiPort, baud, maxTxSize, modConf)
+	if err != nil {
+		spiPort.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrDevice, spiDev, err)
+	}
+	return p, nil
+}
+
+// Erstellt den Displayer auf einem bereits geoeffneten SPI-Port. Damit kann
+// in den Tests ein simulierter Port verwendet werden.
+func newWS2812(spiPort spi.PortCloser, baud, maxTxSize int, modConf conf.ModuleConfig) (*WS2812, error) {
+	var err error
+
+	if baud < MinWS2812Baud || baud > MaxWS2812Baud {
+		return nil, fmt.Errorf("baud rate %d not in range %d..%d", baud,
+			MinWS2812Baud, MaxWS2812Baud)
+	}
+	p := &WS2812{spiPort: spiPort, maxTxSize: maxTxSize}
+	p.DisplayEmbed.Init(p, modConf.NumLeds())
+	p.SetModuleConfig(modConf)
+	p.SetColorOrder(conf.GRB)
+
+	p.bitWidth = 3
+	if baud > ws2812Baud4Bit {
+		p.bitWidth = 4
+	}
+	for i := range p.encTbl {
+		p.encTbl[i] = ws2812EncodeByte(byte(i), p.bitWidth)
+	}
+	p.resetSize = (ws2812ResetTime*baud/1_000_000 + 7) / 8
+	p.txBuffer = make([]byte, 3*p.bitWidth*p.NumLeds()+p.resetSize)
+	if len(p.txBuffer) > maxTxSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the max transfer size of %d bytes (see spidev.bufsiz)",
+			len(p.txBuffer), maxTxSize)
+	}
+
+	p.spiConn, err = p.spiPort.Connect(physic.Frequency(baud)*physic.Hertz,
+		spi.Mode0, 8)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Kodiert ein Datenbyte in 8*bitWidth SPI-Bits; das hoechstwertige Bit wird
+// zuerst gesendet.
+func ws2812EncodeByte(b byte, bitWidth int) uint32 {
+	var code uint32
+
+	for bit := 7; bit >= 0; bit-- {
+		code <<= bitWidth
+		if b&(1<<bit) != 0 {
+			code |= (1<<(bitWidth-1) - 1) << 1
+		} else {
+			code |= 1 << (bitWidth - 1)
+		}
+	}
+	return code
+}
+
+// Diese Methode gehoert zum Displayer-Interface und retourniert die
+// empfohlenen Gamma-Werte fuer die drei Farbkanaele Rot, Gruen und Blau.
+func (p *WS2812) DefaultGamma() (r, g, b float64) {
+	return 2.5, 2.5, 2.5
+}
+
+// Schliesst den Displayer, in diesem Fall den SPI-Port.
+func (p *WS2812) Close() {
+	p.spiPort.Close()
+}
+
+// Wandelt die Farbwerte in buffer (bereits in der Reihenfolge der Module)
+// in die SPI-Bitfolge um. Bei RGBW-Pixeln (SK6812 RGBW, siehe
+// SetWhitePoint) enthaelt buffer 4 Bytes pro Pixel, der Sendepuffer wird
+// in diesem Fall beim ersten Aufruf vergroessert. Die Bytes am Ende des
+// Puffers bleiben 0 und bilden die Pause, mit welcher die NeoPixel die
+// Farbwerte uebernehmen.
+func (p *WS2812) encode(buffer []byte) []byte {
+	var dst []byte
+	var tmp [4]byte
+
+	if size := p.bitWidth*len(buffer) + p.resetSize; len(p.txBuffer) != size {
+		p.txBuffer = make([]byte, size)
+	}
+	dst = p.txBuffer[:0]
+	for _, b := range buffer {
+		binary.BigEndian.PutUint32(tmp[:], p.encTbl[b]<<(32-8*p.bitWidth))
+		dst = append(dst, tmp[:p.bitWidth]...)
+	}
+	return p.txBuffer
+}
+
+// Sendet die Farbwerte in buffer via SPI-Bus zur NeoPixel Lichterkette. Die
+// Reihenfolge der Pixel muss bereits vorgaengig der effektiven Verkabelung
+// angepasst worden sein, ebenso die Farbwertkorrektur. Diese Methode wird
+// ueblicherweise vom DisplayEmbed und nicht von Benutzercode aufgerufen.
+//
+// Das ganze Bild wird in einem einzigen Transfer gesendet: eine Pause
+// zwischen zwei Transfers kann laenger als die Reset-Zeit der NeoPixel
+// sein, womit diese die Farbwerte mitten im Bild uebernehmen wuerden. Bei
+// grossen Panels muss die maximale Groesse mit dem Parameter bufsiz des
+// Kernel-Moduls spidev erhoeht werden (bspw. spidev.bufsiz=65536 in
+// /boot/cmdline.txt). Ist das Bild trotzdem zu gross (bspw. nach
+// SetWhitePoint), wird es nicht gesendet.
+func (p *WS2812) Send(buffer []byte) {
+	txBuffer := p.encode(buffer)
+	if len(txBuffer) > p.maxTxSize {
+		if !p.sizeLogged {
+			log.Printf("Couldn't send data: frame of %d bytes exceeds the max transfer size of %d bytes",
+				len(txBuffer), p.maxTxSize)
+			p.sizeLogged = true
+		}
+		return
+	}
+	if err := p.spiConn.Tx(txBuffer, nil); err != nil {
+		log.Printf("Couldn't send data: %v", err)
+	}
+}
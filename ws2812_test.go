@@ -0,0 +1,62 @@
+package ledgrid
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"periph.io/x/conn/v3/spi/spitest"
+)
+
+func TestWS2812Encoding(t *testing.T) {
+	for _, tc := range []struct {
+		baud     int
+		bitWidth int
+		code     uint32
+	}{
+		// 0xa5 = 1010 0101
+		{DefWS2812Baud, 3, 0b110_100_110_100_100_110_100_110},
+		{3_200_000, 4, 0b1110_1000_1110_1000_1000_1110_1000_1110},
+	} {
+		port := &spitest.Record{}
+		disp, err := newWS2812(port, tc.baud, 4096, testModConf(image.Point{10, 10}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disp.bitWidth != tc.bitWidth || disp.encTbl[0xa5] != tc.code {
+			t.Errorf("%d baud: got %d bits, code %b", tc.baud, disp.bitWidth, disp.encTbl[0xa5])
+		}
+
+		// Alle Farbwerte werden in der Reihenfolge G, R, B kodiert, am
+		// Ende folgt die Pause aus lauter 0-Bytes.
+		disp.SetGamma(1.0, 1.0, 1.0)
+		frame := make([]byte, 3*disp.NumLeds())
+		frame[0], frame[1], frame[2] = 0x00, 0xff, 0xa5
+		disp.Display(frame)
+		// Das ganze Bild wird in einem Transfer gesendet, damit die
+		// NeoPixel nicht mitten im Bild die Farbwerte uebernehmen.
+		if len(port.Ops) != 1 {
+			t.Fatalf("%d baud: expected 1 transfer, got %d", tc.baud, len(port.Ops))
+		}
+		sent := port.Ops[0].W
+		enc := func(b byte) []byte {
+			code := disp.encTbl[b] << (32 - 8*tc.bitWidth)
+			return []byte{byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code)}[:tc.bitWidth]
+		}
+		want := append(append(enc(0xff), enc(0x00)...), enc(0xa5)...)
+		if !bytes.Equal(sent[:len(want)], want) {
+			t.Errorf("%d baud: first pixel encoded as %x, expected %x", tc.baud, sent[:len(want)], want)
+		}
+		resetSize := (300*tc.baud/1_000_000 + 7) / 8
+		if len(sent) != 3*tc.bitWidth*disp.NumLeds()+resetSize ||
+			!bytes.Equal(sent[len(sent)-resetSize:], make([]byte, resetSize)) {
+			t.Errorf("%d baud: missing reset gap", tc.baud)
+		}
+	}
+	if _, err := newWS2812(&spitest.Record{}, 1_000_000, 4096, testModConf(image.Point{10, 10})); err == nil {
+		t.Error("expected error for baud rate 1 MHz")
+	}
+	if _, err := newWS2812(&spitest.Record{}, DefWS2812Baud, 64, testModConf(image.Point{10, 10})); err == nil {
+		t.Error("expected error for a frame larger than the max transfer size")
+	}
+}